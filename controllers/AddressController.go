@@ -2,27 +2,40 @@ package controllers
 
 import (
 	"blog-auth-server/models"
+	"blog-auth-server/utils"
 	"context"
+	"fmt"
 	"log"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// 每个用户最多保存的地址数
+const maxAddressesPerUser = 20
+
 type AddressController struct {
 	addressCollection *mongo.Collection
+	orderCollection   *mongo.Collection // 删除地址前检查未发货订单
 	ctx               context.Context
 }
 
 // NewCartController 构造函数
-func NewAddressController(addressCollection *mongo.Collection, ctx context.Context) *AddressController {
-	return &AddressController{
+func NewAddressController(addressCollection, orderCollection *mongo.Collection, ctx context.Context) *AddressController {
+	ac := &AddressController{
 		addressCollection: addressCollection,
+		orderCollection:   orderCollection,
 		ctx:               ctx,
 	}
+	// 将旧的文本地址迁移为行政区划代码
+	go ac.startLegacyMigration()
+	return ac
 }
 
 // AddAddress 添加新地址
@@ -49,6 +62,9 @@ func (ac *AddressController) AddAddress(c *fiber.Ctx) error {
 	if err := c.BodyParser(&newAddressItem); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid address data"})
 	}
+	if err := normalizeAddressItem(&newAddressItem); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 	newAddressItem.ID = primitive.NewObjectID()
 
 	// 检查用户是否已有地址记录
@@ -56,7 +72,8 @@ func (ac *AddressController) AddAddress(c *fiber.Ctx) error {
 	err = ac.addressCollection.FindOne(ac.ctx, bson.M{"user_ref": userID}).Decode(&existingAddress)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			// 用户没有地址记录，创建新的
+			// 用户没有地址记录，创建新的，第一个地址即为默认地址
+			newAddressItem.IsDefault = true
 			newAddress := models.Address{
 				ID:             primitive.NewObjectID(),
 				UserRef:        userID,
@@ -67,12 +84,25 @@ func (ac *AddressController) AddAddress(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check existing address"})
 		}
 	} else {
-		// 用户已有地址记录，添加新地址项
-		_, err = ac.addressCollection.UpdateOne(
+		if len(existingAddress.AddressDetails) >= maxAddressesPerUser {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("最多保存 %d 个地址", maxAddressesPerUser)})
+		}
+		if findDefaultAddress(existingAddress.AddressDetails) == nil {
+			newAddressItem.IsDefault = true
+		}
+		// 用户已有地址记录，添加新地址项，设为默认时同时取消其他地址的默认
+		var result *mongo.UpdateResult
+		result, err = ac.addressCollection.UpdateOne(
 			ac.ctx,
-			bson.M{"user_ref": userID},
-			bson.M{"$push": bson.M{"address_detail": newAddressItem}},
+			bson.M{
+				"user_ref": userID,
+				fmt.Sprintf("address_detail.%d", maxAddressesPerUser-1): bson.M{"$exists": false},
+			},
+			addAddressPipeline(newAddressItem),
 		)
+		if err == nil && result.MatchedCount == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("最多保存 %d 个地址", maxAddressesPerUser)})
+		}
 	}
 
 	if err != nil {
@@ -134,18 +164,41 @@ func (ac *AddressController) DelAddress(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid address ID"})
 	}
 
-	result, err := ac.addressCollection.UpdateOne(
+	// 有待发货订单仍发往该地址时不能删除
+	pending, err := ac.orderCollection.CountDocuments(ac.ctx, bson.M{
+		"user_ref": userID,
+		"items": bson.M{"$elemMatch": bson.M{
+			"address_item_ref": addressID,
+			"shipping_status":  models.ShippingPending,
+		}},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check orders"})
+	}
+	if pending > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "该地址有未发货的订单，暂不能删除"})
+	}
+
+	var address models.Address
+	err = ac.addressCollection.FindOneAndUpdate(
 		ac.ctx,
-		bson.M{"user_ref": userID},
+		bson.M{"user_ref": userID, "address_detail._id": addressID},
 		bson.M{"$pull": bson.M{"address_detail": bson.M{"_id": addressID}}},
-	)
-
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&address)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Address not found"})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete address"})
 	}
 
-	if result.ModifiedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Address not found"})
+	// 删除的是默认地址时，将剩余的第一个地址设为默认
+	if len(address.AddressDetails) > 0 && findDefaultAddress(address.AddressDetails) == nil {
+		if _, err := ac.addressCollection.UpdateOne(ac.ctx, bson.M{"_id": address.ID},
+			setDefaultPipeline(address.AddressDetails[0].ID)); err != nil {
+			log.Printf("设置默认地址失败: %v", err)
+		}
 	}
 
 	return c.JSON(fiber.Map{"message": "Address deleted successfully"})
@@ -178,6 +231,16 @@ func (ac *AddressController) UpdateAddress(c *fiber.Ctx) error {
 	if err := c.BodyParser(&updatedAddress); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid address data"})
 	}
+	if err := normalizeAddressItem(&updatedAddress); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	// is_default 未传时保持原来的默认状态
+	var flags struct {
+		IsDefault *bool `json:"is_default"`
+	}
+	if err := c.BodyParser(&flags); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid address data"})
+	}
 
 	updatedAddress.ID = addressID
 
@@ -187,19 +250,56 @@ func (ac *AddressController) UpdateAddress(c *fiber.Ctx) error {
 			"user_ref":           userID,
 			"address_detail._id": addressID,
 		},
-		bson.M{"$set": bson.M{"address_detail.$": updatedAddress}},
+		updateAddressPipeline(updatedAddress, flags.IsDefault),
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update address"})
 	}
 
-	if result.ModifiedCount == 0 {
+	if result.MatchedCount == 0 {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Address not found"})
 	}
 
 	return c.JSON(fiber.Map{"message": "Address updated successfully"})
 }
 
+// SetDefaultAddress 将指定地址设为默认地址，同时取消其他地址的默认
+func (ac *AddressController) SetDefaultAddress(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
+	}
+
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User ID not found in claims"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	addressID, err := primitive.ObjectIDFromHex(c.Params("addressID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid address ID"})
+	}
+
+	result, err := ac.addressCollection.UpdateOne(
+		ac.ctx,
+		bson.M{"user_ref": userID, "address_detail._id": addressID},
+		setDefaultPipeline(addressID),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to set default address"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Address not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Default address updated successfully"})
+}
+
 // 用户通过ID获取地址
 func (ac *AddressController) FromIDGetAddress(c *fiber.Ctx) error {
 	claims, ok := c.Locals("claims").(jwt.MapClaims)
@@ -287,3 +387,273 @@ func (ac *AddressController) GetAddressByID(c *fiber.Ctx) error {
 	log.Printf("Successfully retrieved address for ID: %s", addressIDStr)
 	return c.JSON(targetAddress)
 }
+
+// 按地址项ID批量查询地址，返回地址项ID到地址项的映射，已删除的地址不在结果中
+func findAddressItems(ctx context.Context, addressCollection *mongo.Collection, ids []primitive.ObjectID) (map[primitive.ObjectID]*models.AddressItem, error) {
+	items := make(map[primitive.ObjectID]*models.AddressItem, len(ids))
+	if len(ids) == 0 {
+		return items, nil
+	}
+	wanted := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	cursor, err := addressCollection.Find(ctx, bson.M{"address_detail._id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("查询地址失败: %v", err)
+	}
+	var list []models.Address
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("解析地址失败: %v", err)
+	}
+	for _, address := range list {
+		for i := range address.AddressDetails {
+			item := address.AddressDetails[i]
+			if wanted[item.ID] {
+				items[item.ID] = &item
+			}
+		}
+	}
+	return items, nil
+}
+
+// 校验并规范化地址：省市区按代码校验并填入名称，清除旧版的文本字段
+func normalizeAddressItem(item *models.AddressItem) error {
+	item.RecipientName = strings.TrimSpace(item.RecipientName)
+	item.Phone = strings.TrimSpace(item.Phone)
+	item.Detail = strings.TrimSpace(item.Detail)
+	item.ZipCode = strings.TrimSpace(item.ZipCode)
+
+	if n := utf8.RuneCountInString(item.RecipientName); n == 0 || n > 25 {
+		return fmt.Errorf("收件人姓名不能为空且不超过25个字")
+	}
+	if !utils.IsValidPhone(item.Phone) {
+		return fmt.Errorf("请填写有效的手机号")
+	}
+	province, city, district, err := utils.ResolveRegions(item.ProvinceCode, item.CityCode, item.DistrictCode)
+	if err != nil {
+		return err
+	}
+	item.Province, item.City, item.District = province, city, district
+	if n := utf8.RuneCountInString(item.Detail); n < 2 || n > 120 {
+		return fmt.Errorf("详细地址为2到120个字")
+	}
+	if item.ZipCode != "" && (len(item.ZipCode) != 6 || strings.Trim(item.ZipCode, "0123456789") != "") {
+		return fmt.Errorf("邮政编码为6位数字")
+	}
+
+	item.NeedsReview = false
+	item.FirstName, item.LastName, item.Street, item.State = "", "", "", ""
+	return nil
+}
+
+// GetRegions 行政区划级联查询，parent 为空时返回省级，否则返回其下级
+func (ac *AddressController) GetRegions(c *fiber.Ctx) error {
+	children, ok := utils.RegionChildren(c.Query("parent"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "行政区划不存在"})
+	}
+	regions := make([]fiber.Map, 0, len(children))
+	for _, region := range children {
+		regions = append(regions, fiber.Map{
+			"code":         region.Code,
+			"name":         region.Name,
+			"has_children": len(region.Children) > 0,
+		})
+	}
+	// 行政区划数据只随发布更新
+	c.Set(fiber.HeaderCacheControl, "public, max-age=86400")
+	return c.JSON(regions)
+}
+
+// 根据旧地址的省、市文本和街道识别行政区划代码
+func matchLegacyRegions(item *models.AddressItem) (provinceCode, cityCode, districtCode string) {
+	province := utils.MatchRegion("", item.State)
+	if province == nil {
+		// 直辖市常常只填在城市里
+		province = utils.MatchRegion("", item.City)
+	}
+	if province == nil {
+		return "", "", ""
+	}
+	city := utils.MatchRegion(province.Code, item.City)
+	if city == nil && len(province.Children) == 1 {
+		city = province.Children[0]
+	}
+
+	// 区县名出现在城市或街道文本中即视为匹配
+	candidates := []*utils.Region{}
+	if city != nil {
+		candidates = city.Children
+	} else {
+		for _, c := range province.Children {
+			candidates = append(candidates, c.Children...)
+		}
+	}
+	var district *utils.Region
+	text := item.City + item.Street
+	for _, d := range candidates {
+		short := strings.TrimRight(d.Name, "区县市")
+		if strings.Contains(text, d.Name) || (utf8.RuneCountInString(short) >= 2 && strings.Contains(text, short)) {
+			district = d
+			break
+		}
+	}
+	if district != nil && city == nil {
+		city = utils.LookupRegion(district.Code[:4] + "00")
+	}
+
+	provinceCode = province.Code
+	if city != nil {
+		cityCode = city.Code
+	}
+	if district != nil {
+		districtCode = district.Code
+	}
+	return provinceCode, cityCode, districtCode
+}
+
+// 将旧的文本地址迁移为结构化地址，无法识别省市区的地址标记为需要用户复核
+// 旧字段保留不删，用户下次修改地址时清除
+func (ac *AddressController) migrateLegacyAddresses(ctx context.Context) (migrated, review int, err error) {
+	cursor, err := ac.addressCollection.Find(ctx,
+		bson.M{"address_detail": bson.M{"$elemMatch": bson.M{"recipient_name": bson.M{"$exists": false}}}})
+	if err != nil {
+		return 0, 0, fmt.Errorf("查询地址失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var address models.Address
+		if err := cursor.Decode(&address); err != nil {
+			return migrated, review, fmt.Errorf("解析地址失败: %v", err)
+		}
+		fields := bson.M{}
+		filter := bson.M{"_id": address.ID}
+		for i := range address.AddressDetails {
+			item := &address.AddressDetails[i]
+			if item.RecipientName != "" || item.ProvinceCode != "" {
+				continue
+			}
+			prefix := fmt.Sprintf("address_detail.%d.", i)
+			filter[prefix+"_id"] = item.ID
+
+			fields[prefix+"recipient_name"] = strings.TrimSpace(item.LastName + item.FirstName)
+			fields[prefix+"detail"] = strings.TrimSpace(item.Street)
+			provinceCode, cityCode, districtCode := matchLegacyRegions(item)
+			province, city, district, err := utils.ResolveRegions(provinceCode, cityCode, districtCode)
+			if err != nil {
+				// 保留原文本便于用户复核
+				fields[prefix+"province"] = item.State
+				fields[prefix+"needs_review"] = true
+				review++
+			} else {
+				fields[prefix+"province_code"] = provinceCode
+				fields[prefix+"province"] = province
+				fields[prefix+"city_code"] = cityCode
+				fields[prefix+"city"] = city
+				fields[prefix+"district_code"] = districtCode
+				fields[prefix+"district"] = district
+				fields[prefix+"needs_review"] = false
+			}
+			migrated++
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := ac.addressCollection.UpdateOne(ctx, filter, bson.M{"$set": fields}); err != nil {
+			return migrated, review, fmt.Errorf("更新地址失败: %v", err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return migrated, review, fmt.Errorf("遍历地址失败: %v", err)
+	}
+	return migrated, review, nil
+}
+
+// 启动时迁移一次旧地址
+func (ac *AddressController) startLegacyMigration() {
+	migrated, review, err := ac.migrateLegacyAddresses(ac.ctx)
+	if err != nil {
+		log.Printf("迁移旧地址失败: %v", err)
+		return
+	}
+	if migrated > 0 {
+		log.Printf("迁移旧地址完成: %d 个地址, 其中 %d 个需要用户复核", migrated, review)
+	}
+}
+
+// 后台手动迁移旧地址
+func (ac *AddressController) MigrateLegacyAddresses(c *fiber.Ctx) error {
+	migrated, review, err := ac.migrateLegacyAddresses(c.Context())
+	if err != nil {
+		log.Printf("迁移旧地址失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "migrated": migrated, "needs_review": review})
+	}
+	return c.JSON(fiber.Map{
+		"message":      "旧地址迁移完成",
+		"migrated":     migrated,
+		"needs_review": review,
+	})
+}
+
+// 用户的默认地址，没有时返回 nil
+func findDefaultAddress(items []models.AddressItem) *models.AddressItem {
+	for i := range items {
+		if items[i].IsDefault {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+// 以下更新都使用聚合管道在一次写入中完成，保证每个用户最多一个默认地址
+// 地址内容用 $literal 包裹，避免用户输入中的 $ 被当作字段引用
+
+// 将 id 对应的地址设为默认，其余取消默认
+func setDefaultPipeline(id primitive.ObjectID) mongo.Pipeline {
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"address_detail": bson.M{"$map": bson.M{
+			"input": "$address_detail",
+			"in":    bson.M{"$mergeObjects": bson.A{"$$this", bson.M{"isdefault": bson.M{"$eq": bson.A{"$$this._id", id}}}}},
+		}},
+	}}}}
+}
+
+// 添加地址，新地址为默认时取消其余地址的默认
+func addAddressPipeline(item models.AddressItem) interface{} {
+	if !item.IsDefault {
+		return bson.M{"$push": bson.M{"address_detail": item}}
+	}
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"address_detail": bson.M{"$concatArrays": bson.A{
+			bson.M{"$map": bson.M{
+				"input": "$address_detail",
+				"in":    bson.M{"$mergeObjects": bson.A{"$$this", bson.M{"isdefault": false}}},
+			}},
+			bson.A{bson.M{"$literal": item}},
+		}},
+	}}}}
+}
+
+// 替换地址内容，isDefault 为 nil 时保持原来的默认状态，为 true 时取消其余地址的默认
+func updateAddressPipeline(item models.AddressItem, isDefault *bool) mongo.Pipeline {
+	var replacement interface{}
+	var others interface{} = "$$this"
+	if isDefault == nil {
+		replacement = bson.M{"$mergeObjects": bson.A{bson.M{"$literal": item}, bson.M{"isdefault": "$$this.isdefault"}}}
+	} else {
+		item.IsDefault = *isDefault
+		replacement = bson.M{"$literal": item}
+		if *isDefault {
+			others = bson.M{"$mergeObjects": bson.A{"$$this", bson.M{"isdefault": false}}}
+		}
+	}
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"address_detail": bson.M{"$map": bson.M{
+			"input": "$address_detail",
+			"in":    bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$$this._id", item.ID}}, replacement, others}},
+		}},
+	}}}}
+}
@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CarrierProvider 物流服务商接口，可接入顺丰直连或快递100等聚合服务
+type CarrierProvider interface {
+	// Name 服务商名称，推送地址 /api/logistics/push/:provider 使用
+	Name() string
+	// Track 查询包裹的全部轨迹，phone 为收件人手机号（顺丰等需要校验后四位）
+	Track(ctx context.Context, carrier, deliverID, phone string) ([]models.TrackingEvent, error)
+	// ParsePush 解析并校验服务商的推送，返回包裹和轨迹
+	ParsePush(c *fiber.Ctx) (carrier, deliverID string, events []models.TrackingEvent, err error)
+	// PushResponse 推送处理完成后返回给服务商的响应
+	PushResponse(c *fiber.Ctx, err error) error
+}
+
+// 常用快递公司名称到编码的映射，编码与快递100一致
+var carrierCodes = map[string]string{
+	"顺丰": "shunfeng", "顺丰速运": "shunfeng", "sf": "shunfeng",
+	"圆通": "yuantong", "圆通速递": "yuantong",
+	"中通": "zhongtong", "中通快递": "zhongtong",
+	"申通": "shentong", "申通快递": "shentong",
+	"韵达": "yunda", "韵达快递": "yunda",
+	"极兔": "jtexpress", "极兔速递": "jtexpress",
+	"京东": "jd", "京东物流": "jd",
+	"邮政": "youzhengguonei", "中国邮政": "youzhengguonei",
+	"ems": "ems", "EMS": "ems",
+	"德邦": "debangwuliu", "德邦快递": "debangwuliu",
+}
+
+// 将快递公司名称统一为编码，未知名称按小写原样保存
+func normalizeCarrier(name string) string {
+	name = strings.TrimSpace(name)
+	if code, ok := carrierCodes[name]; ok {
+		return code
+	}
+	return strings.ToLower(name)
+}
+
+// NewCarrierProviderFromEnv 根据 LOGISTICS_PROVIDER 选择服务商，未配置时不查询物流轨迹
+// kuaidi100 需要配置 KUAIDI100_CUSTOMER、KUAIDI100_KEY 和推送校验使用的 KUAIDI100_SALT
+// fake 为本地模拟，会生成虚构的轨迹并将包裹标记为签收，只能在本地联调时使用
+func NewCarrierProviderFromEnv() (CarrierProvider, error) {
+	switch name := os.Getenv("LOGISTICS_PROVIDER"); name {
+	case "":
+		log.Println("未配置 LOGISTICS_PROVIDER，不查询物流轨迹")
+		return DisabledCarrierProvider{}, nil
+	case "kuaidi100":
+		salt := os.Getenv("KUAIDI100_SALT")
+		if salt == "" {
+			return nil, fmt.Errorf("使用快递100 需要配置 KUAIDI100_SALT，否则无法校验推送")
+		}
+		return NewKuaidi100Provider(os.Getenv("KUAIDI100_CUSTOMER"), os.Getenv("KUAIDI100_KEY"), salt), nil
+	case "fake":
+		log.Println("!!!!!! 警告：正在使用模拟物流服务商，已发货订单会生成虚构轨迹并在 48 小时后标记为已签收，不要在生产环境使用 !!!!!!")
+		return NewFakeCarrierProvider(), nil
+	default:
+		return nil, fmt.Errorf("未知的物流服务商: %s", name)
+	}
+}
+
+var errLogisticsDisabled = errors.New("未启用物流服务商")
+
+// DisabledCarrierProvider 未配置服务商时使用，不查询轨迹也不接收推送
+type DisabledCarrierProvider struct{}
+
+// Name 为空，任何推送地址都不匹配
+func (DisabledCarrierProvider) Name() string {
+	return ""
+}
+
+func (DisabledCarrierProvider) Track(ctx context.Context, carrier, deliverID, phone string) ([]models.TrackingEvent, error) {
+	return nil, errLogisticsDisabled
+}
+
+func (DisabledCarrierProvider) ParsePush(c *fiber.Ctx) (string, string, []models.TrackingEvent, error) {
+	return "", "", nil, errLogisticsDisabled
+}
+
+func (DisabledCarrierProvider) PushResponse(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": errLogisticsDisabled.Error()})
+}
+
+///////模拟服务商，用于本地联调
+
+// FakeCarrierProvider 以首次查询时间为揽收时间，按固定间隔依次生成运输、派送、签收轨迹
+type FakeCarrierProvider struct {
+	// 各阶段相对揽收时间的间隔
+	Steps []time.Duration
+	// 是否接受模拟推送，推送没有签名，只应在本地开启
+	AllowPush bool
+	now       func() time.Time
+
+	mu        sync.Mutex
+	collected map[string]time.Time
+}
+
+// NewFakeCarrierProvider 构造函数
+func NewFakeCarrierProvider() *FakeCarrierProvider {
+	return &FakeCarrierProvider{
+		Steps:     []time.Duration{0, 2 * time.Hour, 24 * time.Hour, 48 * time.Hour},
+		AllowPush: os.Getenv("LOGISTICS_FAKE_PUSH") == "true",
+		now:       time.Now,
+		collected: make(map[string]time.Time),
+	}
+}
+
+func (p *FakeCarrierProvider) Name() string {
+	return "fake"
+}
+
+var fakeTrackingSteps = []struct {
+	status      string
+	description string
+}{
+	{models.TrackingCollected, "快递员已揽收"},
+	{models.TrackingInTransit, "快件已发往目的地分拨中心"},
+	{models.TrackingDelivering, "快件正在派送中"},
+	{models.TrackingDelivered, "快件已签收"},
+}
+
+// 单号以 EX 结尾时模拟疑难件，便于测试异常流程
+func (p *FakeCarrierProvider) Track(ctx context.Context, carrier, deliverID, phone string) ([]models.TrackingEvent, error) {
+	p.mu.Lock()
+	start, ok := p.collected[carrier+"|"+deliverID]
+	if !ok {
+		start = p.now()
+		p.collected[carrier+"|"+deliverID] = start
+	}
+	p.mu.Unlock()
+
+	var events []models.TrackingEvent
+	for i, step := range fakeTrackingSteps {
+		if i >= len(p.Steps) {
+			break
+		}
+		at := start.Add(p.Steps[i])
+		if at.After(p.now()) {
+			break
+		}
+		status, description := step.status, step.description
+		if strings.HasSuffix(deliverID, "EX") && status == models.TrackingDelivering {
+			status, description = models.TrackingException, "收件人电话无法接通，快件滞留"
+		}
+		events = append(events, models.TrackingEvent{
+			Carrier:     carrier,
+			DeliverID:   deliverID,
+			Status:      status,
+			Description: description,
+			Location:    "模拟网点",
+			EventTime:   at,
+		})
+		if status == models.TrackingException {
+			break
+		}
+	}
+	return events, nil
+}
+
+// 模拟推送直接接收 JSON：{"carrier","deliver_id","events":[...]}，需设置 LOGISTICS_FAKE_PUSH=true
+func (p *FakeCarrierProvider) ParsePush(c *fiber.Ctx) (string, string, []models.TrackingEvent, error) {
+	if !p.AllowPush {
+		return "", "", nil, fmt.Errorf("未开启模拟推送")
+	}
+	var req struct {
+		Carrier   string                 `json:"carrier"`
+		DeliverID string                 `json:"deliver_id"`
+		Events    []models.TrackingEvent `json:"events"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return "", "", nil, fmt.Errorf("无效的推送数据")
+	}
+	return normalizeCarrier(req.Carrier), req.DeliverID, req.Events, nil
+}
+
+func (p *FakeCarrierProvider) PushResponse(c *fiber.Ctx, err error) error {
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "ok"})
+}
+
+///////快递100
+
+// Kuaidi100Provider 快递100 实时查询和订阅推送
+type Kuaidi100Provider struct {
+	customer string
+	key      string
+	salt     string
+	endpoint string
+	client   *http.Client
+}
+
+// NewKuaidi100Provider 构造函数
+func NewKuaidi100Provider(customer, key, salt string) *Kuaidi100Provider {
+	return &Kuaidi100Provider{
+		customer: customer,
+		key:      key,
+		salt:     salt,
+		endpoint: "https://poll.kuaidi100.com/poll/query.do",
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Kuaidi100Provider) Name() string {
+	return "kuaidi100"
+}
+
+func md5Upper(s string) string {
+	sum := md5.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// 快递100 的整体状态码
+func kuaidi100Status(state string) string {
+	switch state {
+	case "1":
+		return models.TrackingCollected
+	case "3":
+		return models.TrackingDelivered
+	case "5":
+		return models.TrackingDelivering
+	case "2", "14":
+		return models.TrackingException
+	case "4", "6":
+		return models.TrackingReturned
+	default:
+		return models.TrackingInTransit
+	}
+}
+
+type kuaidi100Result struct {
+	Result     *bool  `json:"result"` // 查询失败时为 false
+	ReturnCode string `json:"returnCode"`
+	Message    string `json:"message"`
+	Com        string `json:"com"`
+	Nu         string `json:"nu"`
+	State      string `json:"state"`
+	Data       []struct {
+		Context  string `json:"context"`
+		Time     string `json:"time"`
+		Location string `json:"location"`
+	} `json:"data"`
+}
+
+// 快递100 的轨迹按时间倒序返回，整体状态只作用于最新一条
+func (r kuaidi100Result) events() []models.TrackingEvent {
+	loc, _ := time.LoadLocation(defaultAnalyticsTimezone)
+	if loc == nil {
+		loc = time.Local
+	}
+	events := make([]models.TrackingEvent, 0, len(r.Data))
+	for i, d := range r.Data {
+		at, err := time.ParseInLocation("2006-01-02 15:04:05", d.Time, loc)
+		if err != nil {
+			continue
+		}
+		status := models.TrackingInTransit
+		if i == 0 {
+			status = kuaidi100Status(r.State)
+		}
+		events = append(events, models.TrackingEvent{
+			Carrier:     r.Com,
+			DeliverID:   r.Nu,
+			Status:      status,
+			Description: d.Context,
+			Location:    d.Location,
+			EventTime:   at,
+		})
+	}
+	return events
+}
+
+func (p *Kuaidi100Provider) Track(ctx context.Context, carrier, deliverID, phone string) ([]models.TrackingEvent, error) {
+	if p.customer == "" || p.key == "" {
+		return nil, fmt.Errorf("未配置快递100账号")
+	}
+	param, _ := json.Marshal(map[string]string{
+		"com":   carrier,
+		"num":   deliverID,
+		"phone": phone,
+	})
+	form := url.Values{
+		"customer": {p.customer},
+		"sign":     {md5Upper(string(param) + p.key + p.customer)},
+		"param":    {string(param)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求快递100失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result kuaidi100Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析快递100响应失败: %v", err)
+	}
+	if result.Result != nil && !*result.Result {
+		return nil, fmt.Errorf("快递100查询失败: %s %s", result.ReturnCode, result.Message)
+	}
+	if result.Com == "" {
+		result.Com = carrier
+	}
+	if result.Nu == "" {
+		result.Nu = deliverID
+	}
+	return result.events(), nil
+}
+
+// 快递100 订阅推送：表单字段 param 为 JSON，sign = MD5(param + salt) 大写
+// 推送地址不需要登录，未配置 salt 时拒绝所有推送
+func (p *Kuaidi100Provider) ParsePush(c *fiber.Ctx) (string, string, []models.TrackingEvent, error) {
+	if p.salt == "" {
+		return "", "", nil, fmt.Errorf("未配置推送签名密钥")
+	}
+	param := c.FormValue("param")
+	if param == "" {
+		return "", "", nil, fmt.Errorf("缺少推送数据")
+	}
+	if md5Upper(param+p.salt) != c.FormValue("sign") {
+		return "", "", nil, fmt.Errorf("推送签名校验失败")
+	}
+	var push struct {
+		Status     string          `json:"status"`
+		Message    string          `json:"message"`
+		LastResult kuaidi100Result `json:"lastResult"`
+	}
+	if err := json.Unmarshal([]byte(param), &push); err != nil {
+		return "", "", nil, fmt.Errorf("解析推送数据失败: %v", err)
+	}
+	return push.LastResult.Com, push.LastResult.Nu, push.LastResult.events(), nil
+}
+
+func (p *Kuaidi100Provider) PushResponse(c *fiber.Ctx, err error) error {
+	if err != nil {
+		// 返回失败后快递100会稍后重推
+		return c.JSON(fiber.Map{"result": false, "returnCode": "500", "message": err.Error()})
+	}
+	return c.JSON(fiber.Map{"result": true, "returnCode": "200", "message": "成功"})
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func fakeProviderAt(now *time.Time) *FakeCarrierProvider {
+	p := NewFakeCarrierProvider()
+	p.now = func() time.Time { return *now }
+	return p
+}
+
+func eventStatuses(events []models.TrackingEvent) []string {
+	statuses := make([]string, len(events))
+	for i, e := range events {
+		statuses[i] = e.Status
+	}
+	return statuses
+}
+
+func TestFakeCarrierProviderTrackSteps(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	p := fakeProviderAt(&now)
+
+	cases := []struct {
+		elapsed time.Duration
+		want    []string
+	}{
+		{0, []string{models.TrackingCollected}},
+		{2*time.Hour - time.Second, []string{models.TrackingCollected}},
+		{2 * time.Hour, []string{models.TrackingCollected, models.TrackingInTransit}},
+		{24 * time.Hour, []string{models.TrackingCollected, models.TrackingInTransit, models.TrackingDelivering}},
+		{48*time.Hour - time.Second, []string{models.TrackingCollected, models.TrackingInTransit, models.TrackingDelivering}},
+		{48 * time.Hour, []string{models.TrackingCollected, models.TrackingInTransit, models.TrackingDelivering, models.TrackingDelivered}},
+	}
+	for _, tc := range cases {
+		now = start.Add(tc.elapsed)
+		events, err := p.Track(context.Background(), "shunfeng", "SF1001", "")
+		if err != nil {
+			t.Fatalf("Track(%v): %v", tc.elapsed, err)
+		}
+		if got := eventStatuses(events); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Track after %v = %v, want %v", tc.elapsed, got, tc.want)
+		}
+		for i, e := range events {
+			if want := start.Add(p.Steps[i]); !e.EventTime.Equal(want) {
+				t.Errorf("event %d time = %v, want %v", i, e.EventTime, want)
+			}
+		}
+	}
+}
+
+func TestFakeCarrierProviderTrackPerParcel(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	p := fakeProviderAt(&now)
+
+	p.Track(context.Background(), "shunfeng", "A", "")
+	now = start.Add(24 * time.Hour)
+	// 第二个包裹首次查询时才揽收
+	events, _ := p.Track(context.Background(), "shunfeng", "B", "")
+	if len(events) != 1 || !events[0].EventTime.Equal(now) {
+		t.Fatalf("new parcel events = %+v, want one collected event at %v", events, now)
+	}
+	events, _ = p.Track(context.Background(), "shunfeng", "A", "")
+	if len(events) != 3 {
+		t.Fatalf("first parcel has %d events, want 3", len(events))
+	}
+}
+
+func TestFakeCarrierProviderTrackException(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	p := fakeProviderAt(&now)
+
+	p.Track(context.Background(), "yunda", "YD42EX", "")
+	now = start.Add(72 * time.Hour)
+	events, err := p.Track(context.Background(), "yunda", "YD42EX", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{models.TrackingCollected, models.TrackingInTransit, models.TrackingException}
+	if got := eventStatuses(events); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("EX parcel statuses = %v, want %v", got, want)
+	}
+	for _, e := range events {
+		if e.Status == models.TrackingDelivered {
+			t.Fatal("EX parcel must never be delivered")
+		}
+	}
+}
+
+func TestKuaidi100ResultEvents(t *testing.T) {
+	cases := []struct {
+		state string
+		want  string
+	}{
+		{"0", models.TrackingInTransit},
+		{"1", models.TrackingCollected},
+		{"2", models.TrackingException},
+		{"3", models.TrackingDelivered},
+		{"4", models.TrackingReturned},
+		{"5", models.TrackingDelivering},
+		{"6", models.TrackingReturned},
+		{"14", models.TrackingException},
+		{"", models.TrackingInTransit},
+	}
+	for _, tc := range cases {
+		var r kuaidi100Result
+		data := `{"com":"zhongtong","nu":"ZT1","state":"` + tc.state + `","data":[
+			{"context":"latest","time":"2024-05-03 09:30:00","location":"上海"},
+			{"context":"bad time","time":"yesterday"},
+			{"context":"older","time":"2024-05-02 18:00:00"}]}`
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			t.Fatal(err)
+		}
+		events := r.events()
+		if len(events) != 2 {
+			t.Fatalf("state %q: got %d events, want 2 (unparseable time skipped)", tc.state, len(events))
+		}
+		if events[0].Status != tc.want {
+			t.Errorf("state %q: latest status = %s, want %s", tc.state, events[0].Status, tc.want)
+		}
+		// 整体状态只作用于最新一条
+		if events[1].Status != models.TrackingInTransit {
+			t.Errorf("state %q: older status = %s, want %s", tc.state, events[1].Status, models.TrackingInTransit)
+		}
+		if events[0].Carrier != "zhongtong" || events[0].DeliverID != "ZT1" || events[0].Location != "上海" {
+			t.Errorf("state %q: unexpected event %+v", tc.state, events[0])
+		}
+		if events[0].EventTime.UTC() != time.Date(2024, 5, 3, 1, 30, 0, 0, time.UTC) {
+			t.Errorf("state %q: event time %v not parsed in %s", tc.state, events[0].EventTime, defaultAnalyticsTimezone)
+		}
+	}
+}
+
+// 通过 fiber 调用 ParsePush
+func parsePush(t *testing.T, p CarrierProvider, form url.Values) (string, string, []models.TrackingEvent, error) {
+	t.Helper()
+	var (
+		carrier, deliverID string
+		events             []models.TrackingEvent
+		parseErr           error
+	)
+	app := fiber.New()
+	app.Post("/push", func(c *fiber.Ctx) error {
+		carrier, deliverID, events, parseErr = p.ParsePush(c)
+		return nil
+	})
+	req := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	return carrier, deliverID, events, parseErr
+}
+
+func TestKuaidi100ParsePushSignature(t *testing.T) {
+	param := `{"status":"polling","lastResult":{"com":"shunfeng","nu":"SF9","state":"3","data":[{"context":"已签收","time":"2024-05-03 09:30:00"}]}}`
+	p := NewKuaidi100Provider("customer", "key", "salt")
+
+	carrier, deliverID, events, err := parsePush(t, p, url.Values{"param": {param}, "sign": {md5Upper(param + "salt")}})
+	if err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if carrier != "shunfeng" || deliverID != "SF9" || len(events) != 1 || events[0].Status != models.TrackingDelivered {
+		t.Fatalf("unexpected push result %s %s %+v", carrier, deliverID, events)
+	}
+
+	for name, form := range map[string]url.Values{
+		"wrong sign":    {"param": {param}, "sign": {md5Upper(param + "other")}},
+		"missing sign":  {"param": {param}},
+		"lowercase":     {"param": {param}, "sign": {strings.ToLower(md5Upper(param + "salt"))}},
+		"missing param": {"sign": {md5Upper("salt")}},
+	} {
+		if _, _, _, err := parsePush(t, p, form); err == nil {
+			t.Errorf("%s: push accepted", name)
+		}
+	}
+
+	// 未配置 salt 时拒绝所有推送
+	unsalted := NewKuaidi100Provider("customer", "key", "")
+	if _, _, _, err := parsePush(t, unsalted, url.Values{"param": {param}, "sign": {md5Upper(param)}}); err == nil {
+		t.Error("push accepted without salt configured")
+	}
+}
+
+func TestNewCarrierProviderFromEnv(t *testing.T) {
+	t.Setenv("LOGISTICS_PROVIDER", "")
+	p, err := NewCarrierProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(DisabledCarrierProvider); !ok {
+		t.Fatalf("default provider = %T, want DisabledCarrierProvider", p)
+	}
+	if _, err := p.Track(context.Background(), "shunfeng", "SF1", ""); err == nil {
+		t.Error("disabled provider returned tracking events")
+	}
+
+	t.Setenv("LOGISTICS_PROVIDER", "kuaidi100")
+	t.Setenv("KUAIDI100_SALT", "")
+	if _, err := NewCarrierProviderFromEnv(); err == nil {
+		t.Error("kuaidi100 without salt was accepted")
+	}
+
+	t.Setenv("LOGISTICS_PROVIDER", "fake")
+	if p, err := NewCarrierProviderFromEnv(); err != nil || p.Name() != "fake" {
+		t.Errorf("fake provider = %v, %v", p, err)
+	}
+
+	t.Setenv("LOGISTICS_PROVIDER", "unknown")
+	if _, err := NewCarrierProviderFromEnv(); err == nil {
+		t.Error("unknown provider was accepted")
+	}
+}
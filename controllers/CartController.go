@@ -2,8 +2,11 @@ package controllers
 
 import (
 	"blog-auth-server/models"
+	"blog-auth-server/money"
 	"context"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofiber/fiber/v2"
@@ -12,18 +15,34 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// 购物车中单个商品规格的数量上限
+const maxCartLineQuantity = 99
+
+// 购物车商品的异常标记
+const (
+	cartIssueDeleted           = "product_deleted"     // 商品已删除
+	cartIssueOutOfStock        = "out_of_stock"        // 商品已售罄
+	cartIssueInsufficientStock = "insufficient_stock"  // 库存少于购物车数量
+	cartIssueVariant           = "variant_unavailable" // 尺寸颜色组合已下架
+	cartIssueUnavailable       = "product_unavailable" // 商品未发布或已下架
+)
+
 type CartController struct {
-	cartCollection    *mongo.Collection // 用于操作购物车的集合
-	productCollection *mongo.Collection // 用于操作产品的集合
-	ctx               context.Context
+	cartCollection     *mongo.Collection // 用于操作购物车的集合
+	productCollection  *mongo.Collection // 用于操作产品的集合
+	ctx                context.Context
+	visitorController  *VisitorController
+	currencyController *CurrencyController
 }
 
 // NewCartController 构造函数
-func NewCartController(cartCollection, productCollection *mongo.Collection, ctx context.Context) *CartController {
+func NewCartController(cartCollection, productCollection *mongo.Collection, ctx context.Context, visitorController *VisitorController, currencyController *CurrencyController) *CartController {
 	return &CartController{
-		cartCollection:    cartCollection,
-		productCollection: productCollection,
-		ctx:               ctx,
+		cartCollection:     cartCollection,
+		productCollection:  productCollection,
+		ctx:                ctx,
+		visitorController:  visitorController,
+		currencyController: currencyController,
 	}
 }
 
@@ -86,6 +105,9 @@ func (cc *CartController) AddtoCart(c *fiber.Ctx) error {
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
 		}
+		if item.Quantity <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "商品数量必须大于0"})
+		}
 
 		// 检查产品是否存在
 		var product models.Product
@@ -98,6 +120,12 @@ func (cc *CartController) AddtoCart(c *fiber.Ctx) error {
 			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking product existence"})
 		}
+		if !product.Visible(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("商品 %s 已下架", product.Name)})
+		}
+		if err := validateVariant(&product, item.Size, item.Color); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 
 		// 检查购物车中是否已经有该产品
 		found := false
@@ -117,6 +145,9 @@ func (cc *CartController) AddtoCart(c *fiber.Ctx) error {
 				Color:      item.Color,
 			})
 		}
+		if err := checkCartStock(&product, cart.CartItems); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 	}
 
 	// 保存购物车到数据库
@@ -140,6 +171,8 @@ func (cc *CartController) AddtoCart(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error saving cart"})
 	}
 
+	cc.visitorController.Track(c, models.EventAddToCart, "")
+
 	// 返回成功响应
 	return c.JSON(fiber.Map{"message": "Product added to cart successfully"})
 }
@@ -201,7 +234,13 @@ func (cc *CartController) DelfromCart(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"message": "商品已从购物车中删除"})
 }
 
+// currency 参数指定展示货币时，每行和合计附带换算后的展示价格
 func (cc *CartController) AllfromCart(c *fiber.Ctx) error {
+	display, err := cc.currencyController.resolve(cc.ctx, c.Query("currency"))
+	if err != nil {
+		return currencyErrorResponse(c, err)
+	}
+
 	// 步骤1: 验证用户并获取用户ID
 	// 从JWT令牌中获取用户ID
 	claims, ok := c.Locals("claims").(jwt.MapClaims)
@@ -219,7 +258,7 @@ func (cc *CartController) AllfromCart(c *fiber.Ctx) error {
 	// 将user_id字符串转换为primitive.ObjectID
 	var userID primitive.ObjectID
 
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	userID, err = primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
 		// 如果转换失败，处理错误
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
@@ -236,9 +275,297 @@ func (cc *CartController) AllfromCart(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
 	}
 
-	return c.JSON(cart)
+	view, err := cc.cartView(cc.ctx, &cart, display)
+	if err != nil {
+		log.Printf("计算购物车价格失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+	return c.JSON(view)
 }
 
+// UpdatefromCart 修改购物车商品的数量或规格
+// 请求体中 product_id/size/color 定位商品，quantity 为新数量（0 表示删除），new_size/new_color 为新规格（可选）
 func (cc *CartController) UpdatefromCart(c *fiber.Ctx) error {
-	return c.SendString(" Test Route")
+	var req struct {
+		ProductID string  `json:"product_id"`
+		Size      string  `json:"size"`
+		Color     string  `json:"color"`
+		Quantity  int     `json:"quantity"`
+		NewSize   *string `json:"new_size"`
+		NewColor  *string `json:"new_color"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	display, err := cc.currencyController.resolve(cc.ctx, c.Query("currency"))
+	if err != nil {
+		return currencyErrorResponse(c, err)
+	}
+
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		log.Println("Error: claims not found in context locals")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		log.Println("Error: user_id claim not found or not a string")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User ID not found in claims"})
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+	productID, err := primitive.ObjectIDFromHex(req.ProductID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	if req.Quantity < 0 || req.Quantity > maxCartLineQuantity {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("商品数量为0到%d", maxCartLineQuantity)})
+	}
+
+	var cart models.Cart
+	if err := cc.cartCollection.FindOne(cc.ctx, bson.M{"user_ref": userID}).Decode(&cart); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cart not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+
+	original := append([]models.CartItem{}, cart.CartItems...)
+	index := -1
+	for i, item := range cart.CartItems {
+		if item.ProductRef == productID && item.Size == req.Size && item.Color == req.Color {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定商品"})
+	}
+
+	if req.Quantity == 0 {
+		cart.CartItems = append(cart.CartItems[:index], cart.CartItems[index+1:]...)
+	} else {
+		var product models.Product
+		if err := cc.productCollection.FindOne(cc.ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "商品已删除，请从购物车移除"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking product existence"})
+		}
+		if !product.Visible(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "商品已下架，请从购物车移除"})
+		}
+
+		size, color := req.Size, req.Color
+		if req.NewSize != nil {
+			size = *req.NewSize
+		}
+		if req.NewColor != nil {
+			color = *req.NewColor
+		}
+		if err := validateVariant(&product, size, color); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		cart.CartItems[index].Quantity = req.Quantity
+		cart.CartItems[index].Size = size
+		cart.CartItems[index].Color = color
+		// 改成购物车中已有的规格时合并数量，合并后不超过单规格上限
+		for i, item := range cart.CartItems {
+			if i != index && item.ProductRef == productID && item.Size == size && item.Color == color {
+				cart.CartItems[index].Quantity = min(cart.CartItems[index].Quantity+item.Quantity, maxCartLineQuantity)
+				cart.CartItems = append(cart.CartItems[:i], cart.CartItems[i+1:]...)
+				break
+			}
+		}
+		if err := checkCartStock(&product, cart.CartItems); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	// 以读取时的购物车内容作为条件，避免覆盖并发的修改
+	result, err := cc.cartCollection.UpdateOne(cc.ctx,
+		bson.M{"_id": cart.ID, "items": cartItemsMatch(original)},
+		bson.M{"$set": bson.M{"items": cart.CartItems}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error updating cart"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "购物车已被修改，请重试"})
+	}
+
+	view, err := cc.cartView(cc.ctx, &cart, display)
+	if err != nil {
+		log.Printf("计算购物车价格失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+	return c.JSON(view)
+}
+
+// 购物车内容与读取时一致的查询条件，用于先读后写的更新，旧数据中没有商品时 items 可能为 null
+func cartItemsMatch(items []models.CartItem) interface{} {
+	if len(items) == 0 {
+		return bson.M{"$in": bson.A{nil, bson.A{}}}
+	}
+	return items
+}
+
+// 校验尺寸和颜色是否为商品的有效规格，商品未设置规格时不校验
+func validateVariant(product *models.Product, size, color string) error {
+	if len(product.SizeColors) == 0 {
+		return nil
+	}
+	for _, sc := range product.SizeColors {
+		if sc.Size != size {
+			continue
+		}
+		if len(sc.Colors) == 0 && color == "" {
+			return nil
+		}
+		for _, c := range sc.Colors {
+			if c == color {
+				return nil
+			}
+		}
+		return fmt.Errorf("商品没有 %s 颜色的 %s 尺寸", color, size)
+	}
+	return fmt.Errorf("商品没有 %s 尺寸", size)
+}
+
+// 校验购物车中同一商品各规格的数量之和不超过库存，库存按商品统计
+func checkCartStock(product *models.Product, items []models.CartItem) error {
+	total := 0
+	for _, item := range items {
+		if item.ProductRef != product.ID {
+			continue
+		}
+		if item.Quantity > maxCartLineQuantity {
+			return fmt.Errorf("单个规格最多购买 %d 件", maxCartLineQuantity)
+		}
+		total += item.Quantity
+	}
+	if product.Inventory <= 0 {
+		return fmt.Errorf("商品 %s 已售罄", product.Name)
+	}
+	if total > product.Inventory {
+		return fmt.Errorf("商品 %s 库存仅剩 %d 件", product.Name, product.Inventory)
+	}
+	return nil
+}
+
+// 商品主图，没有标记主图时使用第一张
+func mainImageURL(product *models.Product) string {
+	for _, image := range product.Images {
+		if image.MainImage {
+			return image.URL
+		}
+	}
+	if len(product.Images) > 0 {
+		return product.Images[0].URL
+	}
+	return ""
+}
+
+// CartLine 购物车中的一行，价格为当前商品价格
+type CartLine struct {
+	ProductRef primitive.ObjectID `json:"product_ref"`
+	Name       string             `json:"name"`
+	Image      string             `json:"image"`
+	Size       string             `json:"size"`
+	Color      string             `json:"color"`
+	Quantity   int                `json:"quantity"`
+	UnitPrice  money.Fen          `json:"unit_price"`
+	LineTotal  money.Fen          `json:"line_total"`
+	Stock      int                `json:"stock"`
+	Available  bool               `json:"available"`       // 为 false 时不计入合计，结算前需要处理
+	Issue      string             `json:"issue,omitempty"` // 不可购买的原因
+	// 指定展示货币时换算后的价格
+	DisplayUnitPrice *DisplayPrice `json:"display_unit_price,omitempty"`
+	DisplayLineTotal *DisplayPrice `json:"display_line_total,omitempty"`
+}
+
+// 关联商品的当前名称、图片和价格，生成购物车视图
+// 为兼容旧前端，原始的购物车字段保持不变；display 不为 nil 时附带换算后的展示价格
+func (cc *CartController) cartView(ctx context.Context, cart *models.Cart, display *displayCurrency) (fiber.Map, error) {
+	ids := make([]primitive.ObjectID, 0, len(cart.CartItems))
+	for _, item := range cart.CartItems {
+		ids = append(ids, item.ProductRef)
+	}
+	products := make(map[primitive.ObjectID]*models.Product, len(ids))
+	if len(ids) > 0 {
+		cursor, err := cc.productCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, fmt.Errorf("查询商品失败: %v", err)
+		}
+		var list []models.Product
+		if err := cursor.All(ctx, &list); err != nil {
+			return nil, fmt.Errorf("解析商品失败: %v", err)
+		}
+		for i := range list {
+			products[list[i].ID] = &list[i]
+		}
+	}
+
+	// 同一商品多个规格共用库存
+	wanted := make(map[primitive.ObjectID]int)
+	for _, item := range cart.CartItems {
+		wanted[item.ProductRef] += item.Quantity
+	}
+
+	now := time.Now()
+	lines := make([]CartLine, 0, len(cart.CartItems))
+	var subtotal money.Fen
+	itemCount := 0
+	for _, item := range cart.CartItems {
+		line := CartLine{
+			ProductRef: item.ProductRef,
+			Size:       item.Size,
+			Color:      item.Color,
+			Quantity:   item.Quantity,
+			Available:  true,
+		}
+		product, ok := products[item.ProductRef]
+		switch {
+		case !ok:
+			line.Available, line.Issue = false, cartIssueDeleted
+		case !product.Visible(now):
+			line.Available, line.Issue = false, cartIssueUnavailable
+		case product.Inventory <= 0:
+			line.Available, line.Issue = false, cartIssueOutOfStock
+		case validateVariant(product, item.Size, item.Color) != nil:
+			line.Available, line.Issue = false, cartIssueVariant
+		case wanted[item.ProductRef] > product.Inventory:
+			line.Available, line.Issue = false, cartIssueInsufficientStock
+		}
+		if ok {
+			line.Name = product.Name
+			line.Image = mainImageURL(product)
+			line.UnitPrice = product.Price
+			line.LineTotal = product.Price.Mul(item.Quantity)
+			line.Stock = product.Inventory
+			line.DisplayUnitPrice = display.price(line.UnitPrice)
+			line.DisplayLineTotal = display.price(line.LineTotal)
+		}
+		if line.Available {
+			subtotal += line.LineTotal
+			itemCount += item.Quantity
+		}
+		lines = append(lines, line)
+	}
+
+	view := fiber.Map{
+		"ID":         cart.ID,
+		"UserRef":    cart.UserRef,
+		"CartItems":  cart.CartItems,
+		"lines":      lines,
+		"item_count": itemCount,
+		"subtotal":   subtotal,
+	}
+	if display != nil {
+		view["display_subtotal"] = display.price(subtotal)
+		view["fx_rate"] = display.info()
+	}
+	return view, nil
 }
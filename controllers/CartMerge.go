@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 游客购物车与用户购物车中有相同规格时的合并方式
+const (
+	cartMergeMax = "max" // 取较大的数量，适合前端重复同步同一份购物车
+	cartMergeSum = "sum" // 数量相加
+)
+
+// 每个购物车保留的已合并游客购物车ID数量
+const mergedGuestCartsLimit = 50
+
+// 合并时被丢弃或调整的行
+type cartMergeLine struct {
+	ProductID string `json:"product_id"`
+	Size      string `json:"size"`
+	Color     string `json:"color"`
+	Quantity  int    `json:"quantity"`         // 游客购物车中的数量
+	Merged    int    `json:"merged,omitempty"` // 调整后购物车中的数量
+	Reason    string `json:"reason"`
+}
+
+// MergeGuestCart 登录后将前端 localStorage 中的游客购物车合并到用户购物车
+// 同一个 guest_cart_id 只合并一次，strategy 为 max 或 sum，默认取环境变量 CART_MERGE_STRATEGY，未配置时为 max
+func (cc *CartController) MergeGuestCart(c *fiber.Ctx) error {
+	var req struct {
+		GuestCartID string `json:"guest_cart_id"`
+		Strategy    string `json:"strategy"`
+		Items       []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int    `json:"quantity"`
+			Size      string `json:"size"`
+			Color     string `json:"color"`
+		} `json:"items"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if req.GuestCartID == "" || len(req.GuestCartID) > 64 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的游客购物车ID"})
+	}
+	strategy := req.Strategy
+	if strategy == "" {
+		strategy = os.Getenv("CART_MERGE_STRATEGY")
+	}
+	if strategy == "" {
+		strategy = cartMergeMax
+	}
+	if strategy != cartMergeMax && strategy != cartMergeSum {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "strategy 只能为 max 或 sum"})
+	}
+	display, err := cc.currencyController.resolve(cc.ctx, c.Query("currency"))
+	if err != nil {
+		return currencyErrorResponse(c, err)
+	}
+
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		log.Println("Error: claims not found in context locals")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		log.Println("Error: user_id claim not found or not a string")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User ID not found in claims"})
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var cart models.Cart
+	cartExists := true
+	if err := cc.cartCollection.FindOne(cc.ctx, bson.M{"user_ref": userID}).Decode(&cart); err != nil {
+		if err != mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking for existing cart"})
+		}
+		cart = models.Cart{ID: primitive.NewObjectID(), UserRef: userID, CartItems: []models.CartItem{}}
+		cartExists = false
+	}
+
+	// 已合并过的游客购物车直接返回当前购物车
+	for _, id := range cart.MergedGuestCarts {
+		if id == req.GuestCartID {
+			return cc.mergeResponse(c, &cart, display, false, nil, nil)
+		}
+	}
+
+	// 批量查询游客购物车中的商品
+	var ids []primitive.ObjectID
+	for _, item := range req.Items {
+		if id, err := primitive.ObjectIDFromHex(item.ProductID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	products := make(map[primitive.ObjectID]*models.Product, len(ids))
+	if len(ids) > 0 {
+		cursor, err := cc.productCollection.Find(cc.ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking product existence"})
+		}
+		var list []models.Product
+		if err := cursor.All(cc.ctx, &list); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking product existence"})
+		}
+		for i := range list {
+			products[list[i].ID] = &list[i]
+		}
+	}
+
+	now := time.Now()
+	original := append([]models.CartItem{}, cart.CartItems...)
+	merged := cart.CartItems
+	var dropped, adjusted []cartMergeLine
+	for _, item := range req.Items {
+		line := cartMergeLine{ProductID: item.ProductID, Size: item.Size, Color: item.Color, Quantity: item.Quantity}
+		drop := func(reason string) {
+			line.Reason = reason
+			dropped = append(dropped, line)
+		}
+
+		productID, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			drop("invalid_product")
+			continue
+		}
+		if item.Quantity <= 0 {
+			drop("invalid_quantity")
+			continue
+		}
+		product, ok := products[productID]
+		if !ok {
+			drop(cartIssueDeleted)
+			continue
+		}
+		if !product.Visible(now) {
+			drop(cartIssueUnavailable)
+			continue
+		}
+		if validateVariant(product, item.Size, item.Color) != nil {
+			drop(cartIssueVariant)
+			continue
+		}
+
+		index, existing, others := -1, 0, 0
+		for i, cartItem := range merged {
+			if cartItem.ProductRef != productID {
+				continue
+			}
+			if cartItem.Size == item.Size && cartItem.Color == item.Color {
+				index, existing = i, cartItem.Quantity
+			} else {
+				others += cartItem.Quantity
+			}
+		}
+
+		want := item.Quantity
+		if index >= 0 {
+			if strategy == cartMergeSum {
+				want += existing
+			} else if existing > want {
+				want = existing
+			}
+		}
+		// 不超过单规格上限和库存，但不减少用户购物车中原有的数量
+		limit := product.Inventory - others
+		if limit > maxCartLineQuantity {
+			limit = maxCartLineQuantity
+		}
+		quantity := want
+		if quantity > limit {
+			quantity = limit
+		}
+		if quantity < existing {
+			quantity = existing
+		}
+
+		if index < 0 {
+			if quantity <= 0 {
+				if product.Inventory <= 0 {
+					drop(cartIssueOutOfStock)
+				} else {
+					drop(cartIssueInsufficientStock)
+				}
+				continue
+			}
+			merged = append(merged, models.CartItem{ProductRef: productID, Quantity: quantity, Size: item.Size, Color: item.Color})
+		} else {
+			merged[index].Quantity = quantity
+		}
+		if quantity < want {
+			line.Merged = quantity
+			line.Reason = cartIssueInsufficientStock
+			adjusted = append(adjusted, line)
+		}
+	}
+	cart.CartItems = merged
+
+	if !cartExists {
+		cart.MergedGuestCarts = []string{req.GuestCartID}
+		if _, err := cc.cartCollection.InsertOne(cc.ctx, cart); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error creating new cart"})
+		}
+		return cc.mergeResponse(c, &cart, display, true, dropped, adjusted)
+	}
+
+	// 以读取时的购物车内容作为条件，避免覆盖并发的修改
+	result, err := cc.cartCollection.UpdateOne(cc.ctx,
+		bson.M{"_id": cart.ID, "items": cartItemsMatch(original), "merged_guest_carts": bson.M{"$ne": req.GuestCartID}},
+		bson.M{
+			"$set":  bson.M{"items": cart.CartItems},
+			"$push": bson.M{"merged_guest_carts": bson.M{"$each": bson.A{req.GuestCartID}, "$slice": -mergedGuestCartsLimit}},
+		})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error updating cart"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "购物车已被修改，请重试"})
+	}
+	return cc.mergeResponse(c, &cart, display, true, dropped, adjusted)
+}
+
+// 合并结果：购物车视图加上本次合并的丢弃和调整明细
+func (cc *CartController) mergeResponse(c *fiber.Ctx, cart *models.Cart, display *displayCurrency, merged bool, dropped, adjusted []cartMergeLine) error {
+	view, err := cc.cartView(cc.ctx, cart, display)
+	if err != nil {
+		log.Printf("计算购物车价格失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+	if dropped == nil {
+		dropped = []cartMergeLine{}
+	}
+	if adjusted == nil {
+		adjusted = []cartMergeLine{}
+	}
+	view["merged"] = merged // 为 false 表示该游客购物车之前已合并过
+	view["dropped"] = dropped
+	view["adjusted"] = adjusted
+	return c.JSON(view)
+}
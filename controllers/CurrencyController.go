@@ -0,0 +1,431 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"blog-auth-server/money"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errCurrencyUnsupported = errors.New("不支持的货币")
+	errFxRateMissing       = errors.New("该货币暂无可用汇率")
+)
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// 汇率导入的列，表头可以是中文标题，也可以是列名
+var fxRateImportHeaders = map[string]string{
+	"货币": "currency", "currency": "currency",
+	"汇率": "rate", "rate": "rate",
+	"生效时间": "effective_at", "effective_at": "effective_at",
+}
+
+// 生效时间支持的格式，不带时区的按服务器本地时间解析
+var fxRateTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+type CurrencyController struct {
+	currencyCollection *mongo.Collection
+	rateCollection     *mongo.Collection
+	ctx                context.Context
+}
+
+// NewCurrencyController 构造函数
+func NewCurrencyController(currencyCollection, rateCollection *mongo.Collection, ctx context.Context) *CurrencyController {
+	return &CurrencyController{
+		currencyCollection: currencyCollection,
+		rateCollection:     rateCollection,
+		ctx:                ctx,
+	}
+}
+
+// DisplayPrice 换算为外币的展示价格，Amount 按货币的小数位数格式化
+type DisplayPrice struct {
+	Currency string `json:"currency"`
+	Symbol   string `json:"symbol"`
+	Amount   string `json:"amount"`
+}
+
+// 展示货币及其当前汇率，为 nil 表示按人民币展示
+type displayCurrency struct {
+	currency models.Currency
+	rate     models.FxRate
+}
+
+// 将人民币金额换算为展示价格
+func (d *displayCurrency) price(amount money.Fen) *DisplayPrice {
+	if d == nil {
+		return nil
+	}
+	return &DisplayPrice{
+		Currency: d.currency.Code,
+		Symbol:   d.currency.Symbol,
+		Amount:   money.FormatMinor(amount.Convert(d.rate.Rate, d.currency.Decimals), d.currency.Decimals),
+	}
+}
+
+// 响应中说明使用的汇率
+func (d *displayCurrency) info() fiber.Map {
+	if d == nil {
+		return nil
+	}
+	return fiber.Map{
+		"currency":     d.currency.Code,
+		"symbol":       d.currency.Symbol,
+		"decimals":     d.currency.Decimals,
+		"rate":         d.rate.Rate,
+		"rate_ref":     d.rate.ID,
+		"effective_at": d.rate.EffectiveAt,
+	}
+}
+
+// 查询货币当前生效的汇率
+func (cc *CurrencyController) currentRate(ctx context.Context, code string, now time.Time) (*models.FxRate, error) {
+	var rate models.FxRate
+	opts := options.FindOne().SetSort(bson.D{{Key: "effective_at", Value: -1}, {Key: "_id", Value: -1}})
+	err := cc.rateCollection.FindOne(ctx, bson.M{"currency": code, "effective_at": bson.M{"$lte": now}}, opts).Decode(&rate)
+	if err == mongo.ErrNoDocuments {
+		return nil, errFxRateMissing
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询汇率失败: %v", err)
+	}
+	return &rate, nil
+}
+
+// resolve 解析前台传入的货币代码，为空或人民币时返回 nil
+func (cc *CurrencyController) resolve(ctx context.Context, code string) (*displayCurrency, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" || code == models.BaseCurrency {
+		return nil, nil
+	}
+	var currency models.Currency
+	err := cc.currencyCollection.FindOne(ctx, bson.M{"_id": code, "enabled": true}).Decode(&currency)
+	if err == mongo.ErrNoDocuments {
+		return nil, errCurrencyUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询货币失败: %v", err)
+	}
+	rate, err := cc.currentRate(ctx, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &displayCurrency{currency: currency, rate: *rate}, nil
+}
+
+// 货币参数错误返回 400，其他错误返回 500
+func currencyErrorResponse(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errCurrencyUnsupported) || errors.Is(err, errFxRateMissing) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("解析展示货币失败: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+}
+
+// 校验汇率数值
+func validateFxRate(rate float64) string {
+	if math.IsNaN(rate) || math.IsInf(rate, 0) || rate <= 0 {
+		return "汇率必须大于 0"
+	}
+	return ""
+}
+
+// 前台获取可选的展示货币及当前汇率
+func (cc *CurrencyController) GetCurrencies(c *fiber.Ctx) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := cc.currencyCollection.Find(cc.ctx, bson.M{"enabled": true}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取货币失败"})
+	}
+	var list []models.Currency
+	if err := cursor.All(cc.ctx, &list); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析货币失败"})
+	}
+
+	now := time.Now()
+	currencies := []fiber.Map{}
+	for _, currency := range list {
+		rate, err := cc.currentRate(cc.ctx, currency.Code, now)
+		if errors.Is(err, errFxRateMissing) {
+			continue // 没有汇率的货币暂不展示
+		}
+		if err != nil {
+			log.Printf("查询汇率失败 (%s): %v", currency.Code, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取汇率失败"})
+		}
+		currencies = append(currencies, fiber.Map{
+			"code":         currency.Code,
+			"name":         currency.Name,
+			"symbol":       currency.Symbol,
+			"decimals":     currency.Decimals,
+			"rate":         rate.Rate,
+			"effective_at": rate.EffectiveAt,
+		})
+	}
+	return c.JSON(fiber.Map{"base": models.BaseCurrency, "currencies": currencies})
+}
+
+// 后台获取所有货币设置
+func (cc *CurrencyController) GetCurrencySettings(c *fiber.Ctx) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := cc.currencyCollection.Find(cc.ctx, bson.M{}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取货币失败"})
+	}
+	currencies := []models.Currency{}
+	if err := cursor.All(cc.ctx, &currencies); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析货币失败"})
+	}
+	return c.JSON(fiber.Map{"currencies": currencies})
+}
+
+// 后台添加或修改货币设置
+func (cc *CurrencyController) SaveCurrency(c *fiber.Ctx) error {
+	code := strings.ToUpper(c.Params("code"))
+	if !currencyCodePattern.MatchString(code) || code == models.BaseCurrency {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的货币代码"})
+	}
+	var currency models.Currency
+	if err := c.BodyParser(&currency); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if strings.TrimSpace(currency.Name) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "货币名称不能为空"})
+	}
+	if currency.Decimals < 0 || currency.Decimals > 4 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "小数位数必须在 0 到 4 之间"})
+	}
+
+	currency.Code = code
+	currency.UpdatedAt = time.Now()
+	_, err := cc.currencyCollection.ReplaceOne(cc.ctx, bson.M{"_id": code}, currency, options.Replace().SetUpsert(true))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "保存货币失败"})
+	}
+	return c.JSON(fiber.Map{"message": "货币保存成功", "currency": currency})
+}
+
+// 后台查询汇率历史，currency 为空时返回所有货币
+func (cc *CurrencyController) GetFxRates(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if code := strings.ToUpper(c.Query("currency")); code != "" {
+		filter["currency"] = code
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "effective_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := cc.rateCollection.Find(cc.ctx, filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取汇率失败"})
+	}
+	rates := []models.FxRate{}
+	if err := cursor.All(cc.ctx, &rates); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析汇率失败"})
+	}
+	return c.JSON(fiber.Map{"rates": rates})
+}
+
+// 后台录入汇率，effective_at 为空时立即生效
+func (cc *CurrencyController) AddFxRate(c *fiber.Ctx) error {
+	var req struct {
+		Currency    string    `json:"currency"`
+		Rate        float64   `json:"rate"`
+		EffectiveAt time.Time `json:"effective_at"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	req.Currency = strings.ToUpper(strings.TrimSpace(req.Currency))
+	if msg := validateFxRate(req.Rate); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	count, err := cc.currencyCollection.CountDocuments(cc.ctx, bson.M{"_id": req.Currency})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询货币失败"})
+	}
+	if count == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请先添加该货币"})
+	}
+
+	now := time.Now()
+	rate := models.FxRate{
+		ID:          primitive.NewObjectID(),
+		Currency:    req.Currency,
+		Rate:        req.Rate,
+		Source:      models.FxRateManual,
+		EffectiveAt: req.EffectiveAt,
+		CreatedAt:   now,
+	}
+	if rate.EffectiveAt.IsZero() {
+		rate.EffectiveAt = now
+	}
+	if _, err := cc.rateCollection.InsertOne(cc.ctx, rate); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "保存汇率失败"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "汇率添加成功", "rate": rate})
+}
+
+// FxRateImportRow 每一行的导入结果
+type FxRateImportRow struct {
+	Row         int       `json:"row"` // 表格中的行号，表头为第1行
+	Currency    string    `json:"currency"`
+	Rate        float64   `json:"rate,omitempty"`
+	EffectiveAt time.Time `json:"effective_at,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// 后台批量导入汇率
+// 上传字段 file（xlsx/csv，包含货币、汇率列，生效时间可选），dry_run=true 时只校验不保存
+func (cc *CurrencyController) ImportFxRates(c *fiber.Ctx) error {
+	dryRun := c.Query("dry_run", c.FormValue("dry_run")) == "true"
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请上传文件"})
+	}
+	if fileHeader.Size > shipmentImportMaxSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "文件不能超过 10MB"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "读取文件失败"})
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "读取文件失败"})
+	}
+
+	records, err := readSheetRecords(fileHeader.Filename, data)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(records) < 2 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "文件中没有数据行"})
+	}
+	if len(records)-1 > shipmentImportMaxRows {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("单次最多导入 %d 行", shipmentImportMaxRows)})
+	}
+	columns := make(map[int]string)
+	found := make(map[string]bool)
+	for i, title := range records[0] {
+		if key, ok := fxRateImportHeaders[strings.TrimSpace(title)]; ok {
+			columns[i] = key
+			found[key] = true
+		}
+	}
+	if !found["currency"] || !found["rate"] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "缺少货币或汇率列"})
+	}
+
+	cursor, err := cc.currencyCollection.Find(cc.ctx, bson.M{})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询货币失败"})
+	}
+	var list []models.Currency
+	if err := cursor.All(cc.ctx, &list); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析货币失败"})
+	}
+	known := make(map[string]bool, len(list))
+	for _, currency := range list {
+		known[currency.Code] = true
+	}
+
+	now := time.Now()
+	report := make([]FxRateImportRow, 0, len(records)-1)
+	var rates []interface{}
+	for i, record := range records[1:] {
+		row := make(map[string]string, len(columns))
+		for j, value := range record {
+			if key, ok := columns[j]; ok {
+				row[key] = strings.TrimSpace(value)
+			}
+		}
+		result := FxRateImportRow{Row: i + 2, Currency: strings.ToUpper(row["currency"]), Status: importRowError}
+		fail := func(msg string) {
+			result.Error = msg
+			report = append(report, result)
+		}
+
+		if !known[result.Currency] {
+			fail("未添加的货币")
+			continue
+		}
+		rate, err := strconv.ParseFloat(row["rate"], 64)
+		if err != nil {
+			fail("无效的汇率")
+			continue
+		}
+		if msg := validateFxRate(rate); msg != "" {
+			fail(msg)
+			continue
+		}
+		result.Rate = rate
+		result.EffectiveAt = now
+		if value := row["effective_at"]; value != "" {
+			parsed := false
+			for _, layout := range fxRateTimeLayouts {
+				if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+					result.EffectiveAt, parsed = t, true
+					break
+				}
+			}
+			if !parsed {
+				fail("无效的生效时间")
+				continue
+			}
+		}
+
+		result.Status = importRowOK
+		report = append(report, result)
+		rates = append(rates, models.FxRate{
+			ID:          primitive.NewObjectID(),
+			Currency:    result.Currency,
+			Rate:        result.Rate,
+			Source:      models.FxRateImport,
+			EffectiveAt: result.EffectiveAt,
+			CreatedAt:   now,
+		})
+	}
+
+	summary := fiber.Map{"total": len(report), "ok": len(rates), "error": len(report) - len(rates)}
+	if !dryRun && len(rates) > 0 {
+		if _, err := cc.rateCollection.InsertMany(cc.ctx, rates); err != nil {
+			log.Printf("批量导入汇率失败: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "保存汇率失败",
+				"summary": summary,
+				"rows":    report,
+			})
+		}
+	}
+
+	message := "汇率导入完成"
+	if dryRun {
+		message = "试运行完成，未保存任何数据"
+	}
+	return c.JSON(fiber.Map{
+		"message": message,
+		"dry_run": dryRun,
+		"summary": summary,
+		"rows":    report,
+	})
+}
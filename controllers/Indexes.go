@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes 启动时创建业务依赖的唯一索引，索引已存在时不做任何修改
+func EnsureIndexes(ctx context.Context, orderCollection, redemptionOrderCollection *mongo.Collection) error {
+	// 每个报价只能创建一个订单；旧订单没有 quote_id，不参与唯一约束
+	_, err := orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "quote_id", Value: 1}},
+		Options: options.Index().
+			SetName("quote_id_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"quote_id": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return fmt.Errorf("创建订单报价唯一索引失败: %v", err)
+	}
+
+	// 同一笔链上交易只能核验通过一个赎回订单；核验失败的记录不参与唯一约束
+	_, err = redemptionOrderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "verification.signature", Value: 1}},
+		Options: options.Index().
+			SetName("verified_signature_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"verification.verified": true}),
+	})
+	if err != nil {
+		return fmt.Errorf("创建赎回交易签名唯一索引失败: %v", err)
+	}
+	return nil
+}
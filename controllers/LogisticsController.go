@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 物流轨迹轮询间隔
+const trackingPollInterval = 30 * time.Minute
+
+type LogisticsController struct {
+	orderCollection    *mongo.Collection
+	trackingCollection *mongo.Collection
+	provider           CarrierProvider
+	ctx                context.Context
+}
+
+// NewLogisticsController 构造函数
+func NewLogisticsController(orderCollection, trackingCollection *mongo.Collection, provider CarrierProvider, ctx context.Context) *LogisticsController {
+	lc := &LogisticsController{
+		orderCollection:    orderCollection,
+		trackingCollection: trackingCollection,
+		provider:           provider,
+		ctx:                ctx,
+	}
+	// 启动物流轨迹轮询 goroutine，未配置服务商时不轮询
+	if _, disabled := provider.(DisabledCarrierProvider); disabled {
+		return lc
+	}
+	go lc.startPolling()
+	return lc
+}
+
+func (lc *LogisticsController) startPolling() {
+	ticker := time.NewTicker(trackingPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := lc.pollShipments(lc.ctx); err != nil {
+				log.Printf("轮询物流轨迹失败: %v", err)
+			}
+		case <-lc.ctx.Done():
+			return
+		}
+	}
+}
+
+// parcel 一个包裹，同一快递单号可能包含多个商品
+type parcel struct {
+	Carrier   string
+	DeliverID string
+	Phone     string
+}
+
+func (p parcel) key() string {
+	return p.Carrier + "|" + p.DeliverID
+}
+
+// 收集订单中已发货未签收的包裹，收件人电话用于顺丰等需要校验手机号的快递
+func (lc *LogisticsController) shippedParcels(orders []models.Orders) []parcel {
+	seen := make(map[string]bool)
+	var parcels []parcel
+	for _, order := range orders {
+		for _, item := range order.OrderItems {
+			if item.ShippingStatus != models.ShippingShipped || item.DeliverID == "" || item.Carrier == "" {
+				continue
+			}
+			p := parcel{Carrier: item.Carrier, DeliverID: item.DeliverID}
+			if item.ShippingAddress != nil {
+				p.Phone = item.ShippingAddress.Phone
+			}
+			if !seen[p.key()] {
+				seen[p.key()] = true
+				parcels = append(parcels, p)
+			}
+		}
+	}
+	return parcels
+}
+
+// 轮询所有已发货未签收的包裹
+func (lc *LogisticsController) pollShipments(ctx context.Context) error {
+	cursor, err := lc.orderCollection.Find(ctx, bson.M{
+		"items": bson.M{"$elemMatch": bson.M{
+			"shipping_status": models.ShippingShipped,
+			"deliver_id":      bson.M{"$ne": ""},
+		}},
+	}, options.Find().SetProjection(bson.M{"items": 1}))
+	if err != nil {
+		return fmt.Errorf("查询已发货订单失败: %v", err)
+	}
+	var orders []models.Orders
+	if err := cursor.All(ctx, &orders); err != nil {
+		return fmt.Errorf("解析已发货订单失败: %v", err)
+	}
+
+	for _, p := range lc.shippedParcels(orders) {
+		if err := lc.refreshParcel(ctx, p); err != nil {
+			log.Printf("更新物流轨迹失败 (%s %s): %v", p.Carrier, p.DeliverID, err)
+		}
+	}
+	return nil
+}
+
+// 向服务商查询包裹轨迹并保存
+func (lc *LogisticsController) refreshParcel(ctx context.Context, p parcel) error {
+	events, err := lc.provider.Track(ctx, p.Carrier, p.DeliverID, p.Phone)
+	if err != nil {
+		return err
+	}
+	return lc.applyEvents(ctx, p.Carrier, p.DeliverID, events, "poll")
+}
+
+// 保存轨迹，同一时间同一描述的轨迹只保存一次；出现签收轨迹时将包裹内商品标记为已签收
+func (lc *LogisticsController) applyEvents(ctx context.Context, carrier, deliverID string, events []models.TrackingEvent, source string) error {
+	if carrier == "" || deliverID == "" {
+		return fmt.Errorf("缺少快递公司或快递单号")
+	}
+	now := time.Now()
+	var deliveredAt time.Time
+	for _, event := range events {
+		if event.EventTime.IsZero() {
+			continue
+		}
+		_, err := lc.trackingCollection.UpdateOne(ctx,
+			bson.M{
+				"carrier":     carrier,
+				"deliver_id":  deliverID,
+				"event_time":  event.EventTime,
+				"description": event.Description,
+			},
+			bson.M{
+				"$set": bson.M{
+					"status":   event.Status,
+					"location": event.Location,
+				},
+				"$setOnInsert": bson.M{
+					"source":     source,
+					"created_at": now,
+				},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return fmt.Errorf("保存物流轨迹失败: %v", err)
+		}
+		if event.Status == models.TrackingDelivered && event.EventTime.After(deliveredAt) {
+			deliveredAt = event.EventTime
+		}
+	}
+
+	if deliveredAt.IsZero() {
+		return nil
+	}
+	_, err := lc.orderCollection.UpdateMany(ctx,
+		bson.M{"items": bson.M{"$elemMatch": bson.M{
+			"carrier":         carrier,
+			"deliver_id":      deliverID,
+			"shipping_status": models.ShippingShipped,
+		}}},
+		bson.M{"$set": bson.M{
+			"items.$[elem].shipping_status": models.ShippingDelivered,
+			"items.$[elem].delivered_at":    deliveredAt,
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{
+			"elem.carrier":         carrier,
+			"elem.deliver_id":      deliverID,
+			"elem.shipping_status": models.ShippingShipped,
+		}}}),
+	)
+	if err != nil {
+		return fmt.Errorf("更新签收状态失败: %v", err)
+	}
+	return nil
+}
+
+// 物流服务商推送轨迹
+func (lc *LogisticsController) ReceivePush(c *fiber.Ctx) error {
+	if c.Params("provider") != lc.provider.Name() {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未启用该物流服务商"})
+	}
+	carrier, deliverID, events, err := lc.provider.ParsePush(c)
+	if err != nil {
+		log.Printf("解析物流推送失败: %v", err)
+		return lc.provider.PushResponse(c, err)
+	}
+	err = lc.applyEvents(c.Context(), carrier, deliverID, events, "push")
+	if err != nil {
+		log.Printf("保存物流推送失败 (%s %s): %v", carrier, deliverID, err)
+	}
+	return lc.provider.PushResponse(c, err)
+}
+
+// 订单的物流时间线：按包裹分组，包含包裹内的商品和倒序的轨迹
+func (lc *LogisticsController) orderTimeline(ctx context.Context, order models.Orders) ([]fiber.Map, error) {
+	type parcelView struct {
+		carrier   string
+		deliverID string
+		items     []fiber.Map
+	}
+	var parcels []*parcelView
+	byKey := make(map[string]*parcelView)
+	for _, item := range order.OrderItems {
+		if item.DeliverID == "" {
+			continue
+		}
+		key := item.Carrier + "|" + item.DeliverID
+		pv, ok := byKey[key]
+		if !ok {
+			pv = &parcelView{carrier: item.Carrier, deliverID: item.DeliverID}
+			byKey[key] = pv
+			parcels = append(parcels, pv)
+		}
+		pv.items = append(pv.items, fiber.Map{
+			"product_ref":     item.ProductRef,
+			"size":            item.Size,
+			"color":           item.Color,
+			"quantity":        item.Quantity,
+			"shipping_status": item.ShippingStatus,
+			"shipped_at":      item.ShippedAt,
+			"delivered_at":    item.DeliveredAt,
+		})
+	}
+
+	timeline := make([]fiber.Map, 0, len(parcels))
+	for _, pv := range parcels {
+		cursor, err := lc.trackingCollection.Find(ctx,
+			bson.M{"carrier": pv.carrier, "deliver_id": pv.deliverID},
+			options.Find().SetSort(bson.M{"event_time": -1}))
+		if err != nil {
+			return nil, err
+		}
+		events := []models.TrackingEvent{}
+		if err := cursor.All(ctx, &events); err != nil {
+			return nil, err
+		}
+		status := ""
+		if len(events) > 0 {
+			status = events[0].Status
+		}
+		timeline = append(timeline, fiber.Map{
+			"carrier":    pv.carrier,
+			"deliver_id": pv.deliverID,
+			"status":     status,
+			"items":      pv.items,
+			"events":     events,
+		})
+	}
+	return timeline, nil
+}
+
+// 买家查看订单物流
+func (lc *LogisticsController) GetOrderTracking(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "未授权访问"})
+	}
+	userID, err := primitive.ObjectIDFromHex(fmt.Sprint(claims["user_id"]))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的用户ID"})
+	}
+	orderID, err := primitive.ObjectIDFromHex(c.Params("orderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
+	}
+
+	var order models.Orders
+	err = lc.orderCollection.FindOne(c.Context(), bson.M{"_id": orderID, "user_ref": userID}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "订单不存在"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+
+	timeline, err := lc.orderTimeline(c.Context(), order)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询物流轨迹失败"})
+	}
+	return c.JSON(fiber.Map{"order_id": order.ID, "parcels": timeline})
+}
+
+// 后端管理员立即刷新订单的物流轨迹
+func (lc *LogisticsController) RefreshOrderTracking(c *fiber.Ctx) error {
+	orderID, err := primitive.ObjectIDFromHex(c.Params("orderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
+	}
+	var order models.Orders
+	if err := lc.orderCollection.FindOne(c.Context(), bson.M{"_id": orderID}).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "订单不存在"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+
+	errors := fiber.Map{}
+	for _, p := range lc.shippedParcels([]models.Orders{order}) {
+		if err := lc.refreshParcel(c.Context(), p); err != nil {
+			errors[p.DeliverID] = err.Error()
+		}
+	}
+
+	// 重新读取签收状态
+	if err := lc.orderCollection.FindOne(c.Context(), bson.M{"_id": orderID}).Decode(&order); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+	timeline, err := lc.orderTimeline(c.Context(), order)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询物流轨迹失败"})
+	}
+	return c.JSON(fiber.Map{"order_id": order.ID, "parcels": timeline, "errors": errors})
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"blog-auth-server/media"
+	"blog-auth-server/models"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 自动清理未引用图片的间隔
+const mediaGCInterval = 24 * time.Hour
+
+type MediaController struct {
+	productCollection *mongo.Collection
+	media             *media.Service
+	ctx               context.Context
+}
+
+// NewMediaController 构造函数，启动定时清理未引用的图片
+func NewMediaController(productCollection *mongo.Collection, mediaService *media.Service, ctx context.Context) *MediaController {
+	mc := &MediaController{
+		productCollection: productCollection,
+		media:             mediaService,
+		ctx:               ctx,
+	}
+	go mc.startGarbageCollection()
+	return mc
+}
+
+// 未引用的文件保留时间，MEDIA_GC_GRACE_HOURS 默认 24 小时
+func mediaGCGrace() time.Duration {
+	return time.Duration(envFloat("MEDIA_GC_GRACE_HOURS", 24) * float64(time.Hour))
+}
+
+func (mc *MediaController) startGarbageCollection() {
+	ticker := time.NewTicker(mediaGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			result, err := mc.collectGarbage(mc.ctx, false)
+			if err != nil {
+				log.Printf("清理未引用的图片失败: %v", err)
+				continue
+			}
+			if len(result.Removed) > 0 {
+				log.Printf("清理未引用的图片 %d 个，释放 %d 字节", len(result.Removed), result.Bytes)
+			}
+		case <-mc.ctx.Done():
+			return
+		}
+	}
+}
+
+// 图片各版本在存储中的 Key，不属于当前存储的地址忽略
+func imageKeys(storage media.Storage, image models.Image) []string {
+	var keys []string
+	for _, url := range []string{image.URL, image.Thumbnail, image.WebP} {
+		if key, ok := storage.KeyFromURL(url); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// 收集所有商品引用的图片
+func (mc *MediaController) referencedKeys(ctx context.Context) (map[string]bool, error) {
+	cursor, err := mc.productCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"images": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("查询商品图片失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	storage := mc.media.Storage()
+	referenced := make(map[string]bool)
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, fmt.Errorf("解析商品图片失败: %v", err)
+		}
+		for _, image := range product.Images {
+			for _, key := range imageKeys(storage, image) {
+				referenced[key] = true
+			}
+		}
+	}
+	return referenced, cursor.Err()
+}
+
+func (mc *MediaController) collectGarbage(ctx context.Context, dryRun bool) (*media.GCResult, error) {
+	referenced, err := mc.referencedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mc.media.CollectGarbage(ctx, referenced, mediaGCGrace(), dryRun)
+}
+
+// 后台清理未被任何商品引用的图片，dry_run=true 时只列出不删除
+func (mc *MediaController) CollectMediaGarbage(c *fiber.Ctx) error {
+	dryRun := c.Query("dry_run") == "true"
+	result, err := mc.collectGarbage(c.Context(), dryRun)
+	if err != nil {
+		log.Printf("清理未引用的图片失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "清理图片失败"})
+	}
+	message := "清理完成"
+	if dryRun {
+		message = "试运行完成，未删除任何文件"
+	}
+	return c.JSON(fiber.Map{"message": message, "dry_run": dryRun, "result": result})
+}
+
+// 处理上传的图片文件，返回可保存到商品中的图片信息
+func processImageFile(ctx context.Context, service *media.Service, file *multipart.FileHeader, imageType string) (models.Image, error) {
+	if file.Size > service.MaxSize() {
+		return models.Image{}, fmt.Errorf("%s: %w", file.Filename, media.ErrTooLarge)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return models.Image{}, fmt.Errorf("%s: 读取文件失败", file.Filename)
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, service.MaxSize()+1))
+	if err != nil {
+		return models.Image{}, fmt.Errorf("%s: 读取文件失败", file.Filename)
+	}
+	asset, err := service.Process(ctx, data)
+	if err != nil {
+		return models.Image{}, fmt.Errorf("%s: %w", file.Filename, err)
+	}
+	return models.Image{
+		URL:       asset.URL,
+		Type:      imageType,
+		MainImage: imageType == "main",
+		Thumbnail: asset.Thumbnail,
+		WebP:      asset.WebP,
+		Width:     asset.Width,
+		Height:    asset.Height,
+		Hash:      asset.Hash,
+	}, nil
+}
+
+// 图片校验失败返回 400，存储等内部错误返回 500
+func imageErrorResponse(c *fiber.Ctx, err error) error {
+	for _, target := range []error{media.ErrTooLarge, media.ErrUnsupported, media.ErrTooManyPx, media.ErrCorrupt} {
+		if errors.Is(err, target) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	log.Printf("处理图片失败: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "保存图片失败"})
+}
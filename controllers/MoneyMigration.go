@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 金额单位迁移的完成记录，保存在 migrations 集合中
+const moneyMigrationID = "money_fen"
+
+// 元转分：乘以 100 后四舍五入为整数，缺失的字段按 0 处理
+func yuanToFenExpr(value interface{}) bson.M {
+	return bson.M{"$toLong": bson.M{"$round": bson.A{
+		bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{value, 0}}, 100}}, 0,
+	}}}
+}
+
+// MigrateMoneyToFen 将旧数据中以元保存的商品价格、订单金额和运费模板改为以分保存
+// 每个文档转换后标记 amount_unit，新写入的商品、订单和运费模板同样带有该标记，
+// 中断或迁移记录丢失后重新执行不会重复转换；全部完成后写入完成记录，之后启动时直接跳过
+func MigrateMoneyToFen(ctx context.Context, migrationCollection, productCollection, orderCollection, templateCollection *mongo.Collection) error {
+	err := migrationCollection.FindOne(ctx, bson.M{"_id": moneyMigrationID}).Err()
+	if err == nil {
+		return nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return fmt.Errorf("查询迁移记录失败: %v", err)
+	}
+
+	notMigrated := bson.M{"amount_unit": bson.M{"$ne": models.AmountUnitFen}}
+	products, err := productCollection.UpdateMany(ctx, notMigrated, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"price":       yuanToFenExpr("$price"),
+			"amount_unit": models.AmountUnitFen,
+		}}},
+	})
+	if err != nil {
+		return fmt.Errorf("迁移商品价格失败: %v", err)
+	}
+
+	orders, err := orderCollection.UpdateMany(ctx, notMigrated, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"total_price":   yuanToFenExpr("$total_price"),
+			"discount":      yuanToFenExpr("$discount"),
+			"subtotal":      yuanToFenExpr("$subtotal"),
+			"shipping_fee":  yuanToFenExpr("$shipping_fee"),
+			"pow_deduction": yuanToFenExpr("$pow_deduction"),
+			"items": bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$items", bson.A{}}},
+				"as":    "item",
+				"in":    bson.M{"$mergeObjects": bson.A{"$$item", bson.M{"price": yuanToFenExpr("$$item.price")}}},
+			}},
+			"amount_unit": models.AmountUnitFen,
+		}}},
+	})
+	if err != nil {
+		return fmt.Errorf("迁移订单金额失败: %v", err)
+	}
+
+	templates, err := templateCollection.UpdateMany(ctx, notMigrated, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"first_fee": yuanToFenExpr("$first_fee"),
+			"step_fee":  yuanToFenExpr("$step_fee"),
+			"free_over": yuanToFenExpr("$free_over"),
+			"surcharges": bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$surcharges", bson.A{}}},
+				"as":    "s",
+				"in":    bson.M{"$mergeObjects": bson.A{"$$s", bson.M{"fee": yuanToFenExpr("$$s.fee")}}},
+			}},
+			"amount_unit": models.AmountUnitFen,
+		}}},
+	})
+	if err != nil {
+		return fmt.Errorf("迁移运费模板失败: %v", err)
+	}
+
+	_, err = migrationCollection.InsertOne(ctx, bson.M{
+		"_id":          moneyMigrationID,
+		"products":     products.ModifiedCount,
+		"orders":       orders.ModifiedCount,
+		"templates":    templates.ModifiedCount,
+		"completed_at": time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("保存迁移记录失败: %v", err)
+	}
+	log.Printf("金额单位迁移完成: 商品 %d, 订单 %d, 运费模板 %d", products.ModifiedCount, orders.ModifiedCount, templates.ModifiedCount)
+	return nil
+}
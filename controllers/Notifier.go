@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Notifier 告警通知接口，可接入企业微信、钉钉、邮件等渠道
+type Notifier interface {
+	Notify(ctx context.Context, alert models.TreasuryAlert) error
+}
+
+// LogNotifier 仅写日志，未配置通知渠道时使用
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, alert models.TreasuryAlert) error {
+	log.Printf("[金库告警] %s: %s", alert.Type, alert.Message)
+	return nil
+}
+
+// WebhookNotifier 以 JSON 形式 POST 告警到指定地址
+type WebhookNotifier struct {
+	URL    string
+	client *http.Client
+}
+
+// NewWebhookNotifier 构造函数
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, alert models.TreasuryAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("告警通知返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewNotifierFromEnv 配置了 TREASURY_ALERT_WEBHOOK 时使用 Webhook 通知，否则写日志
+func NewNotifierFromEnv() Notifier {
+	if url := os.Getenv("TREASURY_ALERT_WEBHOOK"); url != "" {
+		return NewWebhookNotifier(url)
+	}
+	return LogNotifier{}
+}
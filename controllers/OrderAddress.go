@@ -0,0 +1,451 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 生成订单商品的收件地址快照
+func snapshotAddress(item *models.AddressItem, at time.Time) *models.ShippingAddress {
+	snapshot := &models.ShippingAddress{
+		RecipientName: item.RecipientName,
+		Phone:         item.Phone,
+		ProvinceCode:  item.ProvinceCode,
+		Province:      item.Province,
+		CityCode:      item.CityCode,
+		City:          item.City,
+		DistrictCode:  item.DistrictCode,
+		District:      item.District,
+		Detail:        item.Detail,
+		ZipCode:       item.ZipCode,
+		CapturedAt:    at,
+	}
+	// 尚未迁移的旧地址保留原有字段
+	if item.RecipientName == "" {
+		snapshot.FirstName = item.FirstName
+		snapshot.LastName = item.LastName
+		snapshot.Street = item.Street
+		snapshot.State = item.State
+	}
+	return snapshot
+}
+
+// 为没有地址快照的旧订单回填快照，地址已被删除的商品无法回填，计入 missing
+func (oc *OrderController) backfillAddressSnapshots(ctx context.Context) (updated, missing int, err error) {
+	cursor, err := oc.orderCollection.Find(ctx,
+		bson.M{"items": bson.M{"$elemMatch": bson.M{"shipping_address": bson.M{"$exists": false}}}},
+		options.Find().SetBatchSize(exportBatchSize))
+	if err != nil {
+		return 0, 0, fmt.Errorf("查询订单失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now()
+	batch := make([]models.Orders, 0, exportBatchSize)
+	flush := func() error {
+		var addressIDs []primitive.ObjectID
+		for _, order := range batch {
+			for _, item := range order.OrderItems {
+				if item.ShippingAddress == nil && !item.AddressItemRef.IsZero() {
+					addressIDs = append(addressIDs, item.AddressItemRef)
+				}
+			}
+		}
+		addresses, err := findAddressItems(ctx, oc.addressCollection, addressIDs)
+		if err != nil {
+			return err
+		}
+
+		var writes []mongo.WriteModel
+		for _, order := range batch {
+			fields := bson.M{}
+			filter := bson.M{"_id": order.ID}
+			for i, item := range order.OrderItems {
+				if item.ShippingAddress != nil {
+					continue
+				}
+				address, ok := addresses[item.AddressItemRef]
+				if !ok {
+					missing++
+					continue
+				}
+				// 按下标更新，同时校验下标处仍是同一个商品
+				fields[fmt.Sprintf("items.%d.shipping_address", i)] = snapshotAddress(address, now)
+				filter[fmt.Sprintf("items.%d.address_item_ref", i)] = item.AddressItemRef
+				filter[fmt.Sprintf("items.%d.shipping_address", i)] = bson.M{"$exists": false}
+				updated++
+			}
+			if len(fields) > 0 {
+				writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{"$set": fields}))
+			}
+		}
+		batch = batch[:0]
+		if len(writes) == 0 {
+			return nil
+		}
+		if _, err := oc.orderCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("回填地址快照失败: %v", err)
+		}
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var order models.Orders
+		if err := cursor.Decode(&order); err != nil {
+			return updated, missing, fmt.Errorf("解析订单失败: %v", err)
+		}
+		batch = append(batch, order)
+		if len(batch) == exportBatchSize {
+			if err := flush(); err != nil {
+				return updated, missing, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, missing, fmt.Errorf("遍历订单失败: %v", err)
+	}
+	if err := flush(); err != nil {
+		return updated, missing, err
+	}
+	return updated, missing, nil
+}
+
+// 启动时回填一次旧订单的地址快照
+func (oc *OrderController) startAddressBackfill() {
+	updated, missing, err := oc.backfillAddressSnapshots(oc.ctx)
+	if err != nil {
+		log.Printf("回填订单地址快照失败: %v", err)
+		return
+	}
+	if updated > 0 || missing > 0 {
+		log.Printf("回填订单地址快照完成: 更新 %d 个商品, %d 个商品的地址已删除无法回填", updated, missing)
+	}
+}
+
+// 后台手动回填订单地址快照
+func (oc *OrderController) BackfillAddressSnapshots(c *fiber.Ctx) error {
+	updated, missing, err := oc.backfillAddressSnapshots(c.Context())
+	if err != nil {
+		log.Printf("回填订单地址快照失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "updated": updated, "missing": missing})
+	}
+	return c.JSON(fiber.Map{
+		"message": "地址快照回填完成",
+		"updated": updated,
+		"missing": missing,
+	})
+}
+
+// 后台修正订单收件地址快照，每个被修改的商品都会记录修改前后的地址
+// 请求体中 product_id 按 product_id/size/color 定位商品，address_item_ref 定位发往同一地址的包裹，都为空时修改订单内全部商品
+// 地址按包裹修改：选中商品所在包裹（相同 address_item_ref）的全部商品一起修改，避免同一包裹的地址不一致
+// 包裹中已有商品发货或签收时需要传入 force 确认
+func (oc *OrderController) CorrectOrderAddress(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "未授权"})
+	}
+	adminID, err := primitive.ObjectIDFromHex(fmt.Sprint(claims["user_id"]))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "无效的用户ID"})
+	}
+
+	orderID, err := primitive.ObjectIDFromHex(c.Params("orderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID格式"})
+	}
+
+	// 地址字段与用户地址簿相同：recipient_name、phone、province_code、city_code、district_code、detail、zip_code
+	var req struct {
+		ProductID      string `json:"product_id"`
+		Size           string `json:"size"`
+		Color          string `json:"color"`
+		AddressItemRef string `json:"address_item_ref"`
+		Reason         string `json:"reason"`
+		Force          bool   `json:"force"` // 确认修改已发货或已签收的包裹
+		models.AddressItem
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求体"})
+	}
+	if err := normalizeAddressItem(&req.AddressItem); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	after := *snapshotAddress(&req.AddressItem, time.Now())
+	if strings.TrimSpace(req.Reason) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请填写修改原因"})
+	}
+
+	var order models.Orders
+	if err := oc.orderCollection.FindOne(c.Context(), bson.M{"_id": orderID}).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定订单"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+
+	var productRef, addressRef primitive.ObjectID
+	if req.ProductID != "" {
+		if productRef, err = primitive.ObjectIDFromHex(req.ProductID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的商品ID格式"})
+		}
+	}
+	if req.AddressItemRef != "" {
+		if addressRef, err = primitive.ObjectIDFromHex(req.AddressItemRef); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的地址ID格式"})
+		}
+	}
+
+	refs := correctionShipments(order.OrderItems, productRef, req.Size, req.Color, addressRef)
+	if len(refs) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "订单中未找到该商品"})
+	}
+	inShipments := func(item models.OrderItem) bool {
+		for _, ref := range refs {
+			if item.AddressItemRef == ref {
+				return true
+			}
+		}
+		return false
+	}
+
+	var audits []interface{}
+	for _, item := range order.OrderItems {
+		if !inShipments(item) {
+			continue
+		}
+		if !req.Force && (item.ShippingStatus == models.ShippingShipped || item.ShippingStatus == models.ShippingDelivered) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "包裹中已有商品发货或签收，确认修改请传入 force"})
+		}
+		audits = append(audits, models.OrderAddressAudit{
+			OrderRef:       order.ID,
+			ProductRef:     item.ProductRef,
+			Size:           item.Size,
+			Color:          item.Color,
+			AddressItemRef: item.AddressItemRef,
+			AdminRef:       adminID,
+			Before:         item.ShippingAddress,
+			After:          after,
+			Reason:         strings.TrimSpace(req.Reason),
+			CreatedAt:      after.CapturedAt,
+		})
+	}
+
+	// 先写审计记录，更新失败时记录中可以看到未生效的修改
+	if _, err := oc.addressAuditCollection.InsertMany(c.Context(), audits); err != nil {
+		log.Printf("写入地址修改记录失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "写入修改记录失败"})
+	}
+
+	filter := bson.M{"_id": order.ID}
+	if !req.Force {
+		// 查询之后包裹被发货时不修改
+		filter["items"] = bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"address_item_ref": bson.M{"$in": refs},
+			"shipping_status":  bson.M{"$in": bson.A{models.ShippingShipped, models.ShippingDelivered}},
+		}}}
+	}
+	update := bson.M{"$set": bson.M{"items.$[elem].shipping_address": after}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+		bson.M{"elem.address_item_ref": bson.M{"$in": refs}},
+	}})
+	result, err := oc.orderCollection.UpdateOne(c.Context(), filter, update, opts)
+	if err != nil {
+		log.Printf("修改订单地址失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "修改订单地址失败"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "包裹中已有商品发货或签收，确认修改请传入 force"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "收件地址已修改",
+		"updated_items":    len(audits),
+		"shipping_address": after,
+	})
+}
+
+// 按商品和收件地址选出要修改地址的包裹，返回包裹的 address_item_ref，条件都为空时选中全部包裹
+func correctionShipments(items []models.OrderItem, productRef primitive.ObjectID, size, color string, addressRef primitive.ObjectID) []primitive.ObjectID {
+	var refs []primitive.ObjectID
+	seen := make(map[primitive.ObjectID]bool)
+	for _, item := range items {
+		if !productRef.IsZero() && (item.ProductRef != productRef || item.Size != size || item.Color != color) {
+			continue
+		}
+		if !addressRef.IsZero() && item.AddressItemRef != addressRef {
+			continue
+		}
+		if !seen[item.AddressItemRef] {
+			seen[item.AddressItemRef] = true
+			refs = append(refs, item.AddressItemRef)
+		}
+	}
+	return refs
+}
+
+// 后台查询订单的地址修改记录，按时间倒序
+func (oc *OrderController) GetOrderAddressAudits(c *fiber.Ctx) error {
+	orderID, err := primitive.ObjectIDFromHex(c.Params("orderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID格式"})
+	}
+
+	cursor, err := oc.addressAuditCollection.Find(c.Context(), bson.M{"order_ref": orderID},
+		options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询修改记录失败"})
+	}
+	audits := []models.OrderAddressAudit{}
+	if err := cursor.All(c.Context(), &audits); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析修改记录失败"})
+	}
+	return c.JSON(fiber.Map{"audits": audits})
+}
+
+// shipmentAllocation 下单时为购物车中的一个商品指定收件地址，可将同一商品按数量拆分到多个地址
+type shipmentAllocation struct {
+	ProductRef     string `json:"product_ref"`
+	Size           string `json:"size"`
+	Color          string `json:"color"`
+	AddressItemRef string `json:"address_item_ref"`
+	Quantity       int    `json:"quantity"` // 为 0 时表示该商品剩余的全部数量
+}
+
+// addressSplit 购物车商品拆分到某个地址的数量
+type addressSplit struct {
+	AddressItemRef primitive.ObjectID
+	Quantity       int
+}
+
+// 按 allocations 将购物车商品分配到地址簿中的地址，未指定的数量使用默认地址
+// 返回值与 cartItems 一一对应
+func allocateCartItems(cartItems []models.CartItem, defaultRef primitive.ObjectID, allocations []shipmentAllocation, book map[primitive.ObjectID]*models.AddressItem) ([][]addressSplit, error) {
+	// 迁移后未能识别省市区的旧地址需要用户先修改
+	checkAddress := func(ref primitive.ObjectID) error {
+		if book[ref] == nil {
+			return fmt.Errorf("地址不存在")
+		}
+		if book[ref].NeedsReview {
+			return fmt.Errorf("收件地址 %s 需要重新选择省市区", book[ref].Detail)
+		}
+		return nil
+	}
+	if !defaultRef.IsZero() {
+		if err := checkAddress(defaultRef); err != nil {
+			return nil, err
+		}
+	}
+
+	splits := make([][]addressSplit, len(cartItems))
+	used := make([]bool, len(allocations))
+	for i, cartItem := range cartItems {
+		remaining := cartItem.Quantity
+		rest := primitive.NilObjectID // 承接剩余数量的地址
+		add := func(ref primitive.ObjectID, quantity int) {
+			for j := range splits[i] {
+				if splits[i][j].AddressItemRef == ref {
+					splits[i][j].Quantity += quantity
+					return
+				}
+			}
+			splits[i] = append(splits[i], addressSplit{AddressItemRef: ref, Quantity: quantity})
+		}
+
+		for j, a := range allocations {
+			if a.ProductRef != cartItem.ProductRef.Hex() || a.Size != cartItem.Size || a.Color != cartItem.Color {
+				continue
+			}
+			used[j] = true
+			ref, err := primitive.ObjectIDFromHex(a.AddressItemRef)
+			if err != nil {
+				return nil, fmt.Errorf("无效的地址ID格式")
+			}
+			if err := checkAddress(ref); err != nil {
+				return nil, err
+			}
+			switch {
+			case a.Quantity < 0:
+				return nil, fmt.Errorf("分配数量不能为负数")
+			case a.Quantity == 0:
+				if !rest.IsZero() {
+					return nil, fmt.Errorf("同一商品只能有一个地址承接剩余数量")
+				}
+				rest = ref
+			default:
+				if a.Quantity > remaining {
+					return nil, fmt.Errorf("分配数量超过购物车中的商品数量")
+				}
+				remaining -= a.Quantity
+				add(ref, a.Quantity)
+			}
+		}
+
+		if remaining > 0 {
+			if rest.IsZero() {
+				rest = defaultRef
+			}
+			if rest.IsZero() {
+				return nil, fmt.Errorf("商品数量未全部分配地址，请指定默认地址")
+			}
+			add(rest, remaining)
+		}
+	}
+
+	for j, ok := range used {
+		if !ok {
+			return nil, fmt.Errorf("购物车中没有与第 %d 个地址分配对应的商品", j+1)
+		}
+	}
+	return splits, nil
+}
+
+// OrderShipment 同一收件地址的商品，发往一个地址的商品作为一个包裹处理
+type OrderShipment struct {
+	AddressItemRef  primitive.ObjectID      `json:"address_item_ref"`
+	ShippingAddress *models.ShippingAddress `json:"shipping_address"`
+	Items           []models.OrderItem      `json:"items"`
+}
+
+// 按收件地址对订单商品分组，保持商品首次出现的顺序
+func groupShipments(items []models.OrderItem) []OrderShipment {
+	var shipments []OrderShipment
+	index := make(map[primitive.ObjectID]int)
+	for _, item := range items {
+		i, ok := index[item.AddressItemRef]
+		if !ok {
+			i = len(shipments)
+			index[item.AddressItemRef] = i
+			shipments = append(shipments, OrderShipment{
+				AddressItemRef:  item.AddressItemRef,
+				ShippingAddress: item.ShippingAddress,
+			})
+		}
+		shipments[i].Items = append(shipments[i].Items, item)
+	}
+	return shipments
+}
+
+// 按商品和可选的收件地址生成 arrayFilters 条件
+func orderItemElemFilter(productRef primitive.ObjectID, addressItemRef string) (bson.M, error) {
+	filter := bson.M{"elem.product_ref": productRef}
+	if addressItemRef != "" {
+		ref, err := primitive.ObjectIDFromHex(addressItemRef)
+		if err != nil {
+			return nil, fmt.Errorf("无效的地址ID")
+		}
+		filter["elem.address_item_ref"] = ref
+	}
+	return filter, nil
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 修改地址时按包裹选中商品，同一包裹的其他商品也要一起修改
+func TestCorrectionShipments(t *testing.T) {
+	hoodie, hat := primitive.NewObjectID(), primitive.NewObjectID()
+	home, office := primitive.NewObjectID(), primitive.NewObjectID()
+	items := []models.OrderItem{
+		{ProductRef: hoodie, Size: "L", Color: "黑", AddressItemRef: home},
+		{ProductRef: hoodie, Size: "L", Color: "黑", AddressItemRef: office},
+		{ProductRef: hat, AddressItemRef: home},
+	}
+
+	cases := []struct {
+		name       string
+		product    primitive.ObjectID
+		size       string
+		addressRef primitive.ObjectID
+		want       []primitive.ObjectID
+	}{
+		{"whole order", primitive.NilObjectID, "", primitive.NilObjectID, []primitive.ObjectID{home, office}},
+		{"product split across addresses", hoodie, "L", primitive.NilObjectID, []primitive.ObjectID{home, office}},
+		{"product in one package", hat, "", primitive.NilObjectID, []primitive.ObjectID{home}},
+		{"product and address", hoodie, "L", office, []primitive.ObjectID{office}},
+		{"unknown size", hoodie, "XL", primitive.NilObjectID, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			color := ""
+			if tc.product == hoodie {
+				color = "黑"
+			}
+			got := correctionShipments(items, tc.product, tc.size, color, tc.addressRef)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("correctionShipments = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
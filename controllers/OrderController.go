@@ -2,13 +2,12 @@ package controllers
 
 import (
 	"blog-auth-server/models"
+	"blog-auth-server/money"
 	"context"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
-	"os"
-	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,7 +25,6 @@ import (
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/gofiber/fiber/v2"
 	"github.com/smartwalle/alipay/v3"
-	"github.com/xuri/excelize/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -42,10 +40,18 @@ type OrderController struct {
 	statisticsCollection *mongo.Collection
 	ctx                  context.Context
 	alipayClient         *alipay.Client
+	powController        *PowController
+	treasuryController   *TreasuryController
+	// 管理员修改订单收件地址的记录
+	addressAuditCollection *mongo.Collection
+	// 结算时计算运费
+	shippingController *ShippingController
+	// 报价按所选货币附带展示金额
+	currencyController *CurrencyController
 }
 
 // NewCartController 构造函数
-func NewOrderController(userCollection, cartCollection, productCollection, orderCollection, addressCollection, statisticsCollection *mongo.Collection, ctx context.Context, alipayClient *alipay.Client) *OrderController {
+func NewOrderController(userCollection, cartCollection, productCollection, orderCollection, addressCollection, statisticsCollection, addressAuditCollection *mongo.Collection, ctx context.Context, alipayClient *alipay.Client, powController *PowController, treasuryController *TreasuryController, shippingController *ShippingController, currencyController *CurrencyController) *OrderController {
 	oc := &OrderController{
 		userCollection:       userCollection,
 		cartCollection:       cartCollection,
@@ -55,9 +61,17 @@ func NewOrderController(userCollection, cartCollection, productCollection, order
 		statisticsCollection: statisticsCollection,
 		ctx:                  ctx,
 		alipayClient:         alipayClient,
+		powController:        powController,
+		treasuryController:   treasuryController,
+		// 地址修改记录
+		addressAuditCollection: addressAuditCollection,
+		shippingController:     shippingController,
+		currencyController:     currencyController,
 	}
 	// 启动自动清理 goroutine
 	go oc.startAutoCleanup()
+	// 为旧订单回填收件地址快照
+	go oc.startAddressBackfill()
 	return oc
 }
 
@@ -97,85 +111,41 @@ func (oc *OrderController) AddOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"}) // 如果转换失败，处理错误
 	}
 
-	// 获取用户的购物车
-	var cart models.Cart
-	err = oc.cartCollection.FindOne(oc.ctx, bson.M{"user_ref": userID}).Decode(&cart)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "购物车为空"})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取购物车失败"})
-	}
-
-	// 获取订单地址信息
+	// 下单必须使用结算页获取的报价，金额以服务端重新计算的结果为准
 	var orderRequest struct {
-		AddressItemRef string `json:"address_item_ref"`
+		QuoteID string `json:"quote_id"`
 	}
-
-	// 解析请求体
-	if err := c.BodyParser(&orderRequest); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求体"})
+	if err := c.BodyParser(&orderRequest); err != nil || orderRequest.QuoteID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请先确认订单金额"})
 	}
-
-	// 检查地址ID是否为空
-	if orderRequest.AddressItemRef == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "地址ID不能为空"})
+	quoteRef, quoteRequest, digest, err := parseQuoteID(orderRequest.QuoteID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// 将字符串转换为 ObjectID
-	addressItemRef, err := primitive.ObjectIDFromHex(orderRequest.AddressItemRef)
+	// 每个报价只能创建一个订单；并发的重复提交由 quote_id 唯一索引拦截
+	used, err := oc.orderCollection.CountDocuments(oc.ctx, bson.M{"quote_id": quoteRef})
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的地址ID格式"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "创建订单失败"})
+	}
+	if used > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "该报价已下单，请勿重复提交"})
 	}
 
-	// 现在可以使用 addressItemRef 进行后续操作
-	// 验证地址是否存在
-	var address models.Address
-	err = oc.addressCollection.FindOne(oc.ctx, bson.M{
-		"user_ref":           userID,
-		"address_detail._id": addressItemRef,
-	}).Decode(&address)
+	// 按报价时的参数重新计算，购物车、价格、地址或 Pow 余额变化时返回新的报价
+	quote, err := oc.buildQuote(oc.ctx, userID, quoteRequest)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid address"})
+		return quoteErrorResponse(c, err)
 	}
-
-	// 创建订单项
-	var orderItems []models.OrderItem
-	var totalPrice uint64 = 0
-
-	for _, cartItem := range cart.CartItems {
-		log.Printf("正在处理购物车项: ProductRef=%v, Quantity=%d", cartItem.ProductRef, cartItem.Quantity)
-
-		// 获取产品信息
-		var product models.Product
-		err := oc.productCollection.FindOne(oc.ctx, bson.M{"_id": cartItem.ProductRef}).Decode(&product)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				log.Printf("未找到产品: ProductRef=%v", cartItem.ProductRef)
-				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到产品", "product_ref": cartItem.ProductRef})
-			}
-			log.Printf("获取产品信息时发生错误: %v", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取产品信息失败", "details": err.Error()})
-		}
-
-		log.Printf("成功获取产品信息: ProductID=%v, Price=%d", product.ID, product.Price)
-
-		orderItem := models.OrderItem{
-			ProductRef:     cartItem.ProductRef,
-			Quantity:       cartItem.Quantity,
-			Size:           cartItem.Size,
-			Color:          cartItem.Color,
-			Price:          product.Price,
-			DeliverID:      "", // 初始为空，后续可更新
-			ShippingStatus: "待发货",
-			AddressItemRef: addressItemRef,
+	if quote.digest() != digest {
+		if err := quote.sign(userID, quoteRequest, time.Now()); err != nil {
+			log.Printf("签发订单报价失败: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "计算订单金额失败"})
 		}
-		orderItems = append(orderItems, orderItem)
-		totalPrice += uint64(cartItem.Quantity) * product.Price
-		log.Printf("添加订单项: ProductRef=%v, Quantity=%d, Price=%d", orderItem.ProductRef, orderItem.Quantity, orderItem.Price)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "订单金额已变化，请重新确认", "quote": quote})
 	}
 
-	log.Printf("订单项处理完成，总价: %d", totalPrice)
+	log.Printf("订单项处理完成，应付金额: %d", quote.Payable)
 
 	// // 更新所有没有 is_redeemed 字段的文档
 	// result, err := oc.orderCollection.UpdateMany(
@@ -219,17 +189,41 @@ func (oc *OrderController) AddOrder(c *fiber.Ctx) error {
 	newOrder := models.Orders{
 		ID:            primitive.NewObjectID(),
 		UserRef:       userID,
-		OrderItems:    orderItems,
-		TotalPrice:    totalPrice,
-		Discount:      0, // 可以根据需求设置折扣
+		OrderItems:    quote.items,
+		TotalPrice:    quote.Payable,
+		Discount:      quote.Discount,
 		PaymentStatus: "待支付",
 		CreatedAt:     time.Now(),
 		IsRedeemed:    false, // 初始化新字段
+		Subtotal:      quote.Subtotal,
+		ShippingFee:   quote.ShippingFee,
+		PowOffset:     quote.PowOffset,
+		PowDeduction:  quote.PowDeduction,
+		QuoteID:       quoteRef,
+		FxRate:        quote.FxRate,
+		AmountUnit:    models.AmountUnitFen,
+	}
+
+	// 扣除抵扣使用的 Pow，余额不足时不会扣减
+	if newOrder.PowOffset > 0 {
+		result, err := oc.userCollection.UpdateOne(oc.ctx,
+			bson.M{"_id": userID, "pow": bson.M{"$gte": newOrder.PowOffset}},
+			bson.M{"$inc": bson.M{"pow": -newOrder.PowOffset}})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "扣除抵扣权证失败"})
+		}
+		if result.ModifiedCount == 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "用户权证数量不足，请重新确认订单"})
+		}
 	}
 
 	// 将订单保存到数据库
 	_, err = oc.orderCollection.InsertOne(oc.ctx, newOrder)
 	if err != nil {
+		oc.refundPowOffset(newOrder)
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "该报价已下单，请勿重复提交"})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "创建订单失败"})
 	}
 
@@ -249,10 +243,10 @@ func (oc *OrderController) AddOrder(c *fiber.Ctx) error {
 		Trade: alipay.Trade{
 			Subject:        "订单支付",
 			OutTradeNo:     newOrder.ID.Hex(),
-			TotalAmount:    fmt.Sprintf("%.2f", float64(newOrder.TotalPrice)), // 假设 TotalPrice 是以分为单位
-			ProductCode:    "FACE_TO_FACE_PAYMENT",                            // 面对面支付的产品码
-			Body:           fmt.Sprintf("订单 %s 的支付", newOrder.ID.Hex()),       // 可选：订单描述
-			TimeoutExpress: "15m",                                             // 可选：订单超时时间，这里设置为15分钟
+			TotalAmount:    newOrder.TotalPrice.String(),                // TotalPrice 以分为单位，支付宝使用元
+			ProductCode:    "FACE_TO_FACE_PAYMENT",                      // 面对面支付的产品码
+			Body:           fmt.Sprintf("订单 %s 的支付", newOrder.ID.Hex()), // 可选：订单描述
+			TimeoutExpress: "15m",                                       // 可选：订单超时时间，这里设置为15分钟
 		},
 	}
 	rsp, err := oc.alipayClient.TradePreCreate(c.Context(), p)
@@ -426,18 +420,36 @@ func (oc *OrderController) GetOneOrderByID(c *fiber.Ctx) error {
 		"order_items":          order.OrderItems,
 		"total_price":          order.TotalPrice,
 		"discount":             order.Discount,
+		"subtotal":             order.Subtotal,
+		"shipping_fee":         order.ShippingFee,
+		"pow_deduction":        order.PowDeduction,
 		"payment_status":       order.PaymentStatus,
 		"payment_time":         order.PaymentTime,
 		"buyer_alipay_account": order.BuyerAlipayAccount,
 		"created_at":           order.CreatedAt,
 		"is_redeemed":          order.IsRedeemed,
+		"pow_award":            order.PowAward,
+		"shipments":            groupShipments(order.OrderItems), // 按收件地址分组的包裹
 	}
 
 	return c.JSON(response)
 }
 
 func (oc *OrderController) CreateQRCode(c *fiber.Ctx) error {
-	// 从请求中获取订单信息
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "未授权访问"})
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "无效的用户ID"})
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的用户ID格式"})
+	}
+
+	// 从请求中获取订单信息，金额只用于核对，以订单中保存的应付金额为准
 	var orderInfo struct {
 		OrderID string `json:"order_id"`
 		Amount  string `json:"amount"`
@@ -445,13 +457,36 @@ func (oc *OrderController) CreateQRCode(c *fiber.Ctx) error {
 	if err := c.BodyParser(&orderInfo); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	orderID, err := primitive.ObjectIDFromHex(orderInfo.OrderID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
+	}
+
+	// 只能为自己的待支付订单生成二维码
+	var order models.Orders
+	err = oc.orderCollection.FindOne(oc.ctx, bson.M{"_id": orderID, "user_ref": userID}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "订单不存在或无权访问"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+	if order.PaymentStatus != "待支付" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "订单不是待支付状态"})
+	}
+	amount := order.TotalPrice.String()
+	if orderInfo.Amount != "" {
+		if value, err := money.Parse(orderInfo.Amount); err != nil || value != order.TotalPrice {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "支付金额与订单金额不符"})
+		}
+	}
 
 	// 创建支付宝当面付请求
 	var p = alipay.TradePreCreate{
 		Trade: alipay.Trade{
 			Subject:     "订单支付",
-			OutTradeNo:  orderInfo.OrderID,
-			TotalAmount: orderInfo.Amount,
+			OutTradeNo:  order.ID.Hex(),
+			TotalAmount: amount,
 			ProductCode: "FACE_TO_FACE_PAYMENT", // 面对面支付的产品码
 		},
 	}
@@ -461,7 +496,20 @@ func (oc *OrderController) CreateQRCode(c *fiber.Ctx) error {
 	}
 
 	// 返回二维码链接
-	return c.JSON(fiber.Map{"qr_code": rsp.QRCode})
+	return c.JSON(fiber.Map{"qr_code": rsp.QRCode, "amount": amount})
+}
+
+// 支付宝返回的实付金额，与订单应付金额不一致时记录日志，订单金额保持不变
+func paidAmount(order models.Orders, alipayAmount string) money.Fen {
+	amount, err := money.Parse(alipayAmount)
+	if err != nil {
+		log.Printf("解析支付宝金额失败 (OrderID: %s, Amount: %q): %v", order.ID.Hex(), alipayAmount, err)
+		return order.TotalPrice
+	}
+	if amount != order.TotalPrice {
+		log.Printf("支付宝实付金额与订单金额不一致 (OrderID: %s): 实付 %s 元, 应付 %s 元", order.ID.Hex(), amount, order.TotalPrice)
+	}
+	return amount
 }
 
 // 结算页面用户手动查询订单以更新
@@ -504,7 +552,7 @@ func (oc *OrderController) QueryOrder(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"order_id":     orderID,
 			"status":       order.PaymentStatus,
-			"total_amount": float64(order.TotalPrice) / 100, // 假设TotalPrice以分为单位
+			"total_amount": order.TotalPrice.Yuan(),
 			"pay_time":     order.PaymentTime,
 		})
 	}
@@ -522,8 +570,7 @@ func (oc *OrderController) QueryOrder(c *fiber.Ctx) error {
 	if rsp.TradeStatus == "TRADE_SUCCESS" {
 		// 更新订单状态
 		paymentTime, _ := time.Parse("2006-01-02 15:04:05", rsp.SendPayDate)
-		amountFloat, _ := strconv.ParseFloat(rsp.TotalAmount, 64)
-		totalAmount := uint64(math.Round(amountFloat * 100))
+		totalAmount := paidAmount(order, rsp.TotalAmount)
 
 		update := bson.M{
 			"$set": bson.M{
@@ -531,24 +578,24 @@ func (oc *OrderController) QueryOrder(c *fiber.Ctx) error {
 				"alipay_trade_no":      rsp.TradeNo,
 				"payment_time":         paymentTime,
 				"buyer_alipay_account": rsp.BuyerLogonId,
-				"total_price":          totalAmount / 100,
 			},
 		}
-		//在此处添加增加用户的积分
-		powToAdd := float64(totalAmount) / 100 // 假设每消费1元增加1 Pow
-		userUpdate := bson.M{
-			"$inc": bson.M{"pow": powToAdd},
-		}
-
-		_, err = oc.userCollection.UpdateOne(oc.ctx, bson.M{"_id": userID}, userUpdate)
+		// 只有待支付的订单才会被更新，避免与自动查询重复处理
+		result, err := oc.orderCollection.UpdateOne(oc.ctx, bson.M{"_id": objectID, "payment_status": "待支付"}, update)
 		if err != nil {
-			log.Printf("更新用户Pow失败: %v", err)
-			// 注意：这里我们继续处理，因为订单已经支付成功
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新订单状态失败"})
 		}
 
-		_, err = oc.orderCollection.UpdateOne(oc.ctx, bson.M{"_id": objectID}, update)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新订单状态失败"})
+		// 按权证规则为用户发放 Pow
+		var powAwarded float64
+		if result.ModifiedCount > 0 {
+			award, err := oc.powController.AwardOrderPow(order, totalAmount.Yuan())
+			if err != nil {
+				log.Printf("发放用户Pow失败: %v", err)
+				// 注意：这里我们继续处理，因为订单已经支付成功
+			} else if award != nil {
+				powAwarded = award.Amount
+			}
 		}
 
 		// 清空用户的购物车
@@ -565,8 +612,9 @@ func (oc *OrderController) QueryOrder(c *fiber.Ctx) error {
 			"message":      "订单已支付",
 			"order_id":     orderID,
 			"trade_status": rsp.TradeStatus,
-			"total_amount": float64(totalAmount) / 100,
+			"total_amount": totalAmount.Yuan(),
 			"pay_time":     paymentTime,
+			"pow_awarded":  powAwarded,
 		})
 	}
 
@@ -635,8 +683,7 @@ func (oc *OrderController) QueryOrderAuto(c *fiber.Ctx) error {
 		if rsp.TradeStatus == "TRADE_SUCCESS" {
 			// 更新订单状态
 			paymentTime, _ := time.Parse("2006-01-02 15:04:05", rsp.SendPayDate)
-			amountFloat, _ := strconv.ParseFloat(rsp.TotalAmount, 64)
-			totalAmount := uint64(math.Round(amountFloat * 100))
+			totalAmount := paidAmount(order, rsp.TotalAmount)
 
 			update := bson.M{
 				"$set": bson.M{
@@ -644,34 +691,35 @@ func (oc *OrderController) QueryOrderAuto(c *fiber.Ctx) error {
 					"alipay_trade_no":      rsp.TradeNo,
 					"payment_time":         paymentTime,
 					"buyer_alipay_account": rsp.BuyerLogonId,
-					"total_price":          totalAmount / 100,
 				},
 			}
 
-			// 更新订单
-			_, err = oc.orderCollection.UpdateOne(oc.ctx, bson.M{"_id": order.ID}, update)
+			// 更新订单，只有待支付的订单才会被更新
+			result, err := oc.orderCollection.UpdateOne(oc.ctx, bson.M{"_id": order.ID, "payment_status": "待支付"}, update)
 			if err != nil {
 				log.Printf("更新订单状态失败 (OrderID: %s): %v", order.ID.Hex(), err)
 				continue
 			}
-
-			// 增加用户的积分
-			powToAdd := float64(totalAmount) / 100 // 假设每消费1元增加1 Pow
-			userUpdate := bson.M{
-				"$inc": bson.M{"pow": powToAdd},
+			if result.ModifiedCount == 0 {
+				continue
 			}
 
-			_, err = oc.userCollection.UpdateOne(oc.ctx, bson.M{"_id": userID}, userUpdate)
+			// 按权证规则为用户发放 Pow
+			var powAwarded float64
+			award, err := oc.powController.AwardOrderPow(order, totalAmount.Yuan())
 			if err != nil {
-				log.Printf("更新用户Pow失败 (UserID: %s): %v", userID.Hex(), err)
+				log.Printf("发放用户Pow失败 (UserID: %s): %v", userID.Hex(), err)
 				// 注意：这里我们继续处理，因为订单已经更新成功
+			} else if award != nil {
+				powAwarded = award.Amount
 			}
 
 			updatedOrders = append(updatedOrders, fiber.Map{
 				"order_id":     order.ID.Hex(),
 				"status":       "已支付",
-				"total_amount": float64(totalAmount) / 100,
+				"total_amount": totalAmount.Yuan(),
 				"pay_time":     paymentTime,
+				"pow_awarded":  powAwarded,
 			})
 		}
 	}
@@ -690,135 +738,6 @@ func (oc *OrderController) QueryOrderAuto(c *fiber.Ctx) error {
 	}
 }
 
-// 后台导出订单到excelExportOrders
-func (oc *OrderController) ExportOrders(c *fiber.Ctx) error {
-	f := excelize.NewFile()
-	sheetName := "订单详情"
-	_, err := f.NewSheet(sheetName)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "创建工作表失败"})
-	}
-
-	// 设置列标题
-	titles := []string{"订单ID", "用户ID", "支付状态", "支付时间", "创建时间",
-		"商品ID", "商品数量", "商品尺寸", "商品颜色",
-		"收件人姓名", "收件人电话", "收件地址"}
-	for i, title := range titles {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		f.SetCellValue(sheetName, cell, title)
-	}
-
-	// 查询已支付的订单
-	cursor, err := oc.orderCollection.Find(oc.ctx, bson.M{"payment_status": "已支付"})
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
-	}
-	defer cursor.Close(oc.ctx)
-
-	var orders []models.Orders
-	if err = cursor.All(oc.ctx, &orders); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析订单失败"})
-	}
-
-	// 检查是否有已支付的订单
-	if len(orders) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "没有找到已支付的订单"})
-	}
-
-	row := 2
-	for _, order := range orders {
-		for _, item := range order.OrderItems {
-			// 使用 AddressItemRef 查询地址信息
-			var address models.Address
-			err := oc.addressCollection.FindOne(
-				oc.ctx,
-				bson.M{"address_detail._id": item.AddressItemRef},
-			).Decode(&address)
-
-			var targetAddress models.AddressItem
-			if err != nil {
-				log.Printf("查询地址信息失败: OrderID=%s, ItemID=%s, AddressID=%s, Error=%v",
-					order.ID.Hex(), item.ProductRef.Hex(), item.AddressItemRef.Hex(), err)
-				// 使用默认值
-				targetAddress = models.AddressItem{
-					FirstName: "未知",
-					LastName:  "未知",
-					Phone:     "未知",
-					Street:    "未知",
-					City:      "未知",
-					State:     "未知",
-					ZipCode:   "未知",
-				}
-			} else {
-				// 在地址列表中查找匹配的地址项
-				for _, addr := range address.AddressDetails {
-					if addr.ID == item.AddressItemRef {
-						targetAddress = addr
-						break
-					}
-				}
-				if targetAddress.ID.IsZero() {
-					log.Printf("未找到匹配的地址项: OrderID=%s, ItemID=%s, AddressID=%s",
-						order.ID.Hex(), item.ProductRef.Hex(), item.AddressItemRef.Hex())
-					// 使用默认值
-					targetAddress = models.AddressItem{
-						FirstName: "未知",
-						LastName:  "未知",
-						Phone:     "未知",
-						Street:    "未知",
-						City:      "未知",
-						State:     "未知",
-						ZipCode:   "未知",
-					}
-				}
-			}
-
-			// 设置单元格值
-			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), order.ID.Hex())
-			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), order.UserRef.Hex())
-			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), order.PaymentStatus)
-			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), order.PaymentTime.Format("2006-01-02 15:04:05"))
-			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), order.CreatedAt.Format("2006-01-02 15:04:05"))
-			f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), item.ProductRef.Hex())
-			f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), item.Quantity)
-			f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), item.Size)
-			f.SetCellValue(sheetName, fmt.Sprintf("I%d", row), item.Color)
-			f.SetCellValue(sheetName, fmt.Sprintf("J%d", row), targetAddress.FirstName+" "+targetAddress.LastName)
-			f.SetCellValue(sheetName, fmt.Sprintf("K%d", row), targetAddress.Phone)
-			f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), fmt.Sprintf("%s, %s, %s %s", targetAddress.State, targetAddress.City, targetAddress.Street, targetAddress.ZipCode))
-
-			row++
-		}
-	}
-	// 设置活动工作表
-	sheetIndex, err := f.GetSheetIndex(sheetName)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "设置活动工作表失败"})
-	}
-	f.SetActiveSheet(sheetIndex)
-
-	// 生成文件名和设置响应头
-	currentTime := time.Now()
-	fileName := fmt.Sprintf("OrderExport_%s.xlsx", currentTime.Format("20060102_1504"))
-	c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
-
-	tempFile := path.Join(os.TempDir(), fileName)
-	if err := f.SaveAs(tempFile); err != nil {
-		log.Printf("保存临时文件失败: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "生成 Excel 文件失败"})
-	}
-	defer os.Remove(tempFile)
-
-	return c.Download(tempFile, fileName)
-	// // 将文件写入响应
-	// if err := f.Write(c.Response().BodyWriter()); err != nil {
-	// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "生成 Excel 文件失败"})
-	// }
-	// log.Printf("成功生成文件: %s", fileName)
-	// return nil
-}
-
 // GetAllOrders 查询所有订单
 // GET /orders?page=1&limit=20
 func (oc *OrderController) GetAllOrders(c *fiber.Ctx) error {
@@ -900,6 +819,8 @@ func (oc *OrderController) UpdateOrderItemShippingStatus(c *fiber.Ctx) error {
 		OrderID   string `json:"order_id"`
 		ProductID string `json:"product_id"`
 		Status    string `json:"status"`
+		// 商品拆分到多个地址时，可只更新发往该地址的部分
+		AddressItemRef string `json:"address_item_ref"`
 	}
 
 	if err := c.BodyParser(&updateInfo); err != nil {
@@ -927,13 +848,20 @@ func (oc *OrderController) UpdateOrderItemShippingStatus(c *fiber.Ctx) error {
 		})
 	}
 
+	elemFilter, err := orderItemElemFilter(productID, updateInfo.AddressItemRef)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// 更新订单项的发货状态
 	filter := bson.M{"_id": orderID, "items.product_ref": productID}
 	update := bson.M{"$set": bson.M{"items.$[elem].shipping_status": updateInfo.Status}}
 
 	// 使用 arrayFilters 来匹配所有符合条件的元素
 	opts := options.Update().SetArrayFilters(options.ArrayFilters{
-		Filters: []interface{}{bson.M{"elem.product_ref": productID}},
+		Filters: []interface{}{elemFilter},
 	})
 
 	result, err := oc.orderCollection.UpdateOne(oc.ctx, filter, update, opts)
@@ -964,6 +892,9 @@ func (oc *OrderController) UpdateOrderItemDeliverID(c *fiber.Ctx) error {
 		OrderID   string `json:"order_id"`
 		ProductID string `json:"product_id"`
 		DeliverID string `json:"deliver_id"`
+		Carrier   string `json:"carrier"` // 快递公司名称或编码，可选
+		// 商品拆分到多个地址时，可只更新发往该地址的部分
+		AddressItemRef string `json:"address_item_ref"`
 	}
 	if err := c.BodyParser(&updateInfo); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -989,12 +920,23 @@ func (oc *OrderController) UpdateOrderItemDeliverID(c *fiber.Ctx) error {
 		})
 	}
 
+	elemFilter, err := orderItemElemFilter(productID, updateInfo.AddressItemRef)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// 更新订单项的快递单号
 	filter := bson.M{"_id": orderID, "items.product_ref": productID}
-	update := bson.M{"$set": bson.M{"items.$[elem].deliver_id": updateInfo.DeliverID}}
+	fields := bson.M{"items.$[elem].deliver_id": updateInfo.DeliverID}
+	if updateInfo.Carrier != "" {
+		fields["items.$[elem].carrier"] = normalizeCarrier(updateInfo.Carrier)
+	}
+	update := bson.M{"$set": fields}
 	// 使用 arrayFilters 来匹配所有符合条件的元素
 	opts := options.Update().SetArrayFilters(options.ArrayFilters{
-		Filters: []interface{}{bson.M{"elem.product_ref": productID}},
+		Filters: []interface{}{elemFilter},
 	})
 
 	result, err := oc.orderCollection.UpdateOne(oc.ctx, filter, update, opts)
@@ -1099,30 +1041,44 @@ func (oc *OrderController) cleanupUnpaidOrders() {
 		return
 	}
 
-	var totalAmountSaved int64 // 改用 int64 来存储总金额（单位：元）
+	var totalAmountSaved money.Fen
 	var orders []models.Orders
 	cursor, err := oc.orderCollection.Find(oc.ctx, filter)
 	if err == nil {
 		if err = cursor.All(oc.ctx, &orders); err == nil {
 			for _, order := range orders {
-				totalAmountSaved += int64(order.TotalPrice) // 直接累加，不进行单位转换
+				totalAmountSaved += order.TotalPrice
 			}
 		}
 	}
 
-	result, err := oc.orderCollection.DeleteMany(oc.ctx, filter)
+	// 使用了 Pow 抵扣的订单逐个删除，删除成功后再退回 Pow，避免与支付确认并发时重复退回
+	var refundedCount int64
+	for _, order := range orders {
+		if order.PowOffset <= 0 {
+			continue
+		}
+		deleted, err := oc.orderCollection.DeleteOne(oc.ctx, bson.M{"_id": order.ID, "payment_status": order.PaymentStatus})
+		if err != nil || deleted.DeletedCount == 0 {
+			continue
+		}
+		oc.refundPowOffset(order)
+		refundedCount++
+	}
+
+	result, err := oc.orderCollection.DeleteMany(oc.ctx, bson.M{"$and": []bson.M{filter, {"pow_offset": bson.M{"$not": bson.M{"$gt": 0}}}}})
 	if err != nil {
 		log.Printf("自动删除未支付订单失败: %v", err)
 		return
 	}
+	deletedCount := result.DeletedCount + refundedCount
 
-	// 在日志输出时进行单位转换
-	log.Printf("自动清理: 删除了 %d 个订单, 总金额: %.2f 元", result.DeletedCount, float64(totalAmountSaved))
+	log.Printf("自动清理: 删除了 %d 个订单, 总金额: %s 元", deletedCount, totalAmountSaved)
 
 	stats := models.OrderCleanupStatistics{
 		CleanupDate:      time.Now(),
-		DeletedCount:     result.DeletedCount,
-		TotalAmountSaved: float64(totalAmountSaved), // 存储到数据库时转换为元
+		DeletedCount:     deletedCount,
+		TotalAmountSaved: totalAmountSaved.Yuan(), // 统计数据以元保存
 	}
 
 	_, err = oc.statisticsCollection.InsertOne(oc.ctx, stats)
@@ -1131,38 +1087,6 @@ func (oc *OrderController) cleanupUnpaidOrders() {
 	}
 }
 
-// 展示后台销售数据GetSales
-func (oc *OrderController) GetSales(c *fiber.Ctx) error {
-
-	return c.JSON(fiber.Map{
-		"message": "展示后台销售数据",
-	})
-}
-
-// 展示后台浏览数据GetVisitors
-func (oc *OrderController) GetVisitors(c *fiber.Ctx) error {
-
-	return c.JSON(fiber.Map{
-		"message": "展示后台浏览数据",
-	})
-}
-
-// 展示后台销售数据分析GetSalesAnalytics
-func (oc *OrderController) GetSalesAnalytics(c *fiber.Ctx) error {
-
-	return c.JSON(fiber.Map{
-		"message": "展示后台销售数据分析",
-	})
-}
-
-// 展示后台浏览数据分析GetVisitorsAnalytics
-func (oc *OrderController) GetVisitorsAnalytics(c *fiber.Ctx) error {
-
-	return c.JSON(fiber.Map{
-		"message": "展示后台浏览数据分析",
-	})
-}
-
 // 提现权证
 var (
 	sclTokenMint   = "5iSZFcoi4NRqGPDH6hLxEyimczqr4i7ynS3aG21GPNTQ"
@@ -1501,18 +1425,19 @@ func transferSOLAsync(client *rpc.Client, fromAccount solana.PrivateKey, toPubli
 }
 
 // 检查接收方 SOL 余额，新账户创建并转账，有sol的账户不创建不转账
-func checkAndTransferSOL(client *rpc.Client, fromAccount solana.PrivateKey, toPublicKey solana.PublicKey) (bool, error) {
+// 返回实际转出的 SOL 数量，未转账时为 0
+func checkAndTransferSOL(client *rpc.Client, fromAccount solana.PrivateKey, toPublicKey solana.PublicKey) (float64, error) {
 
 	// 获取接收方账户信息
 	accountInfo, err := client.GetAccountInfo(context.Background(), toPublicKey)
 	if err != nil && !strings.Contains(err.Error(), "not found") {
-		return false, fmt.Errorf("获取接收方账户信息失败: %v", err)
+		return 0, fmt.Errorf("获取接收方账户信息失败: %v", err)
 	}
 
 	// 计算最小所需余额
 	minBalance, err := getMinimumBalanceWithBuffer(client)
 	if err != nil {
-		return false, fmt.Errorf("计算最小余额失败: %v", err)
+		return 0, fmt.Errorf("计算最小余额失败: %v", err)
 	}
 
 	var transferAmount float64
@@ -1526,7 +1451,7 @@ func checkAndTransferSOL(client *rpc.Client, fromAccount solana.PrivateKey, toPu
 		fmt.Printf("接收方余额不足，需要转入 %f SOL\n", transferAmount)
 	} else {
 		fmt.Printf("接收方余额充足，无需转账 SOL\n")
-		return false, nil // 不需要转账
+		return 0, nil // 不需要转账
 	}
 	fmt.Printf("准备转账，发送方: %s, 接收方: %s, 金额: %f SOL\n",
 		fromAccount.PublicKey(), toPublicKey, transferAmount)
@@ -1538,11 +1463,11 @@ func checkAndTransferSOL(client *rpc.Client, fromAccount solana.PrivateKey, toPu
 	select {
 	case err := <-solResultChan:
 		if err != nil {
-			return false, fmt.Errorf("SOL 转账失败: %v", err)
+			return 0, fmt.Errorf("SOL 转账失败: %v", err)
 		}
-		return true, nil // 转账成功
+		return transferAmount, nil // 转账成功
 	case <-time.After(30 * time.Second):
-		return false, fmt.Errorf("SOL 转账超时")
+		return 0, fmt.Errorf("SOL 转账超时")
 	}
 }
 
@@ -1632,6 +1557,7 @@ func (oc *OrderController) TransferSCL(c *fiber.Ctx) error {
 
 	// 检查并转账 SOL（如果需要）
 	solTransferred, err := checkAndTransferSOL(solClient, fromAccount, toPublicKey)
+	oc.treasuryController.RecordOutflow(models.AssetSOL, solTransferred, userID, user.PowAddress, "提现手续费补充")
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "SOL 转账检查失败: " + err.Error()})
 	}
@@ -1644,6 +1570,7 @@ func (oc *OrderController) TransferSCL(c *fiber.Ctx) error {
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "SCL 转账失败: " + err.Error()})
 		}
+		oc.treasuryController.RecordOutflow(models.AssetSCL, req.Amount, userID, user.PowAddress, "权证提现")
 		// SCL 转账成功，更新用户的 Pow 余额
 		_, updateErr := oc.userCollection.UpdateOne(
 			oc.ctx,
@@ -1659,7 +1586,7 @@ func (oc *OrderController) TransferSCL(c *fiber.Ctx) error {
 	}
 
 	// 根据是否转账 SOL 返回不同的消息
-	if solTransferred {
+	if solTransferred > 0 {
 		return c.JSON(fiber.Map{"message": "SCL 转账成功，同时转入了少量 SOL 作为手续费"})
 	} else {
 		return c.JSON(fiber.Map{"message": "SCL 转账成功"})
@@ -0,0 +1,401 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 每批读取的订单数，地址和商品按批次一次性查询
+const exportBatchSize = 200
+
+// exportRow 导出的一行：订单中的一个商品
+type exportRow struct {
+	order   *models.Orders
+	item    *models.OrderItem
+	product *models.Product         // 商品已删除时为 nil
+	address *models.ShippingAddress // 旧订单地址已删除、无法回填快照时为 nil
+	// 商品所在包裹的序号（从1开始）和订单的包裹数，按收件地址分包
+	shipment, shipments int
+}
+
+// exportColumn 可导出的列
+type exportColumn struct {
+	Key   string
+	Title string
+	Value func(r exportRow) interface{}
+}
+
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(exportTimeLayout)
+}
+
+// 全部可选列，顺序即默认导出顺序
+var exportColumns = []exportColumn{
+	{"order_id", "订单ID", func(r exportRow) interface{} { return r.order.ID.Hex() }},
+	{"user_id", "用户ID", func(r exportRow) interface{} { return r.order.UserRef.Hex() }},
+	{"alipay_trade_no", "支付宝交易号", func(r exportRow) interface{} { return r.order.AlipayTradeNo }},
+	{"payment_status", "支付状态", func(r exportRow) interface{} { return r.order.PaymentStatus }},
+	{"payment_time", "支付时间", func(r exportRow) interface{} { return formatExportTime(r.order.PaymentTime) }},
+	{"created_at", "创建时间", func(r exportRow) interface{} { return formatExportTime(r.order.CreatedAt) }},
+	{"total_price", "订单金额", func(r exportRow) interface{} { return r.order.TotalPrice.Yuan() }},
+	{"shipping_fee", "运费", func(r exportRow) interface{} { return r.order.ShippingFee.Yuan() }},
+	{"product_id", "商品ID", func(r exportRow) interface{} { return r.item.ProductRef.Hex() }},
+	{"product_name", "商品名称", func(r exportRow) interface{} {
+		if r.product == nil {
+			return "已删除商品"
+		}
+		return r.product.Name
+	}},
+	{"price", "单价", func(r exportRow) interface{} { return r.item.Price.Yuan() }},
+	{"quantity", "商品数量", func(r exportRow) interface{} { return r.item.Quantity }},
+	{"subtotal", "小计", func(r exportRow) interface{} { return r.item.Price.Mul(r.item.Quantity).Yuan() }},
+	{"size", "商品尺寸", func(r exportRow) interface{} { return r.item.Size }},
+	{"color", "商品颜色", func(r exportRow) interface{} { return r.item.Color }},
+	{"shipping_status", "发货状态", func(r exportRow) interface{} { return r.item.ShippingStatus }},
+	{"carrier", "快递公司", func(r exportRow) interface{} { return r.item.Carrier }},
+	{"deliver_id", "快递单号", func(r exportRow) interface{} { return r.item.DeliverID }},
+	{"shipment", "包裹", func(r exportRow) interface{} { return fmt.Sprintf("%d/%d", r.shipment, r.shipments) }},
+	{"address_item_ref", "收件地址ID", func(r exportRow) interface{} { return r.item.AddressItemRef.Hex() }},
+	{"receiver", "收件人姓名", func(r exportRow) interface{} {
+		if r.address == nil {
+			return "未知"
+		}
+		return r.address.Receiver()
+	}},
+	{"phone", "收件人电话", func(r exportRow) interface{} {
+		if r.address == nil {
+			return "未知"
+		}
+		return r.address.Phone
+	}},
+	{"address", "收件地址", func(r exportRow) interface{} {
+		if r.address == nil {
+			return "未知"
+		}
+		return r.address.FullAddress()
+	}},
+	{"pow", "订单权证", func(r exportRow) interface{} {
+		if r.order.PowAward == nil {
+			return 0
+		}
+		return r.order.PowAward.Amount
+	}},
+}
+
+// 未指定 columns 时的默认列，在旧版导出的基础上增加商品名称
+// 商品ID和收件地址ID用于回填快递单号时准确匹配拆分到多个地址的同一商品，不能省略
+var defaultExportColumns = []string{
+	"order_id", "user_id", "payment_status", "payment_time", "created_at",
+	"product_id", "product_name", "quantity", "size", "color",
+	"shipment", "address_item_ref", "receiver", "phone", "address",
+}
+
+// 解析 columns 参数（逗号分隔的列名）
+func parseExportColumns(param string) ([]exportColumn, error) {
+	keys := defaultExportColumns
+	if param != "" {
+		keys = strings.Split(param, ",")
+	}
+	byKey := make(map[string]exportColumn, len(exportColumns))
+	for _, col := range exportColumns {
+		byKey[col.Key] = col
+	}
+	columns := make([]exportColumn, 0, len(keys))
+	for _, key := range keys {
+		col, ok := byKey[strings.TrimSpace(key)]
+		if !ok {
+			return nil, fmt.Errorf("不支持的导出列: %s", key)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// exportFilter 导出条件，商品和发货状态同时作用于订单和行
+type exportFilter struct {
+	query          bson.M
+	productRef     primitive.ObjectID
+	shippingStatus string
+}
+
+func (f exportFilter) matchItem(item models.OrderItem) bool {
+	if !f.productRef.IsZero() && item.ProductRef != f.productRef {
+		return false
+	}
+	if f.shippingStatus != "" && item.ShippingStatus != f.shippingStatus {
+		return false
+	}
+	return true
+}
+
+// 解析导出条件
+// start、end、tz 按创建时间过滤；payment_status 默认已支付，传 all 表示不限；
+// shipping_status、product_id 只导出匹配的商品行
+func parseExportFilter(c *fiber.Ctx) (exportFilter, error) {
+	filter := exportFilter{query: bson.M{}}
+
+	if c.Query("start") != "" || c.Query("end") != "" {
+		r, err := parseSalesRange(c)
+		if err != nil {
+			return filter, err
+		}
+		filter.query["created_at"] = bson.M{"$gte": r.Start, "$lt": r.End}
+	}
+
+	switch status := c.Query("payment_status", "已支付"); status {
+	case "all":
+	default:
+		filter.query["payment_status"] = status
+	}
+
+	itemMatch := bson.M{}
+	if productID := c.Query("product_id"); productID != "" {
+		productRef, err := primitive.ObjectIDFromHex(productID)
+		if err != nil {
+			return filter, fmt.Errorf("无效的商品ID")
+		}
+		filter.productRef = productRef
+		itemMatch["product_ref"] = productRef
+	}
+	if status := c.Query("shipping_status"); status != "" {
+		filter.shippingStatus = status
+		itemMatch["shipping_status"] = status
+	}
+	if len(itemMatch) > 0 {
+		filter.query["items"] = bson.M{"$elemMatch": itemMatch}
+	}
+	return filter, nil
+}
+
+// 批量查询一批订单涉及的商品，已查询过的不再重复查询，收件地址直接使用订单中的快照
+func (oc *OrderController) loadExportRefs(ctx context.Context, orders []models.Orders, products map[primitive.ObjectID]*models.Product) error {
+	var productIDs []primitive.ObjectID
+	for _, order := range orders {
+		for _, item := range order.OrderItems {
+			if _, ok := products[item.ProductRef]; !ok {
+				products[item.ProductRef] = nil
+				productIDs = append(productIDs, item.ProductRef)
+			}
+		}
+	}
+
+	if len(productIDs) > 0 {
+		cursor, err := oc.productCollection.Find(ctx, bson.M{"_id": bson.M{"$in": productIDs}},
+			options.Find().SetProjection(bson.M{"name": 1, "price": 1}))
+		if err != nil {
+			return fmt.Errorf("查询商品失败: %v", err)
+		}
+		var list []models.Product
+		if err := cursor.All(ctx, &list); err != nil {
+			return fmt.Errorf("解析商品失败: %v", err)
+		}
+		for i := range list {
+			products[list[i].ID] = &list[i]
+		}
+	}
+	return nil
+}
+
+// 按批次遍历符合条件的订单，逐行回调
+func (oc *OrderController) eachExportRow(ctx context.Context, filter exportFilter, fn func(exportRow) error) error {
+	cursor, err := oc.orderCollection.Find(ctx, filter.query,
+		options.Find().SetSort(bson.M{"created_at": 1}).SetBatchSize(exportBatchSize))
+	if err != nil {
+		return fmt.Errorf("查询订单失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	products := make(map[primitive.ObjectID]*models.Product)
+	batch := make([]models.Orders, 0, exportBatchSize)
+
+	flush := func() error {
+		if err := oc.loadExportRefs(ctx, batch, products); err != nil {
+			return err
+		}
+		for i := range batch {
+			order := &batch[i]
+			// 同一包裹的商品连续输出
+			shipments := groupShipments(order.OrderItems)
+			for s := range shipments {
+				for j := range shipments[s].Items {
+					item := &shipments[s].Items[j]
+					if !filter.matchItem(*item) {
+						continue
+					}
+					if err := fn(exportRow{
+						order:     order,
+						item:      item,
+						product:   products[item.ProductRef],
+						address:   item.ShippingAddress,
+						shipment:  s + 1,
+						shipments: len(shipments),
+					}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var order models.Orders
+		if err := cursor.Decode(&order); err != nil {
+			return fmt.Errorf("解析订单失败: %v", err)
+		}
+		batch = append(batch, order)
+		if len(batch) == exportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("读取订单失败: %v", err)
+	}
+	return flush()
+}
+
+// 以 CSV 写出，带 UTF-8 BOM 便于 Excel 直接打开
+func (oc *OrderController) writeExportCSV(w *bufio.Writer, filter exportFilter, columns []exportColumn) error {
+	w.WriteString("\xEF\xBB\xBF")
+	cw := csv.NewWriter(w)
+	titles := make([]string, len(columns))
+	for i, col := range columns {
+		titles[i] = col.Title
+	}
+	if err := cw.Write(titles); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	err := oc.eachExportRow(oc.ctx, filter, func(r exportRow) error {
+		for i, col := range columns {
+			record[i] = fmt.Sprint(col.Value(r))
+		}
+		return cw.Write(record)
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// 使用 StreamWriter 逐行写出 XLSX
+func (oc *OrderController) writeExportXLSX(w *bufio.Writer, filter exportFilter, columns []exportColumn) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheetName := "订单详情"
+	f.SetSheetName(f.GetSheetName(0), sheetName)
+
+	sw, err := f.NewStreamWriter(sheetName)
+	if err != nil {
+		return fmt.Errorf("创建工作表失败: %v", err)
+	}
+	titles := make([]interface{}, len(columns))
+	for i, col := range columns {
+		titles[i] = col.Title
+	}
+	if err := sw.SetRow("A1", titles); err != nil {
+		return err
+	}
+
+	row := 2
+	err = oc.eachExportRow(oc.ctx, filter, func(r exportRow) error {
+		values := make([]interface{}, len(columns))
+		for i, col := range columns {
+			values[i] = col.Value(r)
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		row++
+		return sw.SetRow(cell, values)
+	})
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return fmt.Errorf("写入工作表失败: %v", err)
+	}
+	return f.Write(w)
+}
+
+// 后台导出订单到excelExportOrders
+// 参数：format（xlsx/csv），columns（逗号分隔），start、end、tz、payment_status、shipping_status、product_id
+func (oc *OrderController) ExportOrders(c *fiber.Ctx) error {
+	format := c.Query("format", "xlsx")
+	if format != "xlsx" && format != "csv" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format 仅支持 xlsx、csv"})
+	}
+	columns, err := parseExportColumns(c.Query("columns"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// 开始写出后无法再返回错误状态码，先确认有数据
+	count, err := oc.orderCollection.CountDocuments(c.Context(), filter.query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+	if count == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "没有找到符合条件的订单"})
+	}
+
+	fileName := fmt.Sprintf("OrderExport_%s.%s", time.Now().Format("20060102_1504"), format)
+	if format == "csv" {
+		c.Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	}
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var err error
+		if format == "csv" {
+			err = oc.writeExportCSV(w, filter, columns)
+		} else {
+			err = oc.writeExportXLSX(w, filter, columns)
+		}
+		if err != nil {
+			log.Printf("导出订单失败 (%s): %v", fileName, err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("写出导出文件失败 (%s): %v", fileName, err)
+		}
+	})
+	return nil
+}
+
+// 导出支持的列，供前端选择
+func (oc *OrderController) GetExportColumns(c *fiber.Ctx) error {
+	columns := make([]fiber.Map, len(exportColumns))
+	for i, col := range exportColumns {
+		columns[i] = fiber.Map{"key": col.Key, "title": col.Title}
+	}
+	return c.JSON(fiber.Map{
+		"columns": columns,
+		"default": defaultExportColumns,
+	})
+}
@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"blog-auth-server/money"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 报价有效期，过期后需要重新获取
+const quoteTTL = 10 * time.Minute
+
+var (
+	errQuoteInvalid = errors.New("报价无效，请重新确认订单")
+	errQuoteExpired = errors.New("报价已过期，请重新确认订单")
+)
+
+// quoteError 计算报价时可以直接返回给用户的错误
+type quoteError struct {
+	status  int
+	message string
+}
+
+func (e *quoteError) Error() string {
+	return e.message
+}
+
+// quoteRequest 结算页提交的报价参数，下单时从报价ID中还原
+// address_item_ref 未传时使用默认地址，allocations 与下单时的含义相同
+type quoteRequest struct {
+	AddressItemRef string               `json:"address_item_ref"`
+	Allocations    []shipmentAllocation `json:"allocations"`
+	PowOffset      float64              `json:"pow_offset"`         // 希望用于抵扣的 Pow 数量
+	Currency       string               `json:"currency,omitempty"` // 展示货币，为空时只返回人民币金额
+}
+
+// QuoteLine 报价中的一行，发往不同地址的数量分成不同的行
+type QuoteLine struct {
+	ProductRef     primitive.ObjectID `json:"product_ref"`
+	Name           string             `json:"name"`
+	Size           string             `json:"size"`
+	Color          string             `json:"color"`
+	Quantity       int                `json:"quantity"`
+	UnitPrice      money.Fen          `json:"unit_price"`
+	LineTotal      money.Fen          `json:"line_total"`
+	AddressItemRef primitive.ObjectID `json:"address_item_ref"`
+	// 指定展示货币时换算后的价格
+	DisplayUnitPrice *DisplayPrice `json:"display_unit_price,omitempty"`
+	DisplayLineTotal *DisplayPrice `json:"display_line_total,omitempty"`
+}
+
+// OrderQuote 服务端计算的订单金额，应付金额 = 商品金额 - 优惠 + 运费 - Pow 抵扣
+type OrderQuote struct {
+	QuoteID      string      `json:"quote_id"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+	Lines        []QuoteLine `json:"lines"`
+	Subtotal     money.Fen   `json:"subtotal"`
+	Discount     money.Fen   `json:"discount"`
+	ShippingFee  money.Fen   `json:"shipping_fee"`
+	PowOffset    float64     `json:"pow_offset"`    // 使用的 Pow 数量
+	PowDeduction money.Fen   `json:"pow_deduction"` // Pow 抵扣的金额
+	Payable      money.Fen   `json:"payable"`
+	// 指定展示货币时换算后的金额，下单时汇率保存到订单；实际支付仍为人民币
+	DisplaySubtotal    *DisplayPrice       `json:"display_subtotal,omitempty"`
+	DisplayShippingFee *DisplayPrice       `json:"display_shipping_fee,omitempty"`
+	DisplayPayable     *DisplayPrice       `json:"display_payable,omitempty"`
+	FxRate             *models.OrderFxRate `json:"fx_rate,omitempty"`
+
+	items []models.OrderItem // 下单时写入订单的商品
+}
+
+// Pow 抵扣配置：POW_OFFSET_RATE 为每个 Pow 抵扣的金额（元），未配置时不能抵扣
+// POW_OFFSET_MAX_RATIO 为最多抵扣的应付金额比例，默认 0.5
+func powOffsetConfig() (rate, maxRatio float64) {
+	rate = envFloat("POW_OFFSET_RATE", 0)
+	maxRatio = math.Min(envFloat("POW_OFFSET_MAX_RATIO", 0.5), 1)
+	return rate, maxRatio
+}
+
+// 报价签名密钥，未配置 QUOTE_SECRET 时由登录令牌的密钥派生，避免报价ID被当作登录令牌使用
+func quoteSecret() []byte {
+	if secret := os.Getenv("QUOTE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return append([]byte("quote:"), SecretKey...)
+}
+
+// 根据当前购物车、地址簿、商品价格和 Pow 余额计算报价
+func (oc *OrderController) buildQuote(ctx context.Context, userID primitive.ObjectID, req quoteRequest) (*OrderQuote, error) {
+	if req.PowOffset < 0 {
+		return nil, &quoteError{fiber.StatusBadRequest, "Pow 抵扣数量不能为负数"}
+	}
+	display, err := oc.currencyController.resolve(ctx, req.Currency)
+	if err != nil {
+		if errors.Is(err, errCurrencyUnsupported) || errors.Is(err, errFxRateMissing) {
+			return nil, &quoteError{fiber.StatusBadRequest, err.Error()}
+		}
+		return nil, err
+	}
+
+	var cart models.Cart
+	if err := oc.cartCollection.FindOne(ctx, bson.M{"user_ref": userID}).Decode(&cart); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &quoteError{fiber.StatusNotFound, "购物车为空"}
+		}
+		return nil, fmt.Errorf("获取购物车失败: %v", err)
+	}
+	if len(cart.CartItems) == 0 {
+		return nil, &quoteError{fiber.StatusNotFound, "购物车为空"}
+	}
+
+	var addressItemRef primitive.ObjectID
+	if req.AddressItemRef != "" {
+		ref, err := primitive.ObjectIDFromHex(req.AddressItemRef)
+		if err != nil {
+			return nil, &quoteError{fiber.StatusBadRequest, "无效的地址ID格式"}
+		}
+		addressItemRef = ref
+	}
+
+	// 读取用户地址簿，所有收件地址都必须属于该用户
+	var address models.Address
+	if err := oc.addressCollection.FindOne(ctx, bson.M{"user_ref": userID}).Decode(&address); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &quoteError{fiber.StatusBadRequest, "请先添加收件地址"}
+		}
+		return nil, fmt.Errorf("获取地址失败: %v", err)
+	}
+	book := make(map[primitive.ObjectID]*models.AddressItem, len(address.AddressDetails))
+	for i := range address.AddressDetails {
+		book[address.AddressDetails[i].ID] = &address.AddressDetails[i]
+	}
+	if addressItemRef.IsZero() {
+		if defaultAddress := findDefaultAddress(address.AddressDetails); defaultAddress != nil {
+			addressItemRef = defaultAddress.ID
+		} else if len(req.Allocations) == 0 {
+			return nil, &quoteError{fiber.StatusBadRequest, "请选择收件地址"}
+		}
+	}
+
+	// 按地址拆分购物车商品
+	splits, err := allocateCartItems(cart.CartItems, addressItemRef, req.Allocations, book)
+	if err != nil {
+		return nil, &quoteError{fiber.StatusBadRequest, err.Error()}
+	}
+
+	// 批量查询商品的当前价格和库存
+	ids := make([]primitive.ObjectID, 0, len(cart.CartItems))
+	wanted := make(map[primitive.ObjectID]int)
+	for _, item := range cart.CartItems {
+		ids = append(ids, item.ProductRef)
+		wanted[item.ProductRef] += item.Quantity
+	}
+	cursor, err := oc.productCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("查询商品失败: %v", err)
+	}
+	var list []models.Product
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("解析商品失败: %v", err)
+	}
+	products := make(map[primitive.ObjectID]*models.Product, len(list))
+	for i := range list {
+		products[list[i].ID] = &list[i]
+	}
+
+	// 保存下单时的地址快照，之后用户修改或删除地址不影响本订单
+	snapshotAt := time.Now()
+	quote := &OrderQuote{Lines: []QuoteLine{}}
+	for i, cartItem := range cart.CartItems {
+		product, ok := products[cartItem.ProductRef]
+		if !ok {
+			return nil, &quoteError{fiber.StatusConflict, fmt.Sprintf("商品 %s 已下架，请从购物车中移除", cartItem.ProductRef.Hex())}
+		}
+		if !product.Visible(snapshotAt) {
+			return nil, &quoteError{fiber.StatusConflict, fmt.Sprintf("商品 %s 已下架，请从购物车中移除", product.Name)}
+		}
+		if err := validateVariant(product, cartItem.Size, cartItem.Color); err != nil {
+			return nil, &quoteError{fiber.StatusConflict, fmt.Sprintf("%s: %v", product.Name, err)}
+		}
+		if wanted[product.ID] > product.Inventory {
+			return nil, &quoteError{fiber.StatusConflict, fmt.Sprintf("%s 库存不足", product.Name)}
+		}
+
+		// 发往不同地址的数量拆成不同的订单项
+		for _, split := range splits[i] {
+			line := QuoteLine{
+				ProductRef:     product.ID,
+				Name:           product.Name,
+				Size:           cartItem.Size,
+				Color:          cartItem.Color,
+				Quantity:       split.Quantity,
+				UnitPrice:      product.Price,
+				LineTotal:      product.Price.Mul(split.Quantity),
+				AddressItemRef: split.AddressItemRef,
+			}
+			line.DisplayUnitPrice = display.price(line.UnitPrice)
+			line.DisplayLineTotal = display.price(line.LineTotal)
+			quote.Lines = append(quote.Lines, line)
+			quote.Subtotal += line.LineTotal
+			quote.items = append(quote.items, models.OrderItem{
+				ProductRef:      cartItem.ProductRef,
+				Quantity:        split.Quantity,
+				Size:            cartItem.Size,
+				Color:           cartItem.Color,
+				Price:           product.Price,
+				DeliverID:       "", // 初始为空，后续可更新
+				ShippingStatus:  models.ShippingPending,
+				AddressItemRef:  split.AddressItemRef,
+				ShippingAddress: snapshotAddress(book[split.AddressItemRef], snapshotAt),
+			})
+		}
+	}
+
+	// 运费按包裹计算，包邮门槛使用商品原价
+	quote.ShippingFee, err = oc.shippingController.calculateShippingFee(ctx, quote.items, products)
+	if err != nil {
+		return nil, err
+	}
+
+	total := quote.Subtotal - quote.Discount + quote.ShippingFee
+	if rate, maxRatio := powOffsetConfig(); req.PowOffset > 0 && rate > 0 && total > money.Cent {
+		var user models.User
+		if err := oc.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return nil, fmt.Errorf("获取用户Pow余额失败: %v", err)
+		}
+		// 抵扣向下取整到分，且至少保留 1 分通过支付宝支付
+		limit := money.Min(total.FloorRate(maxRatio), total-money.Cent)
+		deduction := money.Min(money.Yuan.FloorRate(math.Min(req.PowOffset, user.Pow)*rate), limit)
+		quote.PowDeduction = deduction
+		quote.PowOffset = deduction.Yuan() / rate
+	}
+	quote.Payable = total - quote.PowDeduction
+
+	if display != nil {
+		quote.DisplaySubtotal = display.price(quote.Subtotal)
+		quote.DisplayShippingFee = display.price(quote.ShippingFee)
+		quote.DisplayPayable = display.price(quote.Payable)
+		quote.FxRate = &models.OrderFxRate{
+			Currency:     display.currency.Code,
+			Rate:         display.rate.Rate,
+			RateRef:      display.rate.ID,
+			DisplayTotal: quote.DisplayPayable.Amount,
+		}
+	}
+	return quote, nil
+}
+
+// 报价内容摘要，下单时重新计算并比对，价格、数量、地址或抵扣变化后报价失效
+func (q *OrderQuote) digest() string {
+	h := sha256.New()
+	for _, item := range q.items {
+		fmt.Fprintf(h, "%s|%s|%s|%d|%d|%s|%s|%s\n", item.ProductRef.Hex(), item.Size, item.Color, item.Quantity, item.Price,
+			item.AddressItemRef.Hex(), item.ShippingAddress.Receiver(), item.ShippingAddress.FullAddress())
+	}
+	fmt.Fprintf(h, "%d|%d|%d|%d|%d", q.Subtotal, q.Discount, q.ShippingFee, q.PowDeduction, q.Payable)
+	// 展示汇率调整后报价同样失效，保证订单保存的汇率与用户看到的一致
+	if q.FxRate != nil {
+		fmt.Fprintf(h, "|%s|%s", q.FxRate.Currency, q.FxRate.RateRef.Hex())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 签发报价ID，报价参数和摘要保存在签名内容中，服务端不需要保存报价
+func (q *OrderQuote) sign(userID primitive.ObjectID, req quoteRequest, now time.Time) error {
+	params, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	q.ExpiresAt = now.Add(quoteTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":     primitive.NewObjectID().Hex(),
+		"user_id": userID.Hex(),
+		"params":  string(params),
+		"digest":  q.digest(),
+		"exp":     q.ExpiresAt.Unix(),
+	})
+	q.QuoteID, err = token.SignedString(quoteSecret())
+	return err
+}
+
+// 校验报价ID的签名、有效期和所属用户，返回报价编号、报价参数和摘要
+func parseQuoteID(quoteID string, userID primitive.ObjectID) (jti string, req quoteRequest, digest string, err error) {
+	token, err := jwt.Parse(quoteID, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return quoteSecret(), nil
+	})
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+			return "", req, "", errQuoteExpired
+		}
+		return "", req, "", errQuoteInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["user_id"] != userID.Hex() {
+		return "", req, "", errQuoteInvalid
+	}
+	jti, _ = claims["jti"].(string)
+	digest, _ = claims["digest"].(string)
+	params, _ := claims["params"].(string)
+	if jti == "" || digest == "" || json.Unmarshal([]byte(params), &req) != nil {
+		return "", req, "", errQuoteInvalid
+	}
+	return jti, req, digest, nil
+}
+
+// 报价错误转换为响应，非用户输入导致的错误只记录日志
+func quoteErrorResponse(c *fiber.Ctx, err error) error {
+	var qe *quoteError
+	if errors.As(err, &qe) {
+		return c.Status(qe.status).JSON(fiber.Map{"error": qe.message})
+	}
+	log.Printf("计算订单报价失败: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "计算订单金额失败"})
+}
+
+// 退回订单抵扣的 Pow，调用方需保证订单已关闭且只退回一次
+func (oc *OrderController) refundPowOffset(order models.Orders) {
+	if order.PowOffset <= 0 {
+		return
+	}
+	_, err := oc.userCollection.UpdateOne(oc.ctx, bson.M{"_id": order.UserRef}, bson.M{"$inc": bson.M{"pow": order.PowOffset}})
+	if err != nil {
+		log.Printf("退回订单抵扣的Pow失败 (OrderID: %s, Pow: %f): %v", order.ID.Hex(), order.PowOffset, err)
+	}
+}
+
+// GetOrderQuote 结算页报价，返回各行价格、优惠、运费、Pow 抵扣和应付金额
+// 返回的 quote_id 在有效期内用于创建订单
+func (oc *OrderController) GetOrderQuote(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		log.Println("Error: claims not found in context locals")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		log.Println("Error: user_id claim not found or not a string")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User ID not found in claims"})
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// 使用默认地址且不抵扣时可以不传请求体
+	var req quoteRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求体"})
+		}
+	}
+
+	quote, err := oc.buildQuote(oc.ctx, userID, req)
+	if err != nil {
+		return quoteErrorResponse(c, err)
+	}
+	if err := quote.sign(userID, req, time.Now()); err != nil {
+		log.Printf("签发订单报价失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "计算订单金额失败"})
+	}
+	return c.JSON(quote)
+}
@@ -0,0 +1,654 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PowController struct {
+	powRuleCollection   *mongo.Collection
+	powConfigCollection *mongo.Collection
+	userCollection      *mongo.Collection
+	orderCollection     *mongo.Collection
+	productCollection   *mongo.Collection
+	ctx                 context.Context
+}
+
+// NewPowController 构造函数
+func NewPowController(powRuleCollection, powConfigCollection, userCollection, orderCollection, productCollection *mongo.Collection, ctx context.Context) *PowController {
+	pc := &PowController{
+		powRuleCollection:   powRuleCollection,
+		powConfigCollection: powConfigCollection,
+		userCollection:      userCollection,
+		orderCollection:     orderCollection,
+		productCollection:   productCollection,
+		ctx:                 ctx,
+	}
+	// 启动锁定权证定时解锁 goroutine
+	go pc.startVestingRelease()
+	return pc
+}
+
+// 未配置时的默认规则：每消费1元增加1 Pow，立即可提现
+var defaultPowConfig = models.PowConfig{BaseRate: 1}
+
+func (pc *PowController) loadConfig() (models.PowConfig, error) {
+	var config models.PowConfig
+	err := pc.powConfigCollection.FindOne(pc.ctx, bson.M{}).Decode(&config)
+	if err == mongo.ErrNoDocuments {
+		return defaultPowConfig, nil
+	}
+	return config, err
+}
+
+func (pc *PowController) loadActiveRules(now time.Time) ([]models.PowRule, error) {
+	cursor, err := pc.powRuleCollection.Find(pc.ctx, bson.M{"enabled": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(pc.ctx)
+
+	var rules []models.PowRule
+	if err := cursor.All(pc.ctx, &rules); err != nil {
+		return nil, err
+	}
+
+	// 过滤掉不在活动时间内的规则
+	active := rules[:0]
+	for _, rule := range rules {
+		if !rule.StartAt.IsZero() && now.Before(rule.StartAt) {
+			continue
+		}
+		if !rule.EndAt.IsZero() && now.After(rule.EndAt) {
+			continue
+		}
+		active = append(active, rule)
+	}
+	return active, nil
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断倍率规则是否作用于该商品，未限定商品和分类的规则作用于全部商品
+func powRuleMatches(rule models.PowRule, product models.Product) bool {
+	if len(rule.ProductRefs) == 0 && len(rule.CategoryRefs) == 0 {
+		return true
+	}
+	if containsObjectID(rule.ProductRefs, product.ID) {
+		return true
+	}
+	for _, category := range product.Categories {
+		if containsObjectID(rule.CategoryRefs, category.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// calculateOrderPow 按当前规则计算订单应发放的权证
+// paidAmount 为支付宝实际支付金额（元），按商品小计比例分摊到每个订单项。
+// 同一商品命中多条倍率规则时取最高倍率，不叠加。
+func (pc *PowController) calculateOrderPow(order models.Orders, paidAmount float64) (*models.PowAward, error) {
+	now := time.Now()
+	config, err := pc.loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("读取权证配置失败: %v", err)
+	}
+	rules, err := pc.loadActiveRules(now)
+	if err != nil {
+		return nil, fmt.Errorf("读取权证规则失败: %v", err)
+	}
+
+	award := &models.PowAward{
+		BaseRate:  config.BaseRate,
+		Rules:     []models.AppliedPowRule{},
+		AwardedAt: now,
+	}
+
+	// 批量查询订单中的商品，用于匹配商品和分类规则
+	productIDs := make([]primitive.ObjectID, 0, len(order.OrderItems))
+	var itemsTotal float64
+	for _, item := range order.OrderItems {
+		productIDs = append(productIDs, item.ProductRef)
+		itemsTotal += item.Price.Mul(item.Quantity).Yuan()
+	}
+	products := make(map[primitive.ObjectID]models.Product)
+	cursor, err := pc.productCollection.Find(pc.ctx, bson.M{"_id": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("查询商品失败: %v", err)
+	}
+	var productList []models.Product
+	if err := cursor.All(pc.ctx, &productList); err != nil {
+		return nil, fmt.Errorf("解析商品失败: %v", err)
+	}
+	for _, product := range productList {
+		products[product.ID] = product
+	}
+
+	for _, item := range order.OrderItems {
+		subtotal := item.Price.Mul(item.Quantity).Yuan()
+		itemPaid := subtotal
+		if itemsTotal > 0 {
+			itemPaid = paidAmount * subtotal / itemsTotal
+		}
+
+		multiplier := 1.0
+		var best *models.PowRule
+		product, ok := products[item.ProductRef]
+		if !ok {
+			product = models.Product{ID: item.ProductRef}
+		}
+		for i, rule := range rules {
+			if rule.Type != models.PowRuleMultiplier || !powRuleMatches(rule, product) {
+				continue
+			}
+			if rule.Multiplier > multiplier {
+				multiplier = rule.Multiplier
+				best = &rules[i]
+			}
+		}
+		if best != nil {
+			award.Rules = append(award.Rules, models.AppliedPowRule{
+				RuleRef:    best.ID,
+				Name:       best.Name,
+				Type:       best.Type,
+				ProductRef: item.ProductRef,
+				Multiplier: best.Multiplier,
+			})
+		}
+		award.Amount += itemPaid * config.BaseRate * multiplier
+	}
+
+	// 首单奖励：该用户此前没有其他已支付订单，且首单标记未被其他订单占用
+	var bonusRules []models.PowRule
+	for _, rule := range rules {
+		if rule.Type == models.PowRuleFirstOrder && rule.Bonus > 0 {
+			bonusRules = append(bonusRules, rule)
+		}
+	}
+	if len(bonusRules) > 0 {
+		paidBefore, err := pc.orderCollection.CountDocuments(pc.ctx, bson.M{
+			"user_ref":       order.UserRef,
+			"payment_status": "已支付",
+			"_id":            bson.M{"$ne": order.ID},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("查询历史订单失败: %v", err)
+		}
+		claimed := false
+		if paidBefore == 0 {
+			if claimed, err = pc.claimFirstOrderBonus(order); err != nil {
+				return nil, fmt.Errorf("领取首单奖励失败: %v", err)
+			}
+		}
+		if claimed {
+			for _, rule := range bonusRules {
+				award.Amount += rule.Bonus
+				award.Rules = append(award.Rules, models.AppliedPowRule{
+					RuleRef: rule.ID,
+					Name:    rule.Name,
+					Type:    rule.Type,
+					Bonus:   rule.Bonus,
+				})
+			}
+		}
+	}
+
+	// 单笔订单上限
+	if config.MaxPerOrder > 0 && award.Amount > config.MaxPerOrder {
+		award.Amount = config.MaxPerOrder
+		award.Capped = true
+	}
+
+	// 单用户每日上限，按当天已发放的订单权证累计
+	if config.MaxPerDay > 0 {
+		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		cursor, err := pc.orderCollection.Aggregate(pc.ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"user_ref":             order.UserRef,
+				"_id":                  bson.M{"$ne": order.ID},
+				"pow_award.awarded_at": bson.M{"$gte": startOfDay},
+			}}},
+			{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$pow_award.amount"}}}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("统计当日权证失败: %v", err)
+		}
+		var result []struct {
+			Total float64 `bson:"total"`
+		}
+		if err := cursor.All(pc.ctx, &result); err != nil {
+			return nil, fmt.Errorf("解析当日权证统计失败: %v", err)
+		}
+		var awardedToday float64
+		if len(result) > 0 {
+			awardedToday = result[0].Total
+		}
+		remaining := config.MaxPerDay - awardedToday
+		if remaining < 0 {
+			remaining = 0
+		}
+		if award.Amount > remaining {
+			award.Amount = remaining
+			award.Capped = true
+		}
+	}
+
+	// 退货期锁定
+	if config.VestingDays > 0 {
+		award.Status = models.PowAwardLocked
+		award.VestAt = now.AddDate(0, 0, config.VestingDays)
+	} else {
+		award.Status = models.PowAwardVested
+		award.VestAt = now
+	}
+
+	return award, nil
+}
+
+// AwardOrderPow 订单支付成功后发放权证，每个订单只会发放一次
+func (pc *PowController) AwardOrderPow(order models.Orders, paidAmount float64) (*models.PowAward, error) {
+	award, err := pc.calculateOrderPow(order, paidAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只有尚未发放过的订单才写入，防止手动查询和自动查询重复发放
+	// 先保存为待入账，用户余额加成功后再标记为已入账，中途失败由定时任务重试
+	award.Credit = models.PowCreditPending
+	result, err := pc.orderCollection.UpdateOne(
+		pc.ctx,
+		bson.M{"_id": order.ID, "pow_award": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pow_award": award}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("保存订单权证记录失败: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, nil
+	}
+
+	if err := pc.creditOrderPow(order.ID, order.UserRef, award); err != nil {
+		return award, err
+	}
+	return award, nil
+}
+
+// 在用户上原子地记录领取首单奖励的订单（pow_first_order_ref），同时支付的多个订单只有一个能领取
+// 同一订单重新计算时仍视为已领取
+func (pc *PowController) claimFirstOrderBonus(order models.Orders) (bool, error) {
+	result, err := pc.userCollection.UpdateOne(
+		pc.ctx,
+		bson.M{"_id": order.UserRef, "$or": bson.A{
+			bson.M{"pow_first_order_ref": bson.M{"$exists": false}},
+			bson.M{"pow_first_order_ref": order.ID},
+		}},
+		bson.M{"$set": bson.M{"pow_first_order_ref": order.ID}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// 将待入账的订单权证加到用户余额，成功后再把订单标记为已入账
+// 用户上的 pow_crediting_refs 记录已加过余额、订单还没标记的订单，重试时不会重复增加
+func (pc *PowController) creditOrderPow(orderID, userRef primitive.ObjectID, award *models.PowAward) error {
+	field := "pow"
+	if award.Status == models.PowAwardLocked {
+		field = "pow_locked"
+	}
+	result, err := pc.userCollection.UpdateOne(
+		pc.ctx,
+		bson.M{"_id": userRef, "pow_crediting_refs": bson.M{"$ne": orderID}},
+		bson.M{
+			"$inc":      bson.M{field: award.Amount},
+			"$addToSet": bson.M{"pow_crediting_refs": orderID},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("更新用户Pow失败: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		// 没有匹配时，只有上次已加过余额才能继续标记
+		credited, err := pc.userCollection.CountDocuments(pc.ctx, bson.M{"_id": userRef, "pow_crediting_refs": orderID})
+		if err != nil {
+			return fmt.Errorf("查询用户Pow入账记录失败: %v", err)
+		}
+		if credited == 0 {
+			return fmt.Errorf("用户不存在: %s", userRef.Hex())
+		}
+	}
+
+	_, err = pc.orderCollection.UpdateOne(
+		pc.ctx,
+		bson.M{"_id": orderID, "pow_award.credit": models.PowCreditPending},
+		bson.M{"$set": bson.M{"pow_award.credit": models.PowCreditCredited}},
+	)
+	if err != nil {
+		return fmt.Errorf("标记订单权证已入账失败: %v", err)
+	}
+	_, err = pc.userCollection.UpdateOne(pc.ctx, bson.M{"_id": userRef}, bson.M{"$pull": bson.M{"pow_crediting_refs": orderID}})
+	if err != nil {
+		// 残留的记录不影响余额，订单已标记为已入账，不会再被重试
+		log.Printf("清理用户Pow入账记录失败 (OrderID: %s): %v", orderID.Hex(), err)
+	}
+	return nil
+}
+
+func (pc *PowController) startVestingRelease() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pc.retryPendingCredits()
+			pc.releaseVestedPow()
+		case <-pc.ctx.Done():
+			return
+		}
+	}
+}
+
+// 待入账的权证超过这个时间仍未标记，视为发放时中途失败，由定时任务重试
+const powCreditRetryAfter = 5 * time.Minute
+
+// 重试发放时没有加到用户余额的订单权证
+func (pc *PowController) retryPendingCredits() {
+	cursor, err := pc.orderCollection.Find(pc.ctx, bson.M{
+		"pow_award.credit":     models.PowCreditPending,
+		"pow_award.awarded_at": bson.M{"$lte": time.Now().Add(-powCreditRetryAfter)},
+	})
+	if err != nil {
+		log.Printf("查询待入账权证失败: %v", err)
+		return
+	}
+	var orders []models.Orders
+	if err := cursor.All(pc.ctx, &orders); err != nil {
+		log.Printf("解析待入账权证失败: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		if err := pc.creditOrderPow(order.ID, order.UserRef, order.PowAward); err != nil {
+			log.Printf("重试权证入账失败 (OrderID: %s): %v", order.ID.Hex(), err)
+		}
+	}
+	if len(orders) > 0 {
+		log.Printf("权证入账重试: 处理了 %d 个订单", len(orders))
+	}
+}
+
+// 将到期的锁定权证转入用户可提现余额，尚未入账的权证不解锁，避免 pow_locked 变为负数
+func (pc *PowController) releaseVestedPow() {
+	filter := bson.M{
+		"pow_award.status":  models.PowAwardLocked,
+		"pow_award.credit":  bson.M{"$ne": models.PowCreditPending},
+		"pow_award.vest_at": bson.M{"$lte": time.Now()},
+	}
+	cursor, err := pc.orderCollection.Find(pc.ctx, filter)
+	if err != nil {
+		log.Printf("查询待解锁权证失败: %v", err)
+		return
+	}
+	var orders []models.Orders
+	if err := cursor.All(pc.ctx, &orders); err != nil {
+		log.Printf("解析待解锁权证失败: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		result, err := pc.orderCollection.UpdateOne(
+			pc.ctx,
+			bson.M{"_id": order.ID, "pow_award.status": models.PowAwardLocked, "pow_award.credit": bson.M{"$ne": models.PowCreditPending}},
+			bson.M{"$set": bson.M{"pow_award.status": models.PowAwardVested}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		amount := order.PowAward.Amount
+		_, err = pc.userCollection.UpdateOne(
+			pc.ctx,
+			bson.M{"_id": order.UserRef},
+			bson.M{"$inc": bson.M{"pow": amount, "pow_locked": -amount}},
+		)
+		if err != nil {
+			log.Printf("解锁用户Pow失败 (OrderID: %s): %v", order.ID.Hex(), err)
+		}
+	}
+	if len(orders) > 0 {
+		log.Printf("权证解锁: 处理了 %d 个订单", len(orders))
+	}
+}
+
+// 后台获取权证发放配置
+func (pc *PowController) GetPowConfig(c *fiber.Ctx) error {
+	config, err := pc.loadConfig()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取权证配置失败"})
+	}
+	return c.JSON(config)
+}
+
+// 权证配置的取值范围
+const (
+	powMaxBaseRate    = 100 // 每消费1元最多获得的 Pow
+	powMaxVestingDays = 365
+)
+
+// powConfigPatch 更新权证配置的请求，只修改传入的字段
+type powConfigPatch struct {
+	BaseRate    *float64 `json:"base_rate"`
+	MaxPerOrder *float64 `json:"max_per_order"`
+	MaxPerDay   *float64 `json:"max_per_day"`
+	VestingDays *int     `json:"vesting_days"`
+}
+
+// 校验修改内容并生成 $set，current 为当前配置，用于检查修改后各字段是否相互矛盾
+func (p *powConfigPatch) toSet(current models.PowConfig) (bson.M, string) {
+	set := bson.M{}
+	if p.BaseRate != nil {
+		if *p.BaseRate < 0 || *p.BaseRate > powMaxBaseRate {
+			return nil, fmt.Sprintf("基础倍率必须在 0 到 %d 之间", powMaxBaseRate)
+		}
+		current.BaseRate = *p.BaseRate
+		set["base_rate"] = *p.BaseRate
+	}
+	if p.MaxPerOrder != nil {
+		if *p.MaxPerOrder < 0 {
+			return nil, "单笔订单上限不能为负数"
+		}
+		current.MaxPerOrder = *p.MaxPerOrder
+		set["max_per_order"] = *p.MaxPerOrder
+	}
+	if p.MaxPerDay != nil {
+		if *p.MaxPerDay < 0 {
+			return nil, "每日上限不能为负数"
+		}
+		current.MaxPerDay = *p.MaxPerDay
+		set["max_per_day"] = *p.MaxPerDay
+	}
+	if p.VestingDays != nil {
+		if *p.VestingDays < 0 || *p.VestingDays > powMaxVestingDays {
+			return nil, fmt.Sprintf("锁定天数必须在 0 到 %d 之间", powMaxVestingDays)
+		}
+		set["vesting_days"] = *p.VestingDays
+	}
+	if current.MaxPerOrder > 0 && current.MaxPerDay > 0 && current.MaxPerOrder > current.MaxPerDay {
+		return nil, "单笔订单上限不能大于每日上限"
+	}
+	return set, ""
+}
+
+// 后台更新权证发放配置，只修改请求中传入的字段
+func (pc *PowController) UpdatePowConfig(c *fiber.Ctx) error {
+	var patch powConfigPatch
+	if err := c.BodyParser(&patch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	current, err := pc.loadConfig()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取权证配置失败"})
+	}
+	set, msg := patch.toSet(current)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	if len(set) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "没有需要更新的字段"})
+	}
+
+	// 首次保存配置时，未传入的字段使用默认值，避免基础倍率被写成 0
+	defaults := bson.M{
+		"base_rate":     defaultPowConfig.BaseRate,
+		"max_per_order": defaultPowConfig.MaxPerOrder,
+		"max_per_day":   defaultPowConfig.MaxPerDay,
+		"vesting_days":  defaultPowConfig.VestingDays,
+	}
+	for key := range set {
+		delete(defaults, key)
+	}
+	set["updated_at"] = time.Now()
+	update := bson.M{"$set": set}
+	if len(defaults) > 0 {
+		update["$setOnInsert"] = defaults
+	}
+	_, err = pc.powConfigCollection.UpdateOne(pc.ctx, bson.M{}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新权证配置失败"})
+	}
+
+	config, err := pc.loadConfig()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取权证配置失败"})
+	}
+	return c.JSON(fiber.Map{"message": "权证配置更新成功", "config": config})
+}
+
+// 后台获取所有权证规则
+func (pc *PowController) GetPowRules(c *fiber.Ctx) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := pc.powRuleCollection.Find(pc.ctx, bson.M{}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取权证规则失败"})
+	}
+	defer cursor.Close(pc.ctx)
+
+	rules := []models.PowRule{}
+	if err := cursor.All(pc.ctx, &rules); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析权证规则失败"})
+	}
+	return c.JSON(fiber.Map{"rules": rules})
+}
+
+func validatePowRule(rule models.PowRule) string {
+	if rule.Name == "" {
+		return "规则名称不能为空"
+	}
+	switch rule.Type {
+	case models.PowRuleMultiplier:
+		if rule.Multiplier <= 0 {
+			return "倍率必须大于0"
+		}
+	case models.PowRuleFirstOrder:
+		if rule.Bonus <= 0 {
+			return "首单奖励必须大于0"
+		}
+	default:
+		return "无效的规则类型"
+	}
+	if !rule.StartAt.IsZero() && !rule.EndAt.IsZero() && rule.EndAt.Before(rule.StartAt) {
+		return "结束时间不能早于开始时间"
+	}
+	return ""
+}
+
+// 后台添加权证规则
+func (pc *PowController) AddPowRule(c *fiber.Ctx) error {
+	var rule models.PowRule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if msg := validatePowRule(rule); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	rule.ID = primitive.NewObjectID()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+	if _, err := pc.powRuleCollection.InsertOne(pc.ctx, rule); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "添加权证规则失败"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "权证规则添加成功", "rule": rule})
+}
+
+// 后台更新权证规则
+func (pc *PowController) UpdatePowRule(c *fiber.Ctx) error {
+	ruleID, err := primitive.ObjectIDFromHex(c.Params("ruleID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的规则ID"})
+	}
+
+	var rule models.PowRule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if msg := validatePowRule(rule); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	update := bson.M{"$set": bson.M{
+		"name":          rule.Name,
+		"type":          rule.Type,
+		"multiplier":    rule.Multiplier,
+		"bonus":         rule.Bonus,
+		"product_refs":  rule.ProductRefs,
+		"category_refs": rule.CategoryRefs,
+		"start_at":      rule.StartAt,
+		"end_at":        rule.EndAt,
+		"enabled":       rule.Enabled,
+		"updated_at":    time.Now(),
+	}}
+	result, err := pc.powRuleCollection.UpdateOne(pc.ctx, bson.M{"_id": ruleID}, update)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新权证规则失败"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的权证规则"})
+	}
+	return c.JSON(fiber.Map{"message": "权证规则更新成功"})
+}
+
+// 后台删除权证规则
+func (pc *PowController) DeletePowRule(c *fiber.Ctx) error {
+	ruleID, err := primitive.ObjectIDFromHex(c.Params("ruleID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的规则ID"})
+	}
+
+	result, err := pc.powRuleCollection.DeleteOne(pc.ctx, bson.M{"_id": ruleID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "删除权证规则失败"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的权证规则"})
+	}
+	return c.JSON(fiber.Map{"message": "权证规则删除成功"})
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPowConfigPatchToSet(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+	integer := func(v int) *int { return &v }
+	current := models.PowConfig{BaseRate: 1.5, MaxPerOrder: 100, MaxPerDay: 500, VestingDays: 7}
+
+	cases := []struct {
+		name    string
+		patch   powConfigPatch
+		want    bson.M
+		wantErr bool
+	}{
+		{"empty", powConfigPatch{}, bson.M{}, false},
+		{"only base rate", powConfigPatch{BaseRate: float(2)}, bson.M{"base_rate": 2.0}, false},
+		{"disable awards", powConfigPatch{BaseRate: float(0)}, bson.M{"base_rate": 0.0}, false},
+		{"only vesting", powConfigPatch{VestingDays: integer(0)}, bson.M{"vesting_days": 0}, false},
+		{"remove caps", powConfigPatch{MaxPerOrder: float(0), MaxPerDay: float(0)}, bson.M{"max_per_order": 0.0, "max_per_day": 0.0}, false},
+		{"order cap within day cap", powConfigPatch{MaxPerOrder: float(500)}, bson.M{"max_per_order": 500.0}, false},
+		{"negative base rate", powConfigPatch{BaseRate: float(-1)}, nil, true},
+		{"base rate too high", powConfigPatch{BaseRate: float(powMaxBaseRate + 1)}, nil, true},
+		{"negative order cap", powConfigPatch{MaxPerOrder: float(-1)}, nil, true},
+		{"negative day cap", powConfigPatch{MaxPerDay: float(-1)}, nil, true},
+		{"negative vesting", powConfigPatch{VestingDays: integer(-1)}, nil, true},
+		{"vesting too long", powConfigPatch{VestingDays: integer(powMaxVestingDays + 1)}, nil, true},
+		// 与当前配置合并后检查：单笔上限不能超过每日上限
+		{"order cap above current day cap", powConfigPatch{MaxPerOrder: float(600)}, nil, true},
+		{"day cap below current order cap", powConfigPatch{MaxPerDay: float(50)}, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			set, msg := tc.patch.toSet(current)
+			if tc.wantErr {
+				if msg == "" {
+					t.Errorf("toSet = %v, want error", set)
+				}
+				return
+			}
+			if msg != "" {
+				t.Fatalf("toSet error: %s", msg)
+			}
+			if !reflect.DeepEqual(set, tc.want) {
+				t.Errorf("toSet = %v, want %v", set, tc.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package controllers
 
 import (
+	"blog-auth-server/media"
 	"blog-auth-server/models"
+	"blog-auth-server/money"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,22 +23,34 @@ import (
 // var SecretKey = []byte("SecretKey")
 
 type ProductController struct {
-	collection *mongo.Collection
-	ctx        context.Context
+	collection         *mongo.Collection
+	revisionCollection *mongo.Collection // 商品修改历史
+	ctx                context.Context
+	visitorController  *VisitorController
+	currencyController *CurrencyController
+	media              *media.Service // 商品图片处理和存储
 }
 
-func NewProductController(collection *mongo.Collection, ctx context.Context) *ProductController {
-	return &ProductController{
-		collection: collection,
-		ctx:        ctx,
+func NewProductController(collection, revisionCollection *mongo.Collection, ctx context.Context, visitorController *VisitorController, currencyController *CurrencyController, mediaService *media.Service) *ProductController {
+	pc := &ProductController{
+		collection:         collection,
+		revisionCollection: revisionCollection,
+		ctx:                ctx,
+		visitorController:  visitorController,
+		currencyController: currencyController,
+		media:              mediaService,
 	}
+	// 定时下架到期的商品
+	go pc.startScheduledUnpublish()
+	return pc
 }
-func generateTimestampFilename(originalFilename string) string {
-	ext := filepath.Ext(originalFilename)
-	name := strings.TrimSuffix(originalFilename, ext)
-	timestamp := time.Now().Format("20060102150405") //按照20060102150405 这种格式格式化时间
-	return fmt.Sprintf("%s_%s%s", name, timestamp, ext)
+
+// 商品及按所选货币换算的展示价格，price 仍为人民币金额（元）
+type productView struct {
+	models.Product
+	DisplayPrice *DisplayPrice `json:"display_price,omitempty"`
 }
+
 func (pc *ProductController) AddProduct(c *fiber.Ctx) error {
 	// 创建一个新的Product变量
 	var product models.Product
@@ -47,19 +61,23 @@ func (pc *ProductController) AddProduct(c *fiber.Ctx) error {
 	}
 
 	// 进行数据验证
-	if product.Name == "" || product.Description == "" || product.Price == 0 {
+	if product.Name == "" || product.Description == "" || product.Price <= 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing required product information"})
 	}
 
 	product.ID = primitive.NewObjectID()
 	product.CreatedAt = time.Now()
+	product.Version = 1
+	product.AmountUnit = models.AmountUnitFen
+	// 新商品默认为草稿，确认无误后发布；status=published 时直接上架
+	product.Status = c.FormValue("status", models.ProductDraft)
+	if product.Status != models.ProductDraft && product.Status != models.ProductPublished {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "新产品的状态只能为 draft 或 published"})
+	}
+	product.PublishAt, product.UnpublishAt, product.ArchivedAt = nil, nil, nil
 	// 可以添加更多的验证逻辑，例如检查价格是否为正数、库存是否有效等
 
 	// form上传
-	mainImageURL := ""
-	colorVariantImages := make([]models.Image, 0)
-	introductoryImages := make([]models.Image, 0)
-
 	form, err := c.MultipartForm()
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Error retrieving uploaded files"})
@@ -98,86 +116,32 @@ func (pc *ProductController) AddProduct(c *fiber.Ctx) error {
 	// 将提取的尺寸和颜色数据赋值给 product 结构体的相应字段
 	product.SizeColors = sizeColors
 
-	// 处理图片上传
-	// 处理主图
-	mainFiles := form.File["main_image"]
-	if len(mainFiles) > 0 {
-		mainFile := mainFiles[0]
-		log.Println("Main image filename:", mainFile.Filename)
-		src, err := mainFile.Open()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error opening main image file"})
-		}
-		defer src.Close()
-
-		if err := os.MkdirAll("upload", os.ModePerm); err != nil {
-
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error creating upload directory"})
-		}
-
-		// 生成带时间戳的唯一文件名
-		uniqueFilename := generateTimestampFilename(mainFile.Filename)
-
-		dstPath := filepath.Join("upload", uniqueFilename)
-		log.Println("Destination path for main image:", dstPath)
-		dst, err := os.Create(dstPath)
-		if err != nil {
-			log.Println("Error creating destination file for main image:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error creating destination file for main image"})
-		}
-		defer dst.Close()
-
-		// Save the main image file
-		err = c.SaveFile(mainFile, dstPath)
-		if err != nil {
-			log.Println("Error saving main image file:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error saving main image file"})
+	// 处理图片上传，校验格式和大小、去除 EXIF，按内容哈希保存并生成缩略图
+	product.Images = []models.Image{}
+	for _, group := range []struct{ field, imageType string }{
+		{"main_image", "main"},
+		{"color_variant_images", "color_variant"},
+		{"introductory_images", "introductory"},
+	} {
+		files := form.File[group.field]
+		if group.imageType == "main" && len(files) > 1 {
+			files = files[:1] // 只有一张主图
 		}
-
-		mainImageURL = dstPath
-	}
-
-	// 处理颜色变体图片
-	colorVariantFiles := form.File["color_variant_images"]
-	for _, file := range colorVariantFiles {
-		log.Println(file.Filename, file.Size, file.Header["Content-Type"][0])
-		// 生成带时间戳的唯一文件名
-		uniqueFilename := generateTimestampFilename(file.Filename)
-		// Save the color variant image file
-		dstPath := filepath.Join("upload", uniqueFilename)
-		err := c.SaveFile(file, dstPath)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error saving color variant image file"})
+		for _, file := range files {
+			image, err := processImageFile(c.Context(), pc.media, file, group.imageType)
+			if err != nil {
+				return imageErrorResponse(c, err)
+			}
+			product.Images = append(product.Images, image)
 		}
-
-		colorVariantImages = append(colorVariantImages, models.Image{URL: dstPath, Type: "color_variant"})
 	}
 
-	// 处理介绍图片
-	introductoryFiles := form.File["introductory_images"]
-
-	for _, file := range introductoryFiles {
-		log.Println(file.Filename, file.Size, file.Header["Content-Type"][0])
-		// 生成带时间戳的唯一文件名
-		uniqueFilename := generateTimestampFilename(file.Filename)
-		// Save the introductory image file
-		dstPath := filepath.Join("upload", uniqueFilename)
-		err := c.SaveFile(file, dstPath)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error saving introductory image file"})
-		}
-
-		introductoryImages = append(introductoryImages, models.Image{URL: dstPath, Type: "introductory"})
-	}
-
-	product.Images = append([]models.Image{{URL: mainImageURL, Type: "main", MainImage: true}}, colorVariantImages...)
-	product.Images = append(product.Images, introductoryImages...)
-
 	// 插入产品到MongoDB
 	insertResult, err := pc.collection.InsertOne(c.Context(), product)
 	if err != nil {
 		return c.JSON(fiber.Map{"error": "Failed to add product"})
 	}
+	pc.recordRevision(c, models.RevisionCreate, nil, product)
 
 	// 返回成功响应，包括新创建的产品ID
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -201,29 +165,144 @@ func (pc *ProductController) DelProduct(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid Product ID"})
 	}
 
-	// 定义删除过滤器
-	filter := bson.M{"_id": objectID}
+	// 删除只归档，订单中的 ProductRef 仍然能查到商品
+	return pc.archiveProduct(c, objectID)
+}
 
-	// 使用DeleteOne方法根据ID删除产品
-	deleteResult, err := pc.collection.DeleteOne(pc.ctx, filter)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// 没有找到产品
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+// 删除不再被其他商品引用的图片文件，失败时留给定时清理
+func (pc *ProductController) removeImages(ctx context.Context, images []models.Image) {
+	storage := pc.media.Storage()
+	for _, image := range images {
+		if image.URL == "" {
+			continue
+		}
+		count, err := pc.collection.CountDocuments(ctx, bson.M{"images.url": image.URL})
+		if err != nil || count > 0 {
+			continue
+		}
+		for _, key := range imageKeys(storage, image) {
+			if err := storage.Delete(ctx, key); err != nil {
+				log.Printf("删除商品图片失败 (%s): %v", key, err)
+			}
+		}
+	}
+}
+
+// productPatch 后台编辑产品时可以修改的字段，未传的字段保持不变
+// 图片通过图片接口修改，运费模板通过运费模板接口指定
+type productPatch struct {
+	Version     *int64                `json:"version"` // 读取时的版本号，与当前版本不一致时拒绝更新
+	Name        *string               `json:"name"`
+	Description *string               `json:"description"`
+	Price       *money.Fen            `json:"price"`
+	Inventory   *int                  `json:"inventory"`
+	SizeColors  *[]models.SizeColor   `json:"size_colors"`
+	Categories  *[]models.CategoryRef `json:"categories"`
+	Weight      *int                  `json:"weight"`
+}
+
+// 校验尺寸和颜色：尺寸不能为空或重复，同一尺寸下颜色不能为空或重复
+func validateSizeColors(sizeColors []models.SizeColor) string {
+	sizes := make(map[string]bool, len(sizeColors))
+	for _, sc := range sizeColors {
+		if strings.TrimSpace(sc.Size) == "" {
+			return "尺寸不能为空"
+		}
+		if sizes[sc.Size] {
+			return fmt.Sprintf("尺寸 %s 重复", sc.Size)
+		}
+		sizes[sc.Size] = true
+		colors := make(map[string]bool, len(sc.Colors))
+		for _, color := range sc.Colors {
+			if strings.TrimSpace(color) == "" {
+				return fmt.Sprintf("尺寸 %s 的颜色不能为空", sc.Size)
+			}
+			if colors[color] {
+				return fmt.Sprintf("尺寸 %s 的颜色 %s 重复", sc.Size, color)
+			}
+			colors[color] = true
 		}
-		// 删除出错
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
 	}
+	return ""
+}
 
-	// 如果删除的文档数量为0，表示没有找到要删除的产品
-	if deleteResult.DeletedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+// 校验修改内容并生成 $set，product 为当前产品，用于检查颜色图片是否仍然有效
+func (p *productPatch) toSet(product *models.Product) (bson.M, string) {
+	set := bson.M{}
+	if p.Name != nil {
+		name := strings.TrimSpace(*p.Name)
+		if name == "" {
+			return nil, "产品名称不能为空"
+		}
+		set["name"] = name
+	}
+	if p.Description != nil {
+		if strings.TrimSpace(*p.Description) == "" {
+			return nil, "产品描述不能为空"
+		}
+		set["description"] = *p.Description
 	}
+	if p.Price != nil {
+		if *p.Price <= 0 {
+			return nil, "价格必须大于 0"
+		}
+		set["price"] = *p.Price
+		set["amount_unit"] = models.AmountUnitFen
+	}
+	if p.Inventory != nil {
+		if *p.Inventory < 0 {
+			return nil, "库存不能为负数"
+		}
+		set["inventory"] = *p.Inventory
+	}
+	if p.Weight != nil {
+		if *p.Weight < 0 {
+			return nil, "重量不能为负数"
+		}
+		set["weight"] = *p.Weight
+	}
+	if p.SizeColors != nil {
+		sizeColors := *p.SizeColors
+		if sizeColors == nil {
+			sizeColors = []models.SizeColor{}
+		}
+		if msg := validateSizeColors(sizeColors); msg != "" {
+			return nil, msg
+		}
+		// 已关联颜色的图片必须仍在规格中，否则先在图片接口中取消关联
+		colors := productColors(&models.Product{SizeColors: sizeColors})
+		for _, image := range product.Images {
+			if image.Color != "" && !colors[image.Color] {
+				return nil, fmt.Sprintf("颜色 %s 仍有关联的图片", image.Color)
+			}
+		}
+		set["sizecolors"] = sizeColors
+	}
+	if p.Categories != nil {
+		categories := *p.Categories
+		if categories == nil {
+			categories = []models.CategoryRef{}
+		}
+		for _, category := range categories {
+			if category.ID.IsZero() || strings.TrimSpace(category.Name) == "" {
+				return nil, "无效的产品分类"
+			}
+		}
+		set["categories"] = categories
+	}
+	return set, ""
+}
 
-	// 返回成功的响应
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Product deleted successfully"})
+// 版本号条件，旧数据没有版本号时视为 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
 }
 
+// UpdateProduct 后台编辑产品，只接受 productPatch 中的字段
+// 请求需携带读取时的 version，成功后版本号加 1 并返回更新后的产品；版本不一致时返回 409
 func (pc *ProductController) UpdateProduct(c *fiber.Ctx) error {
 	prodID := c.Params("id")
 	objectID, err := primitive.ObjectIDFromHex(prodID)
@@ -231,50 +310,63 @@ func (pc *ProductController) UpdateProduct(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的产品ID"})
 	}
 
-	var updatedFields map[string]interface{}
-	if err := c.BodyParser(&updatedFields); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无法解析请求体"})
+	var patch productPatch
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无法解析请求体: " + err.Error()})
+	}
+	if patch.Version == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "缺少 version"})
 	}
 
-	// 只更新提供的字段
-	update := bson.M{"$set": updatedFields}
-
-	result, err := pc.collection.UpdateOne(pc.ctx, bson.M{"_id": objectID}, update)
-	if err != nil {
+	var product models.Product
+	if err := pc.collection.FindOne(pc.ctx, bson.M{"_id": objectID}).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到产品"})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新产品时出错"})
 	}
+	if product.Version != *patch.Version {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "产品已被修改，请刷新后重试", "product": product})
+	}
+	set, msg := patch.toSet(&product)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	if len(set) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "没有需要更新的字段"})
+	}
 
-	if result.MatchedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到产品"})
+	var updated models.Product
+	err = pc.collection.FindOneAndUpdate(pc.ctx,
+		bson.M{"_id": objectID, "version": versionFilter(*patch.Version)},
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "产品已被修改，请刷新后重试"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新产品时出错"})
 	}
-	// pc.ResetAllProductCategories(c)
+	pc.recordRevision(c, models.RevisionUpdate, &product, updated)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "产品更新成功",
+		"product": updated,
 	})
 }
 
 // 重置所有产品的分类
 func (pc *ProductController) ResetAllProductCategories(c *fiber.Ctx) error {
-	// 第一步：删除所有产品的 Categories 字段
-	_, err := pc.collection.UpdateMany(
-		pc.ctx,
-		bson.M{},
-		bson.M{"$unset": bson.M{"categories": ""}},
-	)
-	if err != nil {
-		log.Printf("删除 Categories 字段时出错: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "重置分类时出错"})
-	}
-
-	// 第二步：为所有产品添加一个空的 Categories 数组
-	_, err = pc.collection.UpdateMany(
-		pc.ctx,
+	// 所有产品的 Categories 字段改为空数组，并为每个产品记录修改历史
+	_, err := updateProductsWithRevisions(pc.ctx, pc.collection, pc.revisionCollection, revisionActor(c), models.RevisionUpdate,
 		bson.M{},
-		bson.M{"$set": bson.M{"categories": []models.CategoryRef{}}},
+		bson.M{"$set": bson.M{"categories": []models.CategoryRef{}}, "$inc": bson.M{"version": 1}},
 	)
 	if err != nil {
-		log.Printf("添加空 Categories 数组时出错: %v", err)
+		log.Printf("重置 Categories 字段时出错: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "重置分类时出错"})
 	}
 
@@ -283,6 +375,7 @@ func (pc *ProductController) ResetAllProductCategories(c *fiber.Ctx) error {
 
 
 
+// currency 参数指定展示货币时，每个商品附带换算后的 display_price
 func (pc *ProductController) AllProduct(c *fiber.Ctx) error {
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil {
@@ -292,18 +385,28 @@ func (pc *ProductController) AllProduct(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid limit parameter"})
 	}
+	display, err := pc.currencyController.resolve(pc.ctx, c.Query("currency"))
+	if err != nil {
+		return currencyErrorResponse(c, err)
+	}
 
 	skip := (page - 1) * limit
 	findOptions := options.Find()
 	findOptions.SetSkip(int64(skip))
 	findOptions.SetLimit(int64(limit))
 
-	cursor, err := pc.collection.Find(pc.ctx, bson.D{}, findOptions)
+	// 前台只展示已发布的商品，后台可以按 status 筛选
+	filter := publicProductFilter(time.Now())
+	if isAdminRequest(c) {
+		filter = adminProductFilter(c.Query("status"))
+	}
+
+	cursor, err := pc.collection.Find(pc.ctx, filter, findOptions)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
 	}
 	defer cursor.Close(pc.ctx)
-	var products []models.Product
+	var products []productView
 	for cursor.Next(pc.ctx) {
 		var product models.Product
 		if err := cursor.Decode(&product); err != nil {
@@ -312,24 +415,26 @@ func (pc *ProductController) AllProduct(c *fiber.Ctx) error {
 			continue
 			// return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error decoding product"})
 		}
-		products = append(products, product)
+		products = append(products, productView{Product: product, DisplayPrice: display.price(product.Price)})
 	}
 
 	if err := cursor.Err(); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cursor Error: " + err.Error()})
 	}
 
-	totalCount, err := pc.collection.CountDocuments(pc.ctx, bson.D{})
+	totalCount, err := pc.collection.CountDocuments(pc.ctx, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error counting products"})
 	}
 
 	response := struct {
-		Products []models.Product `json:"products"`
-		Total    int64            `json:"total"`
+		Products []productView `json:"products"`
+		Total    int64         `json:"total"`
+		FxRate   fiber.Map     `json:"fx_rate,omitempty"` // 换算使用的汇率
 	}{
 		Products: products,
 		Total:    totalCount,
+		FxRate:   display.info(),
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
@@ -381,6 +486,11 @@ func (pc *ProductController) FetchOne(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid Product ID"})
 	}
 
+	display, err := pc.currencyController.resolve(pc.ctx, c.Query("currency"))
+	if err != nil {
+		return currencyErrorResponse(c, err)
+	}
+
 	// 创建用于查询的结构体变量
 	var product models.Product
 
@@ -395,6 +505,14 @@ func (pc *ProductController) FetchOne(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
 	}
 
-	// 将查询到的产品信息序列化为JSON并返回
-	return c.JSON(product)
+	// 后台查看产品时已经过管理员中间件，只统计前台的浏览；前台看不到未发布和已删除的商品
+	if !isAdminRequest(c) {
+		if !product.Visible(time.Now()) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+		}
+		pc.visitorController.Track(c, models.EventProductView, product.ID.Hex())
+	}
+
+	// 将查询到的产品信息序列化为JSON并返回，指定 currency 时附带换算后的展示价格
+	return c.JSON(productView{Product: product, DisplayPrice: display.price(product.Price)})
 }
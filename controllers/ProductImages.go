@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 每个商品最多的图片数量
+const maxProductImages = 30
+
+// 图片类型
+const (
+	imageTypeMain         = "main"
+	imageTypeColorVariant = "color_variant"
+	imageTypeIntroductory = "introductory"
+)
+
+var errImagesConflict = errors.New("产品图片已被修改，请刷新后重试")
+
+// 读取路径参数中的商品，失败时返回状态码和错误信息
+func (pc *ProductController) productForImages(c *fiber.Ctx) (*models.Product, int, string) {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, fiber.StatusBadRequest, "无效的产品ID"
+	}
+	var product models.Product
+	if err := pc.collection.FindOne(pc.ctx, bson.M{"_id": objectID}).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fiber.StatusNotFound, "未找到产品"
+		}
+		return nil, fiber.StatusInternalServerError, "查询产品失败"
+	}
+	return &product, fiber.StatusOK, ""
+}
+
+// 以读取时的图片列表作为条件保存，避免覆盖并发的修改
+func (pc *ProductController) updateImages(c *fiber.Ctx, product *models.Product, images []models.Image) error {
+	var imagesMatch interface{} = product.Images
+	if len(product.Images) == 0 {
+		imagesMatch = bson.M{"$in": bson.A{nil, bson.A{}}}
+	}
+	_, err := pc.updateProduct(c, models.RevisionImages,
+		bson.M{"_id": product.ID, "images": imagesMatch},
+		bson.M{"$set": bson.M{"images": images}, "$inc": bson.M{"version": 1}})
+	if err == mongo.ErrNoDocuments {
+		return errImagesConflict
+	}
+	return err
+}
+
+// 保存图片列表并返回更新后的图片
+func (pc *ProductController) saveImages(c *fiber.Ctx, product *models.Product, images []models.Image) error {
+	return imagesResponse(c, images, pc.updateImages(c, product, images))
+}
+
+func imagesResponse(c *fiber.Ctx, images []models.Image, err error) error {
+	if err == errImagesConflict {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新产品图片失败"})
+	}
+	return c.JSON(fiber.Map{"message": "产品图片已更新", "images": images})
+}
+
+// 按 URL 查找图片
+func findImage(images []models.Image, url string) int {
+	for i, image := range images {
+		if url != "" && image.URL == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// 商品尺寸规格中出现的所有颜色
+func productColors(product *models.Product) map[string]bool {
+	colors := make(map[string]bool)
+	for _, sc := range product.SizeColors {
+		for _, color := range sc.Colors {
+			colors[color] = true
+		}
+	}
+	return colors
+}
+
+// 将第 index 张图片设为主图，原主图改为介绍图
+func setMainImage(images []models.Image, index int) {
+	for i := range images {
+		if i == index {
+			images[i].MainImage = true
+			images[i].Type = imageTypeMain
+			images[i].Color = ""
+		} else if images[i].MainImage || images[i].Type == imageTypeMain {
+			images[i].MainImage = false
+			images[i].Type = imageTypeIntroductory
+		}
+	}
+}
+
+// 后台为已有商品上传图片
+// 上传字段 images（可多张），type 为 main / color_variant / introductory（默认），color_variant 需要同时传 color
+func (pc *ProductController) AddProductImages(c *fiber.Ctx) error {
+	product, status, msg := pc.productForImages(c)
+	if product == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+
+	imageType := c.FormValue("type", imageTypeIntroductory)
+	color := c.FormValue("color")
+	switch imageType {
+	case imageTypeMain, imageTypeIntroductory:
+		color = ""
+	case imageTypeColorVariant:
+		if !productColors(product)[color] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "颜色不在商品规格中"})
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的图片类型"})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请上传图片"})
+	}
+	files := form.File["images"]
+	if len(files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请上传图片"})
+	}
+	if imageType == imageTypeMain && len(files) > 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "主图只能上传一张"})
+	}
+	if len(product.Images)+len(files) > maxProductImages {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("每个产品最多 %d 张图片", maxProductImages)})
+	}
+
+	images := append([]models.Image{}, product.Images...)
+	index := -1
+	for _, file := range files {
+		image, err := processImageFile(c.Context(), pc.media, file, imageType)
+		if err != nil {
+			return imageErrorResponse(c, err)
+		}
+		// 同一张图片重复上传时内容哈希相同，不重复添加
+		if index = findImage(images, image.URL); index >= 0 {
+			continue
+		}
+		image.Color = color
+		images = append(images, image)
+		index = len(images) - 1
+	}
+	if imageType == imageTypeMain {
+		setMainImage(images, index)
+	}
+	return pc.saveImages(c, product, images)
+}
+
+// 后台删除商品图片，url 为图片地址，不再被其他商品引用的文件同时删除
+func (pc *ProductController) DeleteProductImage(c *fiber.Ctx) error {
+	product, status, msg := pc.productForImages(c)
+	if product == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	index := findImage(product.Images, c.Query("url"))
+	if index < 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到该图片"})
+	}
+
+	removed := product.Images[index]
+	images := append(append([]models.Image{}, product.Images[:index]...), product.Images[index+1:]...)
+	err := pc.updateImages(c, product, images)
+	if err == nil {
+		pc.removeImages(pc.ctx, []models.Image{removed})
+	}
+	return imagesResponse(c, images, err)
+}
+
+// 后台调整商品图片顺序，urls 需包含该商品的全部图片
+func (pc *ProductController) ReorderProductImages(c *fiber.Ctx) error {
+	var req struct {
+		URLs []string `json:"urls"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	product, status, msg := pc.productForImages(c)
+	if product == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if len(req.URLs) != len(product.Images) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "需要提供全部图片的顺序"})
+	}
+
+	images := make([]models.Image, 0, len(req.URLs))
+	seen := make(map[string]bool, len(req.URLs))
+	for _, url := range req.URLs {
+		index := findImage(product.Images, url)
+		if index < 0 || seen[url] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "图片列表与产品不一致"})
+		}
+		seen[url] = true
+		images = append(images, product.Images[index])
+	}
+	return pc.saveImages(c, product, images)
+}
+
+// 后台设置商品主图
+func (pc *ProductController) SetProductMainImage(c *fiber.Ctx) error {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	product, status, msg := pc.productForImages(c)
+	if product == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	index := findImage(product.Images, req.URL)
+	if index < 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到该图片"})
+	}
+
+	images := append([]models.Image{}, product.Images...)
+	setMainImage(images, index)
+	return pc.saveImages(c, product, images)
+}
+
+// 后台将图片关联到商品规格中的颜色，color 为空时取消关联
+func (pc *ProductController) SetProductImageColor(c *fiber.Ctx) error {
+	var req struct {
+		URL   string `json:"url"`
+		Color string `json:"color"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	product, status, msg := pc.productForImages(c)
+	if product == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	index := findImage(product.Images, req.URL)
+	if index < 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到该图片"})
+	}
+	if product.Images[index].MainImage {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "主图不能关联颜色"})
+	}
+	if req.Color != "" && !productColors(product)[req.Color] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "颜色不在商品规格中"})
+	}
+
+	images := append([]models.Image{}, product.Images...)
+	images[index].Color = req.Color
+	if req.Color != "" {
+		images[index].Type = imageTypeColorVariant
+	} else {
+		images[index].Type = imageTypeIntroductory
+	}
+	return pc.saveImages(c, product, images)
+}
@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"blog-auth-server/money"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 操作的管理员，取不到时为空
+func revisionActor(c *fiber.Ctx) primitive.ObjectID {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return primitive.NilObjectID
+	}
+	adminID, _ := primitive.ObjectIDFromHex(fmt.Sprint(claims["user_id"]))
+	return adminID
+}
+
+// 商品按 JSON 字段拆分，用于比较修改前后的差异
+func productFields(product *models.Product) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if product == nil {
+		return fields
+	}
+	data, err := json.Marshal(product)
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		log.Printf("解析商品字段失败 (ProductID: %s): %v", product.ID.Hex(), err)
+	}
+	return fields
+}
+
+// 将 JSON 值转换为可以保存到 MongoDB 的值，整数保持为整数；金额与接口一致以元保存
+func jsonValue(raw json.RawMessage) interface{} {
+	if raw == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	return normalizeNumbers(value)
+}
+
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	}
+	return value
+}
+
+// productChanges 比较修改前后的商品，返回有变化的字段，版本号不计入
+func productChanges(before, after *models.Product) []models.FieldChange {
+	from, to := productFields(before), productFields(after)
+	names := make([]string, 0, len(to))
+	for name := range to {
+		names = append(names, name)
+	}
+	for name := range from {
+		if _, ok := to[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.FieldChange{}
+	for _, name := range names {
+		if name == "version" || bytes.Equal(from[name], to[name]) {
+			continue
+		}
+		changes = append(changes, models.FieldChange{Field: name, From: jsonValue(from[name]), To: jsonValue(to[name])})
+	}
+	return changes
+}
+
+// newProductRevision 生成一条修改记录，before 为空表示新建商品
+func newProductRevision(actor primitive.ObjectID, action string, before *models.Product, after models.Product) models.ProductRevision {
+	revision := models.ProductRevision{
+		ID:         primitive.NewObjectID(),
+		ProductRef: after.ID,
+		Version:    after.Version,
+		Action:     action,
+		AdminRef:   actor,
+		Changes:    []models.FieldChange{},
+		Snapshot:   &after,
+		CreatedAt:  time.Now(),
+	}
+	if before != nil {
+		revision.Changes = productChanges(before, &after)
+	}
+	return revision
+}
+
+// 保存修改记录；商品已经修改成功，记录失败只写日志
+func insertProductRevisions(ctx context.Context, collection *mongo.Collection, revisions ...models.ProductRevision) {
+	docs := make([]interface{}, 0, len(revisions))
+	for _, revision := range revisions {
+		// 没有实际变化的写入（例如重复发布）不记录
+		if revision.Action != models.RevisionCreate && revision.Action != models.RevisionRestore && len(revision.Changes) == 0 {
+			continue
+		}
+		docs = append(docs, revision)
+	}
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		log.Printf("保存商品修改记录失败: %v", err)
+	}
+}
+
+// updateProductsWithRevisions 批量修改商品并为每个商品记录修改前后的差异，返回修改的数量
+func updateProductsWithRevisions(ctx context.Context, productCollection, revisionCollection *mongo.Collection, actor primitive.ObjectID, action string, filter, update bson.M) (int64, error) {
+	var befores []models.Product
+	cursor, err := productCollection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if err := cursor.All(ctx, &befores); err != nil {
+		return 0, err
+	}
+	if len(befores) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, len(befores))
+	for i, product := range befores {
+		ids[i] = product.ID
+	}
+
+	// 只修改读取过的商品，之后新满足条件的留到下次处理
+	result, err := productCollection.UpdateMany(ctx, bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$in": ids}}}}, update)
+	if err != nil {
+		return 0, err
+	}
+
+	var afters []models.Product
+	cursor, err = productCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err == nil {
+		err = cursor.All(ctx, &afters)
+	}
+	if err != nil {
+		log.Printf("读取修改后的商品失败，未记录修改历史: %v", err)
+		return result.ModifiedCount, nil
+	}
+	byID := make(map[primitive.ObjectID]models.Product, len(afters))
+	for _, product := range afters {
+		byID[product.ID] = product
+	}
+	revisions := make([]models.ProductRevision, 0, len(befores))
+	for i := range befores {
+		if after, ok := byID[befores[i].ID]; ok {
+			revisions = append(revisions, newProductRevision(actor, action, &befores[i], after))
+		}
+	}
+	insertProductRevisions(ctx, revisionCollection, revisions...)
+	return result.ModifiedCount, nil
+}
+
+// 记录一次商品修改，操作人取自请求的 claims
+func (pc *ProductController) recordRevision(c *fiber.Ctx, action string, before *models.Product, after models.Product) {
+	insertProductRevisions(pc.ctx, pc.revisionCollection, newProductRevision(revisionActor(c), action, before, after))
+}
+
+// 修改单个商品并记录修改历史，返回修改后的商品；没有匹配的商品时返回 mongo.ErrNoDocuments
+func (pc *ProductController) updateProduct(c *fiber.Ctx, action string, filter, update bson.M) (*models.Product, error) {
+	var before models.Product
+	err := pc.collection.FindOneAndUpdate(pc.ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err != nil {
+		return nil, err
+	}
+	var after models.Product
+	if err := pc.collection.FindOne(pc.ctx, bson.M{"_id": before.ID}).Decode(&after); err != nil {
+		return nil, err
+	}
+	pc.recordRevision(c, action, &before, after)
+	return &after, nil
+}
+
+// 读取路径参数中的商品ID和修改记录ID
+func revisionParams(c *fiber.Ctx) (primitive.ObjectID, primitive.ObjectID, string) {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return productID, primitive.NilObjectID, "无效的产品ID"
+	}
+	revisionID, err := primitive.ObjectIDFromHex(c.Params("revisionID"))
+	if err != nil {
+		return productID, revisionID, "无效的修改记录ID"
+	}
+	return productID, revisionID, ""
+}
+
+// GetProductRevisions 后台查看商品的修改历史，按时间倒序分页，不包含完整快照
+func (pc *ProductController) GetProductRevisions(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的产品ID"})
+	}
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	filter := bson.M{"product_ref": productID}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"snapshot": 0})
+	cursor, err := pc.revisionCollection.Find(pc.ctx, filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取修改历史失败"})
+	}
+	revisions := []models.ProductRevision{}
+	if err := cursor.All(pc.ctx, &revisions); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析修改历史失败"})
+	}
+	total, err := pc.revisionCollection.CountDocuments(pc.ctx, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计修改历史失败"})
+	}
+	return c.JSON(fiber.Map{"revisions": revisions, "total": total})
+}
+
+// GetProductRevision 后台查看一条修改记录，包含修改后的完整商品
+func (pc *ProductController) GetProductRevision(c *fiber.Ctx) error {
+	productID, revisionID, msg := revisionParams(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	var revision models.ProductRevision
+	err := pc.revisionCollection.FindOne(pc.ctx, bson.M{"_id": revisionID, "product_ref": productID}).Decode(&revision)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到修改记录"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取修改记录失败"})
+	}
+	return c.JSON(fiber.Map{"revision": revision})
+}
+
+// RestoreProductRevision 后台将商品恢复到某条修改记录后的内容
+// 只恢复名称、描述、价格、尺寸颜色、分类和重量；图片、库存和上下架状态保持当前值
+// 请求需携带当前的 version，版本不一致时返回 409
+func (pc *ProductController) RestoreProductRevision(c *fiber.Ctx) error {
+	productID, revisionID, msg := revisionParams(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	var req struct {
+		Version *int64 `json:"version"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if req.Version == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "缺少 version"})
+	}
+
+	var revision models.ProductRevision
+	err := pc.revisionCollection.FindOne(pc.ctx, bson.M{"_id": revisionID, "product_ref": productID}).Decode(&revision)
+	if err == mongo.ErrNoDocuments || (err == nil && revision.Snapshot == nil) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到修改记录"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取修改记录失败"})
+	}
+
+	var product models.Product
+	if err := pc.collection.FindOne(pc.ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到产品"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询产品失败"})
+	}
+	if product.Version != *req.Version {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "产品已被修改，请刷新后重试", "product": product})
+	}
+
+	// 与编辑产品使用相同的校验，例如已关联图片的颜色必须仍在规格中
+	snapshot := revision.Snapshot
+	patch := productPatch{
+		Name:        &snapshot.Name,
+		Description: &snapshot.Description,
+		Price:       &snapshot.Price,
+		SizeColors:  &snapshot.SizeColors,
+		Categories:  &snapshot.Categories,
+		Weight:      &snapshot.Weight,
+	}
+	set, msg := patch.toSet(&product)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无法恢复该版本: " + msg})
+	}
+	restored := product
+	restored.Name, restored.Description, restored.Price = snapshot.Name, snapshot.Description, snapshot.Price
+	restored.SizeColors, restored.Categories, restored.Weight = snapshot.SizeColors, snapshot.Categories, snapshot.Weight
+	if len(productChanges(&product, &restored)) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "该版本与当前内容相同"})
+	}
+
+	var updated models.Product
+	err = pc.collection.FindOneAndUpdate(pc.ctx,
+		bson.M{"_id": productID, "version": versionFilter(*req.Version)},
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "产品已被修改，请刷新后重试"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "恢复产品失败"})
+	}
+
+	restoreRevision := newProductRevision(revisionActor(c), models.RevisionRestore, &product, updated)
+	restoreRevision.RestoredFrom = &revisionID
+	insertProductRevisions(pc.ctx, pc.revisionCollection, restoreRevision)
+
+	return c.JSON(fiber.Map{"message": "产品已恢复", "product": updated})
+}
+
+// 价格历史中的一次变动
+type priceChange struct {
+	Price     money.Fen          `json:"price"`
+	Previous  *money.Fen         `json:"previous,omitempty"` // 修改前的价格，新建商品时为空
+	Version   int64              `json:"version"`
+	Action    string             `json:"action"`
+	AdminRef  primitive.ObjectID `json:"admin_ref"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// GetProductPriceHistory 后台查询商品的价格变动，按时间正序
+// from、to 为日期（2006-01-02），用于限定时间范围，包含 to 当天
+func (pc *ProductController) GetProductPriceHistory(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的产品ID"})
+	}
+	createdAt := bson.M{}
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的开始日期"})
+		}
+		createdAt["$gte"] = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的结束日期"})
+		}
+		createdAt["$lt"] = t.AddDate(0, 0, 1)
+	}
+
+	filter := bson.M{
+		"product_ref": productID,
+		"$or": bson.A{
+			bson.M{"action": models.RevisionCreate},
+			bson.M{"changes.field": "price"},
+		},
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.M{
+			"version":        1,
+			"action":         1,
+			"admin_ref":      1,
+			"created_at":     1,
+			"snapshot.price": 1,
+			"changes":        bson.M{"$elemMatch": bson.M{"field": "price"}},
+		})
+	cursor, err := pc.revisionCollection.Find(pc.ctx, filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取价格历史失败"})
+	}
+	defer cursor.Close(pc.ctx)
+
+	history := []priceChange{}
+	for cursor.Next(pc.ctx) {
+		var revision struct {
+			Version   int64              `bson:"version"`
+			Action    string             `bson:"action"`
+			AdminRef  primitive.ObjectID `bson:"admin_ref"`
+			CreatedAt time.Time          `bson:"created_at"`
+			Snapshot  struct {
+				Price money.Fen `bson:"price"`
+			} `bson:"snapshot"`
+			// 修改记录中的值来自接口 JSON，价格以元保存
+			Changes []struct {
+				From *float64 `bson:"from"`
+			} `bson:"changes"`
+		}
+		if err := cursor.Decode(&revision); err != nil {
+			log.Printf("解析价格历史失败 (ProductID: %s): %v", productID.Hex(), err)
+			continue
+		}
+		entry := priceChange{
+			Price:     revision.Snapshot.Price,
+			Version:   revision.Version,
+			Action:    revision.Action,
+			AdminRef:  revision.AdminRef,
+			CreatedAt: revision.CreatedAt,
+		}
+		if len(revision.Changes) > 0 && revision.Changes[0].From != nil {
+			previous := money.FromYuan(*revision.Changes[0].From)
+			entry.Previous = &previous
+		}
+		history = append(history, entry)
+	}
+	if err := cursor.Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取价格历史失败"})
+	}
+	return c.JSON(fiber.Map{"product_id": productID, "history": history})
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 前台可见商品的查询条件，与 models.Product.Visible 一致
+func publicProductFilter(now time.Time) bson.M {
+	return bson.M{
+		"status": bson.M{"$in": bson.A{models.ProductPublished, nil}},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"publish_at": nil}, bson.M{"publish_at": bson.M{"$lte": now}}}},
+			bson.M{"$or": bson.A{bson.M{"unpublish_at": nil}, bson.M{"unpublish_at": bson.M{"$gt": now}}}},
+		},
+	}
+}
+
+// 后台按状态筛选商品的查询条件，status 为空时不筛选
+func adminProductFilter(status string) bson.M {
+	switch status {
+	case models.ProductPublished:
+		return bson.M{"status": bson.M{"$in": bson.A{models.ProductPublished, nil}}}
+	case models.ProductDraft, models.ProductArchived:
+		return bson.M{"status": status}
+	}
+	return bson.M{}
+}
+
+// 经过管理员中间件的请求带有 claims，前台商品接口不校验登录
+func isAdminRequest(c *fiber.Ctx) bool {
+	return c.Locals("claims") != nil
+}
+
+// 定时下架：到期的已发布商品改为草稿
+func (pc *ProductController) startScheduledUnpublish() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// 系统操作，修改历史中没有操作人
+			now := time.Now()
+			modified, err := updateProductsWithRevisions(pc.ctx, pc.collection, pc.revisionCollection, primitive.NilObjectID, models.RevisionStatus,
+				bson.M{"status": bson.M{"$in": bson.A{models.ProductPublished, nil}}, "unpublish_at": bson.M{"$lte": now}},
+				bson.M{
+					"$set":   bson.M{"status": models.ProductDraft},
+					"$unset": bson.M{"publish_at": "", "unpublish_at": ""},
+					"$inc":   bson.M{"version": 1},
+				})
+			if err != nil {
+				log.Printf("定时下架商品失败: %v", err)
+			} else if modified > 0 {
+				log.Printf("定时下架商品 %d 个", modified)
+			}
+		case <-pc.ctx.Done():
+			return
+		}
+	}
+}
+
+// SetProductStatus 后台修改商品状态
+// status 为 draft / published / archived；发布时可以指定 publish_at 定时上架、unpublish_at 定时下架
+func (pc *ProductController) SetProductStatus(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的产品ID"})
+	}
+	var req struct {
+		Status      string     `json:"status"`
+		PublishAt   *time.Time `json:"publish_at"`
+		UnpublishAt *time.Time `json:"unpublish_at"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+
+	now := time.Now()
+	set := bson.M{"status": req.Status}
+	unset := bson.M{}
+	switch req.Status {
+	case models.ProductPublished:
+		start := now
+		if req.PublishAt != nil && req.PublishAt.After(now) {
+			start = *req.PublishAt
+			set["publish_at"] = start
+		} else {
+			unset["publish_at"] = ""
+		}
+		if req.UnpublishAt != nil {
+			if !req.UnpublishAt.After(start) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "下架时间必须晚于上架时间"})
+			}
+			set["unpublish_at"] = *req.UnpublishAt
+		} else {
+			unset["unpublish_at"] = ""
+		}
+		unset["archived_at"] = ""
+	case models.ProductDraft:
+		unset = bson.M{"publish_at": "", "unpublish_at": "", "archived_at": ""}
+	case models.ProductArchived:
+		return pc.archiveProduct(c, objectID)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的产品状态"})
+	}
+
+	product, err := pc.updateProduct(c, models.RevisionStatus, bson.M{"_id": objectID},
+		bson.M{"$set": set, "$unset": unset, "$inc": bson.M{"version": 1}})
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到产品"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "修改产品状态失败"})
+	}
+	return c.JSON(fiber.Map{"message": "产品状态已更新", "product": product})
+}
+
+// 归档商品，文档和图片保留，历史订单、购物车和导出仍能查到名称和图片
+func (pc *ProductController) archiveProduct(c *fiber.Ctx, objectID primitive.ObjectID) error {
+	product, err := pc.updateProduct(c, models.RevisionStatus, bson.M{"_id": objectID},
+		bson.M{
+			"$set":   bson.M{"status": models.ProductArchived, "archived_at": time.Now()},
+			"$unset": bson.M{"publish_at": "", "unpublish_at": ""},
+			"$inc":   bson.M{"version": 1},
+		})
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal Server Error"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Product deleted successfully", "product": product})
+}
@@ -3,12 +3,14 @@ package controllers
 import (
 	"blog-auth-server/models"
 	"context"
+	"errors"
 	"log"
 	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofiber/fiber/v2"
+	"github.com/smartwalle/alipay/v3"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,15 +22,156 @@ type RedemptionOrderController struct {
 	userCollection            *mongo.Collection
 	orderCollection           *mongo.Collection
 	ctx                       context.Context
+	verifier                  *RedemptionVerifier // 为 nil 时无法进行链上核验
+	alipayClient              *alipay.Client
 }
 
-func NewRedemptionOrderController(redemptionOrderCollection, userCollection, orderCollection *mongo.Collection, ctx context.Context) *RedemptionOrderController {
-	return &RedemptionOrderController{
+func NewRedemptionOrderController(redemptionOrderCollection, userCollection, orderCollection *mongo.Collection, ctx context.Context, verifier *RedemptionVerifier, alipayClient *alipay.Client) *RedemptionOrderController {
+	roc := &RedemptionOrderController{
 		redemptionOrderCollection: redemptionOrderCollection,
 		userCollection:            userCollection,
 		orderCollection:           orderCollection,
 		ctx:                       ctx,
+		verifier:                  verifier,
+		alipayClient:              alipayClient,
 	}
+	// 启动处理中打款的后台重试 goroutine
+	go roc.startPayoutRetry()
+	return roc
+}
+
+// 赎回订单允许的状态流转
+var redemptionTransitions = map[string][]string{
+	models.RedemptionPending:  {models.RedemptionApproved, models.RedemptionRejected},
+	models.RedemptionApproved: {models.RedemptionPaidOut, models.RedemptionRejected},
+}
+
+// 仍在处理中的赎回订单状态，同一订单只能有一个
+var activeRedemptionStatuses = []string{models.RedemptionPending, models.RedemptionApproved, models.RedemptionPaidOut, "待处理", "已完成"}
+
+var (
+	errRedemptionNotFound   = errors.New("未找到指定的赎回订单")
+	errRedemptionTransition = errors.New("当前状态不允许此操作")
+	errRedemptionConflict   = errors.New("赎回订单状态已被修改，请刷新后重试")
+	errRedemptionUnverified = errors.New("钱包赎回的交易哈希尚未通过链上核验")
+	errRedemptionPayingOut  = errors.New("支付宝打款处理中或已完成，不能拒绝该赎回订单")
+	errRedemptionPayoutOnly = errors.New("支付宝收款的赎回订单只能通过打款接口完成")
+)
+
+// 通过钱包转账赎回的订单需要链上核验
+func isWalletRedemption(ro models.RedemptionOrder) bool {
+	return ro.WalletAddress != "" && ro.Hash != ""
+}
+
+// 兼容旧数据中的中文状态
+func redemptionStatus(status string) string {
+	switch status {
+	case "待处理":
+		return models.RedemptionPending
+	case "已完成":
+		return models.RedemptionPaidOut
+	case "已取消":
+		return models.RedemptionRejected
+	}
+	return status
+}
+
+// 旧数据中与新状态对应的中文状态
+var legacyRedemptionStatuses = map[string]string{
+	models.RedemptionPending:  "待处理",
+	models.RedemptionPaidOut:  "已完成",
+	models.RedemptionRejected: "已取消",
+}
+
+// 按状态查询的条件，同时匹配旧数据中的中文状态
+func redemptionStatusFilter(status string) interface{} {
+	status = redemptionStatus(status)
+	if legacy, ok := legacyRedemptionStatuses[status]; ok {
+		return bson.M{"$in": bson.A{status, legacy}}
+	}
+	return status
+}
+
+func canTransitRedemption(from, to string) bool {
+	for _, next := range redemptionTransitions[redemptionStatus(from)] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitRedemption 按状态机更新赎回订单，并处理冻结 Pow 的退回或扣除
+func (roc *RedemptionOrderController) transitRedemption(ctx context.Context, id primitive.ObjectID, to string, extra bson.M) (models.RedemptionOrder, error) {
+	var ro models.RedemptionOrder
+	err := roc.redemptionOrderCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&ro)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ro, errRedemptionNotFound
+		}
+		return ro, err
+	}
+	if !canTransitRedemption(ro.Status, to) {
+		return ro, errRedemptionTransition
+	}
+	if to == models.RedemptionApproved && isWalletRedemption(ro) && (ro.Verification == nil || !ro.Verification.Verified) {
+		return ro, errRedemptionUnverified
+	}
+
+	// 以当前状态作为条件，防止并发操作重复退回或扣除 Pow
+	filter := bson.M{"_id": id, "status": ro.Status}
+	switch to {
+	case models.RedemptionRejected:
+		// 已发起支付宝转账的订单不能拒绝，否则退回 Pow 后转账仍可能到账，用户会同时拿到 Pow 和现金
+		if ro.Payout != nil && (ro.Payout.Status == payoutDealing || ro.Payout.Status == payoutSuccess) {
+			return ro, errRedemptionPayingOut
+		}
+		filter["payout.status"] = bson.M{"$nin": bson.A{payoutDealing, payoutSuccess}}
+	case models.RedemptionPaidOut:
+		// 支付宝收款的订单只能在打款成功并保存结果后完成，不能手动标记
+		if ro.AlipayAccount != "" {
+			if ro.Payout == nil || ro.Payout.Status != payoutSuccess {
+				return ro, errRedemptionPayoutOnly
+			}
+			filter["payout.status"] = payoutSuccess
+		}
+	}
+
+	set := bson.M{"status": to, "updated_at": time.Now()}
+	for k, v := range extra {
+		set[k] = v
+	}
+	result, err := roc.redemptionOrderCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return ro, err
+	}
+	if result.ModifiedCount == 0 {
+		return ro, errRedemptionConflict
+	}
+
+	switch to {
+	case models.RedemptionRejected:
+		// 退回冻结的 Pow，并释放原始订单
+		_, err = roc.userCollection.UpdateOne(ctx, bson.M{"_id": ro.UserRef},
+			bson.M{"$inc": bson.M{"pow": ro.PowAmount, "pow_escrowed": -ro.PowAmount}})
+		if err != nil {
+			log.Printf("退回冻结Pow失败 (RedemptionID: %s): %v", id.Hex(), err)
+		}
+		_, err = roc.orderCollection.UpdateOne(ctx, bson.M{"_id": ro.OrderRef}, bson.M{"$set": bson.M{"is_redeemed": false}})
+		if err != nil {
+			log.Printf("更新原始订单失败: %v", err)
+		}
+	case models.RedemptionPaidOut:
+		// 打款完成，扣除冻结的 Pow
+		_, err = roc.userCollection.UpdateOne(ctx, bson.M{"_id": ro.UserRef},
+			bson.M{"$inc": bson.M{"pow_escrowed": -ro.PowAmount}})
+		if err != nil {
+			log.Printf("扣除冻结Pow失败 (RedemptionID: %s): %v", id.Hex(), err)
+		}
+	}
+
+	ro.Status = to
+	return ro, nil
 }
 
 // 个人用户创建赎回订单
@@ -68,13 +211,94 @@ func (roc *RedemptionOrderController) CreateRedemptionOrder(c *fiber.Ctx) error
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
 	}
 
+	// 验证支付宝信息或钱包信息
+	if (input.AlipayUsername == "" || input.AlipayAccount == "") && (input.WalletAddress == "" || input.Hash == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "必须提供支付宝信息或钱包信息"})
+	}
+
+	// 订单必须属于当前用户且已支付
+	var order models.Orders
+	err = roc.orderCollection.FindOne(c.Context(), bson.M{"_id": orderID, "user_ref": userID}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的订单"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+	}
+	if order.PaymentStatus != "已支付" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "订单尚未支付，不能赎回"})
+	}
+
+	// 同一订单只能有一个进行中的赎回
+	activeCount, err := roc.redemptionOrderCollection.CountDocuments(c.Context(), bson.M{
+		"order_ref": orderID,
+		"status":    bson.M{"$in": activeRedemptionStatuses},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询赎回订单失败"})
+	}
+	if activeCount > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "该订单已提交赎回"})
+	}
+
+	// 同一笔链上交易不能重复用于赎回
+	if input.Hash != "" {
+		used, err := roc.redemptionOrderCollection.CountDocuments(c.Context(), bson.M{
+			"hash":   input.Hash,
+			"status": bson.M{"$nin": bson.A{models.RedemptionRejected, legacyRedemptionStatuses[models.RedemptionRejected]}},
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询赎回订单失败"})
+		}
+		if used > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "该交易哈希已被使用"})
+		}
+	}
+
+	// 原子地标记订单为已赎回，防止并发重复提交
+	claimResult, err := roc.orderCollection.UpdateOne(
+		c.Context(),
+		bson.M{"_id": orderID, "user_ref": userID, "payment_status": "已支付", "is_redeemed": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"is_redeemed": true}},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新原始订单失败"})
+	}
+	if claimResult.ModifiedCount == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "该订单已提交赎回"})
+	}
+	releaseOrder := func() {
+		if _, err := roc.orderCollection.UpdateOne(c.Context(), bson.M{"_id": orderID}, bson.M{"$set": bson.M{"is_redeemed": false}}); err != nil {
+			log.Printf("回滚原始订单失败: %v", err)
+		}
+	}
+
+	// 冻结与订单金额等量的 Pow，余额不足时不会扣减
+	powAmount := order.TotalPrice.Yuan()
+	escrowResult, err := roc.userCollection.UpdateOne(
+		c.Context(),
+		bson.M{"_id": userID, "pow": bson.M{"$gte": powAmount}},
+		bson.M{"$inc": bson.M{"pow": -powAmount, "pow_escrowed": powAmount}},
+	)
+	if err != nil {
+		releaseOrder()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "冻结用户权证失败"})
+	}
+	if escrowResult.ModifiedCount == 0 {
+		releaseOrder()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "用户权证数量不足"})
+	}
+
 	// 创建新的赎回订单
+	now := time.Now()
 	redemptionOrder := models.RedemptionOrder{
 		ID:             primitive.NewObjectID(),
 		UserRef:        userID,
 		OrderRef:       orderID,
-		CreatedAt:      time.Now(),
-		Status:         "待处理",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Status:         models.RedemptionPending,
+		PowAmount:      powAmount,
 		IsSubmitted:    true,
 		AlipayUsername: input.AlipayUsername,
 		AlipayAccount:  input.AlipayAccount,
@@ -82,56 +306,202 @@ func (roc *RedemptionOrderController) CreateRedemptionOrder(c *fiber.Ctx) error
 		Hash:           input.Hash,
 	}
 
-	// 验证支付宝信息或钱包信息
-	if (input.AlipayUsername == "" || input.AlipayAccount == "") && (input.WalletAddress == "" || input.Hash == "") {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "必须提供支付宝信息或钱包信息"})
+	// 插入数据库
+	result, err := roc.redemptionOrderCollection.InsertOne(c.Context(), redemptionOrder)
+	if err != nil {
+		// 回滚冻结的 Pow 和订单标记
+		if _, err := roc.userCollection.UpdateOne(c.Context(), bson.M{"_id": userID},
+			bson.M{"$inc": bson.M{"pow": powAmount, "pow_escrowed": -powAmount}}); err != nil {
+			log.Printf("回滚冻结Pow失败: %v", err)
+		}
+		releaseOrder()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "创建赎回订单失败"})
 	}
-	// 检查权证数量
-	var order models.Orders
-	err = roc.orderCollection.FindOne(c.Context(), bson.M{"_id": orderID}).Decode(&order)
+
+	// 钱包赎回在后台异步核验链上交易
+	if isWalletRedemption(redemptionOrder) && roc.verifier != nil {
+		go func() {
+			if _, err := roc.verifyRedemption(roc.ctx, redemptionOrder); err != nil {
+				log.Printf("链上核验赎回订单失败 (RedemptionID: %s): %v", redemptionOrder.ID.Hex(), err)
+			}
+		}()
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "赎回订单创建成功",
+		"id":         result.InsertedID,
+		"pow_amount": powAmount,
+	})
+}
+
+// verifyRedemption 核验赎回订单的交易哈希，并把结果保存到赎回订单上
+func (roc *RedemptionOrderController) verifyRedemption(ctx context.Context, ro models.RedemptionOrder) (*models.ChainVerification, error) {
+	verification, err := roc.verifier.Verify(ctx, ro.Hash, ro.WalletAddress, ro.PowAmount)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的订单"})
+		return nil, err
+	}
+
+	// 同一签名已在其他赎回订单上核验通过，视为重复使用
+	if verification.Verified {
+		reused, err := roc.redemptionOrderCollection.CountDocuments(ctx, bson.M{
+			"_id":                    bson.M{"$ne": ro.ID},
+			"verification.signature": verification.Signature,
+			"verification.verified":  true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if reused > 0 {
+			verification.Verified = false
+			verification.Error = "该交易签名已被其他赎回订单使用"
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
 	}
 
-	var user models.User
-	err = roc.userCollection.FindOne(c.Context(), bson.M{"_id": userID}).Decode(&user)
+	_, err = roc.redemptionOrderCollection.UpdateOne(ctx, bson.M{"_id": ro.ID},
+		bson.M{"$set": bson.M{"verification": verification, "updated_at": time.Now()}})
+	if mongo.IsDuplicateKeyError(err) {
+		// 并发核验时由唯一索引拦截重复使用的签名
+		verification.Verified = false
+		verification.Error = "该交易签名已被其他赎回订单使用"
+		_, err = roc.redemptionOrderCollection.UpdateOne(ctx, bson.M{"_id": ro.ID},
+			bson.M{"$set": bson.M{"verification": verification, "updated_at": time.Now()}})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
+
+// 后端管理员手动重新核验赎回订单的链上交易
+func (roc *RedemptionOrderController) VerifyRedemptionOrder(c *fiber.Ctx) error {
+	if roc.verifier == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "链上核验服务未配置"})
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(c.Params("dempOrderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
+	}
+
+	var redemptionOrder models.RedemptionOrder
+	err = roc.redemptionOrderCollection.FindOne(c.Context(), bson.M{"_id": objectID}).Decode(&redemptionOrder)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的用户"})
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的赎回订单"})
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询用户失败"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询赎回订单失败"})
+	}
+	if !isWalletRedemption(redemptionOrder) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "该赎回订单未提供钱包地址和交易哈希"})
 	}
 
-	if order.TotalPrice > uint64(user.Pow) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "用户权证数量不足"})
+	verification, err := roc.verifyRedemption(c.Context(), redemptionOrder)
+	if err != nil {
+		log.Printf("链上核验赎回订单失败 (RedemptionID: %s): %v", objectID.Hex(), err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "链上核验失败，请稍后重试"})
 	}
 
-	// 插入数据库
-	result, err := roc.redemptionOrderCollection.InsertOne(c.Context(), redemptionOrder)
+	return c.JSON(fiber.Map{
+		"message":      "链上核验完成",
+		"verification": verification,
+	})
+}
+
+// 个人用户获取自己的赎回订单
+func (roc *RedemptionOrderController) GetMyRedemptionOrders(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "无法获取用户信息"})
+	}
+
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "用户ID不存在"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "创建赎回订单失败"})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的用户ID"})
 	}
-	// 更新原始订单的 IsRedeemed 字段为 true
-	_, err = roc.orderCollection.UpdateOne(
-		c.Context(),
-		bson.M{"_id": orderID},
-		bson.M{"$set": bson.M{"is_redeemed": true}},
-	)
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	skip := (page - 1) * limit
+
+	filter := bson.M{"user_ref": userID}
+	opts := options.Find().
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit)).
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := roc.redemptionOrderCollection.Find(c.Context(), filter, opts)
 	if err != nil {
-		// 如果更新失败，可以选择回滚赎回订单的创建
-		// 这里我们只记录错误，但仍然返回成功创建赎回订单的消息
-		log.Printf("更新原始订单失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取赎回订单失败"})
 	}
+	defer cursor.Close(c.Context())
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"message": "赎回订单创建成功",
-		"id":      result.InsertedID,
+	redemptionOrders := []models.RedemptionOrder{}
+	if err := cursor.All(c.Context(), &redemptionOrders); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解码赎回订单失败"})
+	}
+	for i := range redemptionOrders {
+		redemptionOrders[i].Status = redemptionStatus(redemptionOrders[i].Status)
+	}
+
+	total, err := roc.redemptionOrderCollection.CountDocuments(c.Context(), filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取总订单数失败"})
+	}
+
+	return c.JSON(fiber.Map{
+		"orders": redemptionOrders,
+		"page":   page,
+		"limit":  limit,
+		"total":  total,
 	})
 }
 
+// 个人用户获取单个赎回订单
+func (roc *RedemptionOrderController) GetMyRedemptionOrder(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "无法获取用户信息"})
+	}
+
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "用户ID不存在"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的用户ID"})
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(c.Params("dempOrderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
+	}
+
+	var redemptionOrder models.RedemptionOrder
+	err = roc.redemptionOrderCollection.FindOne(c.Context(), bson.M{"_id": objectID, "user_ref": userID}).Decode(&redemptionOrder)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的赎回订单"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取赎回订单失败"})
+	}
+	redemptionOrder.Status = redemptionStatus(redemptionOrder.Status)
+
+	return c.JSON(redemptionOrder)
+}
+
 // 后端管理员获取所有赎回订单
 func (roc *RedemptionOrderController) GetRedemptionOrder(c *fiber.Ctx) error {
 	// 获取分页参数
@@ -149,6 +519,12 @@ func (roc *RedemptionOrderController) GetRedemptionOrder(c *fiber.Ctx) error {
 	// 计算跳过的文档数
 	skip := (page - 1) * limit
 
+	// 按状态筛选
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = redemptionStatusFilter(status)
+	}
+
 	// 创建一个空的赎回订单切片
 	var redemptionOrders []models.RedemptionOrder
 
@@ -159,7 +535,7 @@ func (roc *RedemptionOrderController) GetRedemptionOrder(c *fiber.Ctx) error {
 		SetSort(bson.D{{Key: "created_at", Value: -1}}) // 按创建时间降序排序
 
 	// 执行查询
-	cursor, err := roc.redemptionOrderCollection.Find(c.Context(), bson.M{}, opts)
+	cursor, err := roc.redemptionOrderCollection.Find(c.Context(), filter, opts)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "获取赎回订单失败",
@@ -175,7 +551,7 @@ func (roc *RedemptionOrderController) GetRedemptionOrder(c *fiber.Ctx) error {
 	}
 
 	// 获取总文档数
-	total, err := roc.redemptionOrderCollection.CountDocuments(c.Context(), bson.M{})
+	total, err := roc.redemptionOrderCollection.CountDocuments(c.Context(), filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "获取总订单数失败",
@@ -197,6 +573,7 @@ func (roc *RedemptionOrderController) UpdateRedemptionOrderStatus(c *fiber.Ctx)
 
 	var input struct {
 		Status string `json:"status"`
+		Reason string `json:"reason"`
 	}
 
 	// 解析请求体
@@ -205,8 +582,10 @@ func (roc *RedemptionOrderController) UpdateRedemptionOrderStatus(c *fiber.Ctx)
 	}
 
 	// 验证状态
-	if input.Status == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "状态不能为空"})
+	switch input.Status {
+	case models.RedemptionApproved, models.RedemptionPaidOut, models.RedemptionRejected:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的赎回订单状态"})
 	}
 
 	// 将字符串ID转换为ObjectID
@@ -215,20 +594,21 @@ func (roc *RedemptionOrderController) UpdateRedemptionOrderStatus(c *fiber.Ctx)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
 	}
 
-	// 更新订单状态
-	update := bson.M{"$set": bson.M{"status": input.Status}}
-	result, err := roc.redemptionOrderCollection.UpdateOne(
-		c.Context(),
-		bson.M{"_id": objectID},
-		update,
-	)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新订单状态失败"})
+	extra := bson.M{}
+	if input.Status == models.RedemptionRejected {
+		extra["reject_reason"] = input.Reason
 	}
 
-	if result.MatchedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的赎回订单"})
+	// 按状态机更新订单状态
+	_, err = roc.transitRedemption(c.Context(), objectID, input.Status, extra)
+	switch err {
+	case nil:
+	case errRedemptionNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errRedemptionTransition, errRedemptionConflict, errRedemptionUnverified, errRedemptionPayingOut, errRedemptionPayoutOnly:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新订单状态失败"})
 	}
 
 	return c.JSON(fiber.Map{
@@ -237,8 +617,8 @@ func (roc *RedemptionOrderController) UpdateRedemptionOrderStatus(c *fiber.Ctx)
 	})
 }
 
-
 // 根据赎回订单ID删除赎回订单
+// 只允许删除待审核或已拒绝的赎回订单，待审核的订单会先退回冻结的 Pow
 func (roc *RedemptionOrderController) DeleteRedemptionOrder(c *fiber.Ctx) error {
 	orderID := c.Params("dempOrderID")
 
@@ -248,6 +628,26 @@ func (roc *RedemptionOrderController) DeleteRedemptionOrder(c *fiber.Ctx) error
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
 	}
 
+	var redemptionOrder models.RedemptionOrder
+	err = roc.redemptionOrderCollection.FindOne(c.Context(), bson.M{"_id": objectID}).Decode(&redemptionOrder)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的赎回订单"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询赎回订单失败"})
+	}
+
+	switch redemptionStatus(redemptionOrder.Status) {
+	case models.RedemptionPending:
+		// 先走拒绝流程退回 Pow 并释放原始订单
+		if _, err := roc.transitRedemption(c.Context(), objectID, models.RedemptionRejected, bson.M{"reject_reason": "管理员删除"}); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+	case models.RedemptionRejected:
+	default:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "已审核或已打款的赎回订单不能删除"})
+	}
+
 	// 删除赎回订单
 	result, err := roc.redemptionOrderCollection.DeleteOne(c.Context(), bson.M{"_id": objectID})
 	if err != nil {
@@ -258,17 +658,7 @@ func (roc *RedemptionOrderController) DeleteRedemptionOrder(c *fiber.Ctx) error
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的赎回订单"})
 	}
 
-	// 更新原始订单的 IsRedeemed 字段为 false
-	_, err = roc.orderCollection.UpdateOne(
-		c.Context(),
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{"is_redeemed": false}},
-	)
-	if err != nil {
-		// 如果更新失败，记录错误但不影响删除操作的结果
-		log.Printf("更新原始订单失败: %v", err)
-	}
-
+	// 原始订单的 is_redeemed 已在拒绝时释放，这里不再修改：该订单可能已有新的赎回申请
 	return c.JSON(fiber.Map{
 		"message": "赎回订单删除成功",
 		"id":      orderID,
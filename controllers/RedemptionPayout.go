@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"blog-auth-server/money"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/smartwalle/alipay/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 支付宝转账单据状态
+const (
+	payoutSuccess = "SUCCESS"
+	payoutFail    = "FAIL"
+	payoutDealing = "DEALING"
+)
+
+// 沙箱模式下不调用支付宝接口，直接视为打款成功，便于本地联调
+func payoutSandbox() bool {
+	return os.Getenv("ALIPAY_PAYOUT_SANDBOX") == "true"
+}
+
+// 服务不可用、调用限流和系统错误可以安全重试，转账单号保证了幂等
+func isTransientAlipayError(e alipay.Error) bool {
+	return e.Code == alipay.CodeUnknowError || e.Code == alipay.CodeCallLimited || e.SubCode == "SYSTEM_ERROR"
+}
+
+// 支付宝转账到用户账户，使用赎回订单ID作为商户转账单号
+func (roc *RedemptionOrderController) transferToAlipay(ctx context.Context, ro models.RedemptionOrder) *models.RedemptionPayout {
+	payout := &models.RedemptionPayout{
+		OutBizNo: ro.ID.Hex(),
+		Amount:   money.FromYuan(ro.PowAmount).String(), // 1 Pow 兑换 1 元
+	}
+	if ro.Payout != nil {
+		payout.Attempts = ro.Payout.Attempts
+	}
+
+	if payoutSandbox() {
+		payout.Attempts++
+		payout.Status = payoutSuccess
+		payout.OrderID = "SANDBOX-" + ro.ID.Hex()
+		payout.TransDate = time.Now().Format("2006-01-02 15:04:05")
+		payout.Sandbox = true
+		payout.UpdatedAt = time.Now()
+		return payout
+	}
+
+	param := alipay.FundTransUniTransfer{
+		OutBizNo:    payout.OutBizNo,
+		TransAmount: payout.Amount,
+		ProductCode: "TRANS_ACCOUNT_NO_PWD",
+		BizScene:    "DIRECT_TRANSFER",
+		OrderTitle:  "权证赎回",
+		PayeeInfo: &alipay.PayeeInfo{
+			Identity:     ro.AlipayAccount,
+			IdentityType: "ALIPAY_LOGON_ID",
+			Name:         ro.AlipayUsername,
+		},
+		Remark: fmt.Sprintf("赎回订单 %s", ro.ID.Hex()),
+	}
+
+	// 只调用一次，网络错误和支付宝临时错误记为处理中，由后台任务使用相同单号重试
+	payout.Attempts++
+	rsp, err := roc.alipayClient.FundTransUniTransfer(ctx, param)
+	switch {
+	case err != nil:
+		payout.Status = payoutDealing
+		payout.Error = err.Error()
+	case rsp.IsFailure():
+		payout.Error = rsp.Error.Error()
+		payout.Status = payoutFail
+		if isTransientAlipayError(rsp.Error) {
+			payout.Status = payoutDealing
+		}
+	default:
+		payout.Status = rsp.Status
+		payout.OrderID = rsp.OrderId
+		payout.PayFundOrderID = rsp.PayFundOrderId
+		payout.TransDate = rsp.TransDate
+		payout.Error = ""
+	}
+	payout.UpdatedAt = time.Now()
+	return payout
+}
+
+// 发起一次打款并保存结果，打款成功时将订单流转为已打款
+// 之前已打款成功但状态未更新时，不再调用支付宝，直接完成状态流转
+func (roc *RedemptionOrderController) payRedemption(ctx context.Context, ro models.RedemptionOrder) (*models.RedemptionPayout, error) {
+	payout := ro.Payout
+	if payout == nil || payout.Status != payoutSuccess {
+		// 调用支付宝前先标记为处理中，此后拒绝订单会被拦截；进程中断时由后台任务按相同单号重试
+		now := time.Now()
+		result, err := roc.redemptionOrderCollection.UpdateOne(ctx,
+			bson.M{"_id": ro.ID, "status": models.RedemptionApproved},
+			bson.M{"$set": bson.M{
+				"payout.out_biz_no": ro.ID.Hex(),
+				"payout.status":     payoutDealing,
+				"payout.updated_at": now,
+				"updated_at":        now,
+			}})
+		if err != nil {
+			return nil, fmt.Errorf("标记打款处理中失败: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return nil, errRedemptionConflict
+		}
+
+		payout = roc.transferToAlipay(ctx, ro)
+		_, err = roc.redemptionOrderCollection.UpdateOne(ctx, bson.M{"_id": ro.ID},
+			bson.M{"$set": bson.M{"payout": payout, "updated_at": time.Now()}})
+		if err != nil {
+			// 数据库中仍为处理中，后台任务会按相同单号重新获取结果
+			return payout, fmt.Errorf("保存打款结果失败: %v", err)
+		}
+	}
+	if payout.Status == payoutSuccess {
+		if _, err := roc.transitRedemption(ctx, ro.ID, models.RedemptionPaidOut, nil); err != nil {
+			return payout, err
+		}
+	}
+	return payout, nil
+}
+
+// 处理中的打款重试参数：第 n 次调用后等待 2^n 分钟，最长 1 小时，超过次数后保留处理中状态，由管理员人工处理
+const (
+	payoutRetryInterval = time.Minute
+	payoutMaxAttempts   = 10
+)
+
+func payoutRetryDelay(attempts int) time.Duration {
+	if attempts > 6 {
+		return time.Hour
+	}
+	return time.Duration(1<<attempts) * time.Minute
+}
+
+// 后台重试处理中的打款
+func (roc *RedemptionOrderController) startPayoutRetry() {
+	ticker := time.NewTicker(payoutRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			roc.retryDealingPayouts()
+		case <-roc.ctx.Done():
+			return
+		}
+	}
+}
+
+// 使用原商户转账单号重新发起处理中的打款，支付宝按单号幂等，已到账的转账只会返回原结果
+// 已打款成功但订单状态未更新的，直接完成状态流转
+func (roc *RedemptionOrderController) retryDealingPayouts() {
+	filter := bson.M{
+		"status": models.RedemptionApproved,
+		"$or": bson.A{
+			bson.M{"payout.status": payoutDealing, "payout.attempts": bson.M{"$lt": payoutMaxAttempts}},
+			bson.M{"payout.status": payoutSuccess},
+		},
+	}
+	cursor, err := roc.redemptionOrderCollection.Find(roc.ctx, filter)
+	if err != nil {
+		log.Printf("查询处理中的打款失败: %v", err)
+		return
+	}
+	var orders []models.RedemptionOrder
+	if err := cursor.All(roc.ctx, &orders); err != nil {
+		log.Printf("解析处理中的打款失败: %v", err)
+		return
+	}
+
+	for _, ro := range orders {
+		if ro.Payout.Status == payoutDealing && time.Since(ro.Payout.UpdatedAt) < payoutRetryDelay(ro.Payout.Attempts) {
+			continue
+		}
+		payout, err := roc.payRedemption(roc.ctx, ro)
+		if err != nil {
+			log.Printf("重试打款失败 (RedemptionID: %s): %v", ro.ID.Hex(), err)
+			continue
+		}
+		log.Printf("重试打款 (RedemptionID: %s): 第 %d 次，状态 %s %s", ro.ID.Hex(), payout.Attempts, payout.Status, payout.Error)
+	}
+}
+
+// 后端管理员审核并通过支付宝打款
+func (roc *RedemptionOrderController) ApproveAndPayRedemption(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("dempOrderID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的订单ID"})
+	}
+
+	var ro models.RedemptionOrder
+	err = roc.redemptionOrderCollection.FindOne(c.Context(), bson.M{"_id": objectID}).Decode(&ro)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的赎回订单"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询赎回订单失败"})
+	}
+	if ro.AlipayAccount == "" || ro.AlipayUsername == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "该赎回订单未选择支付宝收款"})
+	}
+
+	// 待审核的订单先审核通过
+	switch redemptionStatus(ro.Status) {
+	case models.RedemptionPending:
+		ro, err = roc.transitRedemption(c.Context(), objectID, models.RedemptionApproved, nil)
+		switch err {
+		case nil:
+		case errRedemptionNotFound:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case errRedemptionTransition, errRedemptionConflict, errRedemptionUnverified:
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新订单状态失败"})
+		}
+	case models.RedemptionApproved:
+	default:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": errRedemptionTransition.Error()})
+	}
+
+	payout, err := roc.payRedemption(c.Context(), ro)
+	if err == errRedemptionConflict {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		log.Printf("赎回订单打款失败 (RedemptionID: %s): %v", objectID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "保存打款结果或更新订单状态失败，系统会按相同单号自动重试", "payout": payout})
+	}
+
+	switch payout.Status {
+	case payoutSuccess:
+		return c.JSON(fiber.Map{
+			"message": "赎回订单已打款",
+			"status":  models.RedemptionPaidOut,
+			"payout":  payout,
+		})
+	case payoutFail:
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "支付宝打款失败: " + payout.Error, "payout": payout})
+	default:
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "支付宝打款处理中，系统会自动使用相同单号重试，请稍后查询", "payout": payout})
+	}
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// RedemptionVerifier 通过 Solana RPC 核验用户提交的赎回交易
+type RedemptionVerifier struct {
+	endpoint string // 为空时轮询 rpcEndpoints，测试时可指向本地桩服务
+	treasury solana.PublicKey
+	mint     solana.PublicKey
+}
+
+// NewRedemptionVerifier 构造函数
+// treasuryAddress 为空时读取环境变量 SCL_TREASURY_ADDRESS，仍为空则使用热钱包地址
+func NewRedemptionVerifier(endpoint, treasuryAddress string) (*RedemptionVerifier, error) {
+	if treasuryAddress == "" {
+		treasuryAddress = os.Getenv("SCL_TREASURY_ADDRESS")
+	}
+	var treasury solana.PublicKey
+	if treasuryAddress != "" {
+		key, err := solana.PublicKeyFromBase58(treasuryAddress)
+		if err != nil {
+			return nil, fmt.Errorf("无效的金库地址: %v", err)
+		}
+		treasury = key
+	} else {
+		account, err := solana.PrivateKeyFromBase58(fromPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("未配置金库地址且无法解析热钱包私钥: %v", err)
+		}
+		treasury = account.PublicKey()
+	}
+
+	mint, err := solana.PublicKeyFromBase58(sclTokenMint)
+	if err != nil {
+		return nil, fmt.Errorf("无效的SCL代币地址: %v", err)
+	}
+
+	return &RedemptionVerifier{
+		endpoint: endpoint,
+		treasury: treasury,
+		mint:     mint,
+	}, nil
+}
+
+func (v *RedemptionVerifier) client() *rpc.Client {
+	if v.endpoint != "" {
+		return rpc.New(v.endpoint)
+	}
+	return rpc.New(getNextRPCEndpoint())
+}
+
+// 计算某个所有者在交易前后持有的 SCL 原始数量变化
+func (v *RedemptionVerifier) tokenDelta(meta *rpc.TransactionMeta, owner solana.PublicKey) (*big.Int, uint8) {
+	sum := func(balances []rpc.TokenBalance) (*big.Int, uint8) {
+		total := new(big.Int)
+		var decimals uint8
+		for _, b := range balances {
+			if b.Owner == nil || !b.Owner.Equals(owner) || !b.Mint.Equals(v.mint) || b.UiTokenAmount == nil {
+				continue
+			}
+			amount, ok := new(big.Int).SetString(b.UiTokenAmount.Amount, 10)
+			if !ok {
+				continue
+			}
+			total.Add(total, amount)
+			decimals = b.UiTokenAmount.Decimals
+		}
+		return total, decimals
+	}
+	pre, preDecimals := sum(meta.PreTokenBalances)
+	post, postDecimals := sum(meta.PostTokenBalances)
+	if postDecimals == 0 {
+		postDecimals = preDecimals
+	}
+	return new(big.Int).Sub(post, pre), postDecimals
+}
+
+// Verify 核验交易是否从 wallet 向金库转入了 expected 数量的 SCL
+// 返回的 error 仅表示 RPC 等基础设施错误，业务上的核验失败记录在结果的 Error 字段中。
+func (v *RedemptionVerifier) Verify(ctx context.Context, signature, wallet string, expected float64) (*models.ChainVerification, error) {
+	result := &models.ChainVerification{
+		Signature:  signature,
+		FromWallet: wallet,
+		ToWallet:   v.treasury.String(),
+		Expected:   expected,
+		CheckedAt:  time.Now(),
+	}
+
+	sig, err := solana.SignatureFromBase58(signature)
+	if err != nil {
+		result.Error = "无效的交易签名"
+		return result, nil
+	}
+	from, err := solana.PublicKeyFromBase58(wallet)
+	if err != nil {
+		result.Error = "无效的钱包地址"
+		return result, nil
+	}
+
+	if err := waitForRateLimit(ctx); err != nil {
+		return result, fmt.Errorf("等待限流失败: %v", err)
+	}
+	maxVersion := uint64(0)
+	out, err := v.client().GetTransaction(ctx, sig, &rpc.GetTransactionOpts{
+		Commitment:                     rpc.CommitmentFinalized,
+		MaxSupportedTransactionVersion: &maxVersion,
+	})
+	if err != nil {
+		if err == rpc.ErrNotFound {
+			result.Error = "链上未找到该交易或尚未最终确认"
+			return result, nil
+		}
+		return result, fmt.Errorf("查询链上交易失败: %v", err)
+	}
+	result.Slot = out.Slot
+
+	if out.Meta == nil {
+		result.Error = "交易缺少执行结果"
+		return result, nil
+	}
+	if out.Meta.Err != nil {
+		result.Error = fmt.Sprintf("交易执行失败: %v", out.Meta.Err)
+		return result, nil
+	}
+
+	// 钱包必须是交易签名者，防止冒用他人的转账；无法解析交易时同样视为核验失败
+	if out.Transaction == nil {
+		result.Error = "交易缺少签名信息"
+		return result, nil
+	}
+	tx, err := out.Transaction.GetTransaction()
+	if err != nil || tx == nil {
+		result.Error = "无法解析交易的签名者"
+		return result, nil
+	}
+	if !tx.Message.IsSigner(from) {
+		result.Error = "钱包地址不是该交易的签名者"
+		return result, nil
+	}
+
+	received, decimals := v.tokenDelta(out.Meta, v.treasury)
+	sent, _ := v.tokenDelta(out.Meta, from)
+	scale := math.Pow10(int(decimals))
+	result.Amount, _ = new(big.Float).Quo(new(big.Float).SetInt(received), big.NewFloat(scale)).Float64()
+
+	expectedRaw := big.NewInt(int64(math.Round(expected * scale)))
+	if received.Sign() <= 0 {
+		result.Error = "交易未向金库转入SCL"
+		return result, nil
+	}
+	if new(big.Int).Neg(sent).Cmp(expectedRaw) < 0 {
+		result.Error = "钱包转出的SCL数量不足"
+		return result, nil
+	}
+	if received.Cmp(expectedRaw) < 0 {
+		result.Error = fmt.Sprintf("转入数量不足，期望 %.2f SCL，实际 %.2f SCL", expected, result.Amount)
+		return result, nil
+	}
+
+	result.Verified = true
+	return result, nil
+}
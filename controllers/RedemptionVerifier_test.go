@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// 链上交易桩：签名者、SCL 余额变化和执行结果
+type stubTx struct {
+	signer     solana.PublicKey
+	treasury   solana.PublicKey // 接收 SCL 的账户
+	amount     int64            // 原始数量，decimals 为 6
+	failed     bool
+	noEnvelope bool // 不返回交易内容
+}
+
+func (tx stubTx) result(t *testing.T) map[string]interface{} {
+	balance := func(index int, owner solana.PublicKey, amount int64) map[string]interface{} {
+		return map[string]interface{}{
+			"accountIndex":  index,
+			"mint":          sclTokenMint,
+			"owner":         owner.String(),
+			"uiTokenAmount": map[string]interface{}{"amount": strconv.FormatInt(amount, 10), "decimals": 6},
+		}
+	}
+	meta := map[string]interface{}{
+		"err":               nil,
+		"fee":               5000,
+		"preBalances":       []int{},
+		"postBalances":      []int{},
+		"preTokenBalances":  []interface{}{balance(0, tx.signer, 10_000_000), balance(1, tx.treasury, 0)},
+		"postTokenBalances": []interface{}{balance(0, tx.signer, 10_000_000-tx.amount), balance(1, tx.treasury, tx.amount)},
+	}
+	if tx.failed {
+		meta["err"] = map[string]interface{}{"InstructionError": []interface{}{0, "Custom"}}
+	}
+	result := map[string]interface{}{"slot": 42, "meta": meta}
+	if !tx.noEnvelope {
+		message := solana.Message{
+			AccountKeys: solana.PublicKeySlice{tx.signer, tx.treasury},
+			Header:      solana.MessageHeader{NumRequiredSignatures: 1},
+		}
+		raw, err := (&solana.Transaction{Signatures: []solana.Signature{{}}, Message: message}).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result["transaction"] = []string{base64.StdEncoding.EncodeToString(raw), "base64"}
+	}
+	return result
+}
+
+// 启动 JSON-RPC 桩服务，所有 getTransaction 请求返回同一笔交易
+func stubRPC(t *testing.T, tx *stubTx) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if req.Method != "getTransaction" || tx == nil {
+			resp["result"] = nil
+		} else {
+			resp["result"] = tx.result(t)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestRedemptionVerifierVerify(t *testing.T) {
+	wallet := solana.NewWallet().PublicKey()
+	other := solana.NewWallet().PublicKey()
+	treasury := solana.NewWallet().PublicKey()
+	signature := solana.Signature{1}.String()
+
+	cases := []struct {
+		name    string
+		tx      *stubTx
+		want    bool
+		errPart string
+	}{
+		{"valid transfer", &stubTx{signer: wallet, treasury: treasury, amount: 5_000_000}, true, ""},
+		{"overpaid", &stubTx{signer: wallet, treasury: treasury, amount: 6_000_000}, true, ""},
+		{"wrong signer", &stubTx{signer: other, treasury: treasury, amount: 5_000_000}, false, "签名者"},
+		{"wrong destination", &stubTx{signer: wallet, treasury: other, amount: 5_000_000}, false, "未向金库转入"},
+		{"short amount", &stubTx{signer: wallet, treasury: treasury, amount: 4_990_000}, false, "不足"},
+		{"failed tx", &stubTx{signer: wallet, treasury: treasury, amount: 5_000_000, failed: true}, false, "执行失败"},
+		{"missing transaction", &stubTx{signer: wallet, treasury: treasury, amount: 5_000_000, noEnvelope: true}, false, "签名"},
+		{"not found", nil, false, "未找到"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewRedemptionVerifier(stubRPC(t, tc.tx), treasury.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := v.Verify(context.Background(), signature, wallet.String(), 5)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if result.Verified != tc.want {
+				t.Fatalf("Verified = %v (%s), want %v", result.Verified, result.Error, tc.want)
+			}
+			if tc.errPart != "" && !strings.Contains(result.Error, tc.errPart) {
+				t.Errorf("Error = %q, want it to contain %q", result.Error, tc.errPart)
+			}
+		})
+	}
+}
+
+func TestRedemptionVerifierRejectsBadInput(t *testing.T) {
+	v, err := NewRedemptionVerifier("http://127.0.0.1:0", solana.NewWallet().PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := v.Verify(context.Background(), "not-a-signature", solana.NewWallet().PublicKey().String(), 1); result.Verified || result.Error == "" {
+		t.Errorf("invalid signature accepted: %+v", result)
+	}
+	if result, _ := v.Verify(context.Background(), solana.Signature{1}.String(), "not-a-wallet", 1); result.Verified || result.Error == "" {
+		t.Errorf("invalid wallet accepted: %+v", result)
+	}
+}
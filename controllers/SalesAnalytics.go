@@ -0,0 +1,420 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 未指定时区时按北京时间统计
+const defaultAnalyticsTimezone = "Asia/Shanghai"
+
+// 统计粒度对应的 $dateToString 格式，周使用 ISO 周
+var salesGranularityFormats = map[string]string{
+	"day":   "%Y-%m-%d",
+	"week":  "%G-W%V",
+	"month": "%Y-%m",
+}
+
+// salesRange 统计区间 [Start, End)
+type salesRange struct {
+	Start    time.Time
+	End      time.Time
+	Timezone string
+}
+
+// 上一个等长区间，用于环比
+func (r salesRange) previous() salesRange {
+	return salesRange{Start: r.Start.Add(-r.End.Sub(r.Start)), End: r.Start, Timezone: r.Timezone}
+}
+
+// SalesSummary 区间内销售汇总
+type SalesSummary struct {
+	Revenue       float64 `json:"revenue"`        // 已支付订单金额（元）
+	OrderCount    int64   `json:"order_count"`    // 已支付订单数
+	AOV           float64 `json:"aov"`            // 客单价
+	ExpiredCount  int64   `json:"expired_count"`  // 超时未支付被清理的订单数
+	ExpiredAmount float64 `json:"expired_amount"` // 超时未支付被清理的订单金额
+	Conversion    float64 `json:"conversion"`     // 支付转化率 = 已支付 / (已支付 + 超时)
+	Customers     int64   `json:"customers"`      // 下单用户数
+	RepeatRate    float64 `json:"repeat_rate"`    // 复购率：截至区间结束累计支付2单及以上的用户占比
+	PowIssued     float64 `json:"pow_issued"`     // 发放的权证
+	ShippingFee   float64 `json:"shipping_fee"`   // 已支付订单中的运费（元），已计入 Revenue
+	PowWithdrawn  float64 `json:"pow_withdrawn"`  // 链上提现的权证
+}
+
+// 解析 start、end（YYYY-MM-DD，end 含当天）和 tz 参数，默认最近30天
+func parseSalesRange(c *fiber.Ctx) (salesRange, error) {
+	tz := c.Query("tz", defaultAnalyticsTimezone)
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return salesRange{}, fmt.Errorf("无效的时区: %s", tz)
+	}
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	r := salesRange{Start: today.AddDate(0, 0, -29), End: today.AddDate(0, 0, 1), Timezone: tz}
+
+	if s := c.Query("start"); s != "" {
+		r.Start, err = time.ParseInLocation("2006-01-02", s, loc)
+		if err != nil {
+			return salesRange{}, fmt.Errorf("无效的开始日期: %s", s)
+		}
+	}
+	if e := c.Query("end"); e != "" {
+		end, err := time.ParseInLocation("2006-01-02", e, loc)
+		if err != nil {
+			return salesRange{}, fmt.Errorf("无效的结束日期: %s", e)
+		}
+		r.End = end.AddDate(0, 0, 1)
+	}
+	if !r.Start.Before(r.End) {
+		return salesRange{}, fmt.Errorf("开始日期不能晚于结束日期")
+	}
+	if r.End.Sub(r.Start) > 366*24*time.Hour {
+		return salesRange{}, fmt.Errorf("统计区间不能超过一年")
+	}
+	return r, nil
+}
+
+// 区间内已支付订单的匹配条件
+func paidOrdersMatch(r salesRange) bson.M {
+	return bson.M{
+		"payment_status": "已支付",
+		"payment_time":   bson.M{"$gte": r.Start, "$lt": r.End},
+	}
+}
+
+func safeRatio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// 执行聚合并返回第一条结果，没有结果时 out 保持零值
+func aggregateOne(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, out interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		return cursor.Decode(out)
+	}
+	return cursor.Err()
+}
+
+// 聚合结果中以分保存的金额转换为元
+func fenToYuan(field string) bson.M {
+	return bson.M{"$divide": bson.A{field, 100}}
+}
+
+// 汇总区间内的销售指标
+func (oc *OrderController) salesSummary(ctx context.Context, r salesRange) (SalesSummary, error) {
+	var summary SalesSummary
+
+	// 收入、订单数、发放权证
+	var paid struct {
+		Revenue    float64 `bson:"revenue"`
+		OrderCount int64   `bson:"order_count"`
+		PowIssued  float64 `bson:"pow_issued"`
+		Shipping   float64 `bson:"shipping_fee"`
+	}
+	err := aggregateOne(ctx, oc.orderCollection, mongo.Pipeline{
+		{{Key: "$match", Value: paidOrdersMatch(r)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          nil,
+			"revenue":      bson.M{"$sum": "$total_price"},
+			"order_count":  bson.M{"$sum": 1},
+			"pow_issued":   bson.M{"$sum": bson.M{"$ifNull": bson.A{"$pow_award.amount", 0}}},
+			"shipping_fee": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$shipping_fee", 0}}},
+		}}},
+	}, &paid)
+	if err != nil {
+		return summary, fmt.Errorf("统计销售额失败: %v", err)
+	}
+	// 订单金额以分保存，统计结果以元返回
+	summary.Revenue = paid.Revenue / 100
+	summary.OrderCount = paid.OrderCount
+	summary.AOV = safeRatio(summary.Revenue, float64(paid.OrderCount))
+	summary.PowIssued = paid.PowIssued
+	summary.ShippingFee = paid.Shipping / 100
+
+	// 超时未支付订单由定时清理记录在 order_cleanup_statistics
+	var expired struct {
+		Count  int64   `bson:"count"`
+		Amount float64 `bson:"amount"`
+	}
+	err = aggregateOne(ctx, oc.statisticsCollection, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"cleanup_date": bson.M{"$gte": r.Start, "$lt": r.End}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    nil,
+			"count":  bson.M{"$sum": "$deleted_count"},
+			"amount": bson.M{"$sum": "$total_amount_saved"},
+		}}},
+	}, &expired)
+	if err != nil {
+		return summary, fmt.Errorf("统计超时订单失败: %v", err)
+	}
+	summary.ExpiredCount = expired.Count
+	summary.ExpiredAmount = expired.Amount
+	summary.Conversion = safeRatio(float64(summary.OrderCount), float64(summary.OrderCount+summary.ExpiredCount))
+
+	// 复购率：区间内下单的用户中，截至区间结束累计支付不少于2单的比例
+	var customers struct {
+		Customers int64 `bson:"customers"`
+		Repeat    int64 `bson:"repeat"`
+	}
+	err = aggregateOne(ctx, oc.orderCollection, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"payment_status": "已支付", "payment_time": bson.M{"$lt": r.End}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$user_ref",
+			"total": bson.M{"$sum": 1},
+			"in_range": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$payment_time", r.Start}}, 1, 0,
+			}}},
+		}}},
+		{{Key: "$match", Value: bson.M{"in_range": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"customers": bson.M{"$sum": 1},
+			"repeat":    bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$total", 2}}, 1, 0}}},
+		}}},
+	}, &customers)
+	if err != nil {
+		return summary, fmt.Errorf("统计复购率失败: %v", err)
+	}
+	summary.Customers = customers.Customers
+	summary.RepeatRate = safeRatio(float64(customers.Repeat), float64(customers.Customers))
+
+	// 提现权证来自热钱包流出记录
+	if oc.treasuryController != nil {
+		var withdrawn struct {
+			Total float64 `bson:"total"`
+		}
+		err = aggregateOne(ctx, oc.treasuryController.outflowCollection, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"asset": models.AssetSCL, "created_at": bson.M{"$gte": r.Start, "$lt": r.End}}}},
+			{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+		}, &withdrawn)
+		if err != nil {
+			return summary, fmt.Errorf("统计权证提现失败: %v", err)
+		}
+		summary.PowWithdrawn = withdrawn.Total
+	}
+
+	return summary, nil
+}
+
+// 环比变化率，上期为 0 时返回 nil
+func growth(cur, prev float64) interface{} {
+	if prev == 0 {
+		return nil
+	}
+	return (cur - prev) / prev
+}
+
+func compareSummaries(cur, prev SalesSummary) fiber.Map {
+	return fiber.Map{
+		"revenue":      growth(cur.Revenue, prev.Revenue),
+		"order_count":  growth(float64(cur.OrderCount), float64(prev.OrderCount)),
+		"aov":          growth(cur.AOV, prev.AOV),
+		"conversion":   growth(cur.Conversion, prev.Conversion),
+		"repeat_rate":  growth(cur.RepeatRate, prev.RepeatRate),
+		"pow_issued":   growth(cur.PowIssued, prev.PowIssued),
+		"shipping_fee": growth(cur.ShippingFee, prev.ShippingFee),
+	}
+}
+
+// 按天/周/月统计收入、订单数和客单价
+func (oc *OrderController) salesSeries(ctx context.Context, r salesRange, granularity string) ([]bson.M, error) {
+	cursor, err := oc.orderCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: paidOrdersMatch(r)}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format":   salesGranularityFormats[granularity],
+				"date":     "$payment_time",
+				"timezone": r.Timezone,
+			}},
+			"revenue":      bson.M{"$sum": "$total_price"},
+			"order_count":  bson.M{"$sum": 1},
+			"customers":    bson.M{"$addToSet": "$user_ref"},
+			"shipping_fee": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$shipping_fee", 0}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"period":       "$_id",
+			"revenue":      fenToYuan("$revenue"),
+			"order_count":  1,
+			"customers":    bson.M{"$size": "$customers"},
+			"shipping_fee": fenToYuan("$shipping_fee"),
+			"aov":          bson.M{"$divide": bson.A{"$revenue", bson.M{"$multiply": bson.A{"$order_count", 100}}}},
+		}}},
+		{{Key: "$sort", Value: bson.M{"period": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	series := []bson.M{}
+	if err := cursor.All(ctx, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// 销量最高的商品
+func (oc *OrderController) topProducts(ctx context.Context, r salesRange, limit int) ([]bson.M, error) {
+	cursor, err := oc.orderCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: paidOrdersMatch(r)}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$items.product_ref",
+			"quantity": bson.M{"$sum": "$items.quantity"},
+			"revenue":  bson.M{"$sum": bson.M{"$multiply": bson.A{"$items.price", "$items.quantity"}}},
+			"orders":   bson.M{"$addToSet": "$_id"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "revenue", Value: -1}, {Key: "quantity", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         oc.productCollection.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"product_ref": "$_id",
+			"name":        bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$product.name", 0}}, ""}},
+			"quantity":    1,
+			"revenue":     fenToYuan("$revenue"),
+			"order_count": bson.M{"$size": "$orders"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	products := []bson.M{}
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// 销量最高的分类，商品属于多个分类时分别计入
+func (oc *OrderController) topCategories(ctx context.Context, r salesRange, limit int) ([]bson.M, error) {
+	cursor, err := oc.orderCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: paidOrdersMatch(r)}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         oc.productCollection.Name(),
+			"localField":   "items.product_ref",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$unwind", Value: "$product.categories"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$product.categories._id",
+			"name":     bson.M{"$first": "$product.categories.name"},
+			"quantity": bson.M{"$sum": "$items.quantity"},
+			"revenue":  bson.M{"$sum": bson.M{"$multiply": bson.A{"$items.price", "$items.quantity"}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "revenue", Value: -1}, {Key: "quantity", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"category_ref": "$_id",
+			"name":         1,
+			"quantity":     1,
+			"revenue":      fenToYuan("$revenue"),
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	categories := []bson.M{}
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// 展示后台销售数据GetSales：区间汇总及与上一等长区间的对比
+// 参数：start、end（YYYY-MM-DD），tz（IANA 时区，默认 Asia/Shanghai）
+func (oc *OrderController) GetSales(c *fiber.Ctx) error {
+	r, err := parseSalesRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	current, err := oc.salesSummary(c.Context(), r)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	prevRange := r.previous()
+	previous, err := oc.salesSummary(c.Context(), prevRange)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"range":    fiber.Map{"start": r.Start, "end": r.End, "timezone": r.Timezone},
+		"current":  current,
+		"previous": fiber.Map{"start": prevRange.Start, "end": prevRange.End, "summary": previous},
+		"change":   compareSummaries(current, previous),
+	})
+}
+
+// 展示后台销售数据分析GetSalesAnalytics：趋势、热销商品和分类
+// 参数：start、end、tz 同 GetSales，granularity 为 day/week/month，top 为排行数量
+func (oc *OrderController) GetSalesAnalytics(c *fiber.Ctx) error {
+	r, err := parseSalesRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	granularity := c.Query("granularity", "day")
+	if _, ok := salesGranularityFormats[granularity]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "granularity 仅支持 day、week、month"})
+	}
+	top := c.QueryInt("top", 10)
+	if top <= 0 || top > 100 {
+		top = 10
+	}
+
+	series, err := oc.salesSeries(c.Context(), r, granularity)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计销售趋势失败"})
+	}
+	prevRange := r.previous()
+	previousSeries, err := oc.salesSeries(c.Context(), prevRange, granularity)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计上期销售趋势失败"})
+	}
+	products, err := oc.topProducts(c.Context(), r, top)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计热销商品失败"})
+	}
+	categories, err := oc.topCategories(c.Context(), r, top)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计热销分类失败"})
+	}
+	summary, err := oc.salesSummary(c.Context(), r)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"range":           fiber.Map{"start": r.Start, "end": r.End, "timezone": r.Timezone},
+		"granularity":     granularity,
+		"summary":         summary,
+		"series":          series,
+		"previous_series": previousSeries,
+		"top_products":    products,
+		"top_categories":  categories,
+	})
+}
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 导入文件限制
+const (
+	shipmentImportMaxSize = 10 << 20 // 10MB
+	shipmentImportMaxRows = 5000
+)
+
+// 导入行的处理结果
+const (
+	importRowOK        = "ok"        // 已更新（试运行时表示校验通过）
+	importRowUnchanged = "unchanged" // 快递信息与现有一致，无需更新
+	importRowError     = "error"
+)
+
+// 导入需要的列，表头可以是导出的中文标题，也可以是列名
+var shipmentImportHeaders = map[string]string{
+	"订单ID": "order_id", "order_id": "order_id",
+	"商品ID": "product_id", "product_id": "product_id",
+	"商品名称": "product_name", "product_name": "product_name",
+	"商品尺寸": "size", "size": "size",
+	"商品颜色": "color", "color": "color",
+	"快递单号": "deliver_id", "deliver_id": "deliver_id",
+	"快递公司": "carrier", "carrier": "carrier",
+	"收件地址ID": "address_item_ref", "address_item_ref": "address_item_ref",
+}
+
+// ShipmentImportRow 每一行的导入结果
+type ShipmentImportRow struct {
+	Row        int    `json:"row"` // 表格中的行号，表头为第1行
+	OrderID    string `json:"order_id"`
+	ProductRef string `json:"product_ref,omitempty"`
+	Size       string `json:"size,omitempty"`
+	Color      string `json:"color,omitempty"`
+	AddressRef string `json:"address_item_ref,omitempty"`
+	DeliverID  string `json:"deliver_id"`
+	Carrier    string `json:"carrier"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 读取上传的 xlsx/csv 表格的所有行，包括表头
+func readSheetRecords(name string, data []byte) ([][]string, error) {
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		var err error
+		if records, err = reader.ReadAll(); err != nil {
+			return nil, fmt.Errorf("解析 CSV 失败: %v", err)
+		}
+	case ".xlsx":
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("解析 Excel 失败: %v", err)
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, fmt.Errorf("Excel 中没有工作表")
+		}
+		if records, err = f.GetRows(sheets[0]); err != nil {
+			return nil, fmt.Errorf("读取工作表失败: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("仅支持 xlsx、csv 文件")
+	}
+	return records, nil
+}
+
+// 读取上传的表格，返回表头之后的所有行（已按列名映射）
+func readShipmentSheet(name string, data []byte) ([]map[string]string, error) {
+	records, err := readSheetRecords(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("文件中没有数据行")
+	}
+	if len(records)-1 > shipmentImportMaxRows {
+		return nil, fmt.Errorf("单次最多导入 %d 行", shipmentImportMaxRows)
+	}
+
+	columns := make(map[int]string)
+	found := make(map[string]bool)
+	for i, title := range records[0] {
+		if key, ok := shipmentImportHeaders[strings.TrimSpace(title)]; ok {
+			columns[i] = key
+			found[key] = true
+		}
+	}
+	for _, required := range []string{"order_id", "deliver_id", "carrier"} {
+		if !found[required] {
+			return nil, fmt.Errorf("缺少必需的列: %s", required)
+		}
+	}
+	if !found["product_id"] && !found["product_name"] {
+		return nil, fmt.Errorf("缺少商品ID或商品名称列")
+	}
+
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(columns))
+		for i, value := range record {
+			if key, ok := columns[i]; ok {
+				row[key] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 在订单中定位导入行对应的商品，尺寸、颜色和收件地址为空时不参与匹配
+func matchImportItem(order *models.Orders, row map[string]string, productRef primitive.ObjectID, productNames map[primitive.ObjectID]string) (*models.OrderItem, error) {
+	var matched []*models.OrderItem
+	for i := range order.OrderItems {
+		item := &order.OrderItems[i]
+		if !productRef.IsZero() {
+			if item.ProductRef != productRef {
+				continue
+			}
+		} else if productNames[item.ProductRef] != row["product_name"] {
+			continue
+		}
+		if row["size"] != "" && item.Size != row["size"] {
+			continue
+		}
+		if row["color"] != "" && item.Color != row["color"] {
+			continue
+		}
+		if row["address_item_ref"] != "" && item.AddressItemRef.Hex() != row["address_item_ref"] {
+			continue
+		}
+		matched = append(matched, item)
+	}
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("订单中未找到该商品")
+	case 1:
+		return matched[0], nil
+	default:
+		return nil, fmt.Errorf("订单中有多个匹配商品，请填写商品尺寸、颜色或收件地址ID")
+	}
+}
+
+// 后台批量导入快递单号
+// 上传字段 file（xlsx/csv，与导出格式一致，需包含快递单号和快递公司列），dry_run=true 时只校验不更新
+func (oc *OrderController) ImportShipments(c *fiber.Ctx) error {
+	dryRun := c.Query("dry_run", c.FormValue("dry_run")) == "true"
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请上传文件"})
+	}
+	if fileHeader.Size > shipmentImportMaxSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "文件不能超过 10MB"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "读取文件失败"})
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "读取文件失败"})
+	}
+
+	rows, err := readShipmentSheet(fileHeader.Filename, data)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// 批量查询涉及的订单
+	var orderIDs []primitive.ObjectID
+	seenOrders := make(map[primitive.ObjectID]bool)
+	for _, row := range rows {
+		if id, err := primitive.ObjectIDFromHex(row["order_id"]); err == nil && !seenOrders[id] {
+			seenOrders[id] = true
+			orderIDs = append(orderIDs, id)
+		}
+	}
+	orders := make(map[primitive.ObjectID]*models.Orders, len(orderIDs))
+	productNames := make(map[primitive.ObjectID]string)
+	if len(orderIDs) > 0 {
+		cursor, err := oc.orderCollection.Find(c.Context(), bson.M{"_id": bson.M{"$in": orderIDs}})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询订单失败"})
+		}
+		var list []models.Orders
+		if err := cursor.All(c.Context(), &list); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析订单失败"})
+		}
+		products := make(map[primitive.ObjectID]*models.Product)
+		if err := oc.loadExportRefs(c.Context(), list, products); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		for id, product := range products {
+			if product != nil {
+				productNames[id] = product.Name
+			}
+		}
+		for i := range list {
+			orders[list[i].ID] = &list[i]
+		}
+	}
+
+	report := make([]ShipmentImportRow, 0, len(rows))
+	var writes []mongo.WriteModel
+	seenItems := make(map[string]int) // 同一商品在文件中重复出现时记录首次行号
+	now := time.Now()
+
+	for i, row := range rows {
+		result := ShipmentImportRow{
+			Row:       i + 2,
+			OrderID:   row["order_id"],
+			DeliverID: row["deliver_id"],
+			Carrier:   normalizeCarrier(row["carrier"]),
+			Status:    importRowError,
+		}
+		fail := func(msg string) {
+			result.Error = msg
+			report = append(report, result)
+		}
+
+		orderID, err := primitive.ObjectIDFromHex(row["order_id"])
+		if err != nil {
+			fail("无效的订单ID")
+			continue
+		}
+		order, ok := orders[orderID]
+		if !ok {
+			fail("订单不存在")
+			continue
+		}
+		if order.PaymentStatus != "已支付" {
+			fail("订单未支付")
+			continue
+		}
+		var productRef primitive.ObjectID
+		if row["product_id"] != "" {
+			if productRef, err = primitive.ObjectIDFromHex(row["product_id"]); err != nil {
+				fail("无效的商品ID")
+				continue
+			}
+		} else if row["product_name"] == "" {
+			fail("缺少商品ID或商品名称")
+			continue
+		}
+		if result.DeliverID == "" {
+			fail("快递单号不能为空")
+			continue
+		}
+		if result.Carrier == "" {
+			fail("快递公司不能为空")
+			continue
+		}
+
+		item, err := matchImportItem(order, row, productRef, productNames)
+		if err != nil {
+			fail(err.Error())
+			continue
+		}
+		result.ProductRef = item.ProductRef.Hex()
+		result.Size = item.Size
+		result.Color = item.Color
+		result.AddressRef = item.AddressItemRef.Hex()
+
+		itemKey := fmt.Sprintf("%s|%s|%s|%s|%s", order.ID.Hex(), item.ProductRef.Hex(), item.Size, item.Color, item.AddressItemRef.Hex())
+		if first, dup := seenItems[itemKey]; dup {
+			fail(fmt.Sprintf("与第 %d 行是同一商品", first))
+			continue
+		}
+		seenItems[itemKey] = result.Row
+
+		if item.ShippingStatus == models.ShippingDelivered {
+			fail("商品已签收，不能修改快递信息")
+			continue
+		}
+		if item.DeliverID == result.DeliverID && item.Carrier == result.Carrier && item.ShippingStatus == models.ShippingShipped {
+			result.Status = importRowUnchanged
+			report = append(report, result)
+			continue
+		}
+
+		result.Status = importRowOK
+		report = append(report, result)
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": order.ID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"items.$[elem].deliver_id":      result.DeliverID,
+				"items.$[elem].carrier":         result.Carrier,
+				"items.$[elem].shipping_status": models.ShippingShipped,
+				"items.$[elem].shipped_at":      now,
+			}}).
+			SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{
+				"elem.product_ref":      item.ProductRef,
+				"elem.size":             item.Size,
+				"elem.color":            item.Color,
+				"elem.address_item_ref": item.AddressItemRef,
+			}}}))
+	}
+
+	summary := fiber.Map{"total": len(report), "ok": 0, "unchanged": 0, "error": 0}
+	for _, r := range report {
+		summary[r.Status] = summary[r.Status].(int) + 1
+	}
+
+	if !dryRun && len(writes) > 0 {
+		if _, err := oc.orderCollection.BulkWrite(c.Context(), writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			log.Printf("批量导入快递单号失败: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "批量更新失败，部分行可能已更新，请重新导入核对",
+				"summary": summary,
+				"rows":    report,
+			})
+		}
+	}
+
+	message := "快递单号导入完成"
+	if dryRun {
+		message = "试运行完成，未更新任何数据"
+	}
+	return c.JSON(fiber.Map{
+		"message": message,
+		"dry_run": dryRun,
+		"summary": summary,
+		"rows":    report,
+	})
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 默认导出的表格加上快递列后直接导入，拆分到多个地址的同一商品也要各自匹配到原来的行
+func TestDefaultExportColumnsRoundTrip(t *testing.T) {
+	product := &models.Product{ID: primitive.NewObjectID(), Name: "卫衣"}
+	other := &models.Product{ID: primitive.NewObjectID(), Name: "卫衣"} // 同名的另一个商品
+	home, office := primitive.NewObjectID(), primitive.NewObjectID()
+	order := &models.Orders{
+		ID:            primitive.NewObjectID(),
+		UserRef:       primitive.NewObjectID(),
+		PaymentStatus: "已支付",
+		OrderItems: []models.OrderItem{
+			{ProductRef: product.ID, Quantity: 1, Size: "L", Color: "黑", AddressItemRef: home},
+			{ProductRef: product.ID, Quantity: 2, Size: "L", Color: "黑", AddressItemRef: office},
+			{ProductRef: other.ID, Quantity: 1, Size: "L", Color: "黑", AddressItemRef: home},
+		},
+	}
+	products := map[primitive.ObjectID]*models.Product{product.ID: product, other.ID: other}
+	productNames := map[primitive.ObjectID]string{product.ID: product.Name, other.ID: other.Name}
+
+	columns, err := parseExportColumns("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{}
+	for _, col := range columns {
+		header = append(header, col.Title)
+	}
+	w.Write(append(header, "快递单号", "快递公司"))
+	for i := range order.OrderItems {
+		item := &order.OrderItems[i]
+		r := exportRow{order: order, item: item, product: products[item.ProductRef], shipment: 1, shipments: 2}
+		record := []string{}
+		for _, col := range columns {
+			record = append(record, fmt.Sprint(col.Value(r)))
+		}
+		w.Write(append(record, fmt.Sprintf("SF%d", i), "顺丰"))
+	}
+	w.Flush()
+
+	rows, err := readShipmentSheet("orders.csv", buf.Bytes())
+	if err != nil {
+		t.Fatalf("readShipmentSheet: %v", err)
+	}
+	if len(rows) != len(order.OrderItems) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(order.OrderItems))
+	}
+	for i, row := range rows {
+		productRef, err := primitive.ObjectIDFromHex(row["product_id"])
+		if err != nil {
+			t.Fatalf("row %d: product_id %q: %v", i, row["product_id"], err)
+		}
+		item, err := matchImportItem(order, row, productRef, productNames)
+		if err != nil {
+			t.Errorf("row %d: %v", i, err)
+			continue
+		}
+		if item != &order.OrderItems[i] {
+			t.Errorf("row %d matched %+v, want %+v", i, *item, order.OrderItems[i])
+		}
+	}
+}
+
+func TestMatchImportItemAmbiguous(t *testing.T) {
+	productRef := primitive.NewObjectID()
+	order := &models.Orders{OrderItems: []models.OrderItem{
+		{ProductRef: productRef, Size: "L", AddressItemRef: primitive.NewObjectID()},
+		{ProductRef: productRef, Size: "L", AddressItemRef: primitive.NewObjectID()},
+	}}
+	// 没有收件地址ID时无法区分拆分的两行
+	if _, err := matchImportItem(order, map[string]string{"size": "L"}, productRef, nil); err == nil {
+		t.Error("ambiguous row matched without address_item_ref")
+	}
+	row := map[string]string{"size": "L", "address_item_ref": order.OrderItems[1].AddressItemRef.Hex()}
+	if item, err := matchImportItem(order, row, productRef, nil); err != nil || item != &order.OrderItems[1] {
+		t.Errorf("matchImportItem = %v, %v; want second item", item, err)
+	}
+}
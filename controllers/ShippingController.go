@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"blog-auth-server/money"
+	"blog-auth-server/utils"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ShippingController struct {
+	templateCollection *mongo.Collection
+	productCollection  *mongo.Collection
+	revisionCollection *mongo.Collection // 修改商品运费模板时记录商品修改历史
+	ctx                context.Context
+}
+
+// NewShippingController 构造函数
+func NewShippingController(templateCollection, productCollection, revisionCollection *mongo.Collection, ctx context.Context) *ShippingController {
+	return &ShippingController{
+		templateCollection: templateCollection,
+		productCollection:  productCollection,
+		revisionCollection: revisionCollection,
+		ctx:                ctx,
+	}
+}
+
+// 按模板计算一个包裹的运费，units 为总重量（克）或总件数，amount 为商品金额
+func templateFee(t *models.ShippingTemplate, units int, amount money.Fen, provinceCode string) money.Fen {
+	var fee money.Fen
+	if t.FreeOver == 0 || amount < t.FreeOver {
+		fee = t.FirstFee
+		if t.Type != models.ShippingFeeFlat && units > t.FirstUnits && t.StepUnits > 0 {
+			steps := (units - t.FirstUnits + t.StepUnits - 1) / t.StepUnits
+			fee += t.StepFee.Mul(steps)
+		}
+	}
+	for _, s := range t.Surcharges {
+		if s.ProvinceCode == provinceCode {
+			fee += s.Fee
+			break
+		}
+	}
+	return fee
+}
+
+// calculateShippingFee 计算订单运费
+// 发往同一地址、使用同一模板的商品合并计费，不同地址分别计费；没有模板可用的商品不收运费
+func (sc *ShippingController) calculateShippingFee(ctx context.Context, items []models.OrderItem, products map[primitive.ObjectID]*models.Product) (money.Fen, error) {
+	cursor, err := sc.templateCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("查询运费模板失败: %v", err)
+	}
+	var list []models.ShippingTemplate
+	if err := cursor.All(ctx, &list); err != nil {
+		return 0, fmt.Errorf("解析运费模板失败: %v", err)
+	}
+	templates := make(map[primitive.ObjectID]*models.ShippingTemplate, len(list))
+	var defaultTemplate *models.ShippingTemplate
+	for i := range list {
+		templates[list[i].ID] = &list[i]
+		if list[i].IsDefault {
+			defaultTemplate = &list[i]
+		}
+	}
+
+	type parcelKey struct {
+		addressRef  primitive.ObjectID
+		templateRef primitive.ObjectID
+	}
+	type parcel struct {
+		template *models.ShippingTemplate
+		province string
+		units    int
+		amount   money.Fen
+	}
+	parcels := make(map[parcelKey]*parcel)
+	var order []parcelKey
+	for _, item := range items {
+		product := products[item.ProductRef]
+		if product == nil {
+			continue
+		}
+		template := defaultTemplate
+		if t, ok := templates[product.ShippingTemplateRef]; ok {
+			template = t
+		}
+		if template == nil {
+			continue
+		}
+		key := parcelKey{item.AddressItemRef, template.ID}
+		p, ok := parcels[key]
+		if !ok {
+			p = &parcel{template: template}
+			if item.ShippingAddress != nil {
+				p.province = item.ShippingAddress.ProvinceCode
+			}
+			parcels[key] = p
+			order = append(order, key)
+		}
+		if template.Type == models.ShippingFeeWeight {
+			p.units += product.Weight * item.Quantity
+		} else {
+			p.units += item.Quantity
+		}
+		p.amount += item.Price.Mul(item.Quantity)
+	}
+
+	var fee money.Fen
+	for _, key := range order {
+		p := parcels[key]
+		fee += templateFee(p.template, p.units, p.amount, p.province)
+	}
+	return fee, nil
+}
+
+func validateShippingTemplate(t models.ShippingTemplate) string {
+	if t.Name == "" {
+		return "模板名称不能为空"
+	}
+	if t.FirstFee < 0 || t.StepFee < 0 || t.FreeOver < 0 {
+		return "运费和包邮门槛不能为负数"
+	}
+	switch t.Type {
+	case models.ShippingFeeFlat:
+	case models.ShippingFeeWeight, models.ShippingFeeCount:
+		if t.FirstUnits < 0 || t.StepUnits < 0 {
+			return "首重/首件和续重/续件不能为负数"
+		}
+		if t.StepFee > 0 && t.StepUnits == 0 {
+			return "设置续费时续重/续件必须大于0"
+		}
+	default:
+		return "无效的计费方式"
+	}
+	seen := make(map[string]bool, len(t.Surcharges))
+	for _, s := range t.Surcharges {
+		if utils.RegionLevel(s.ProvinceCode) != utils.RegionProvince || utils.LookupRegion(s.ProvinceCode) == nil {
+			return fmt.Sprintf("无效的省份代码: %s", s.ProvinceCode)
+		}
+		if s.Fee < 0 {
+			return "加收运费不能为负数"
+		}
+		if seen[s.ProvinceCode] {
+			return fmt.Sprintf("省份 %s 重复设置加收运费", s.ProvinceCode)
+		}
+		seen[s.ProvinceCode] = true
+	}
+	return ""
+}
+
+// 设为默认模板时取消其他模板的默认标记
+func (sc *ShippingController) clearOtherDefaults(ctx context.Context, id primitive.ObjectID) {
+	_, err := sc.templateCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$ne": id}, "is_default": true}, bson.M{"$set": bson.M{"is_default": false}})
+	if err != nil {
+		log.Printf("取消其他默认运费模板失败: %v", err)
+	}
+}
+
+// 后台获取所有运费模板
+func (sc *ShippingController) GetShippingTemplates(c *fiber.Ctx) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := sc.templateCollection.Find(sc.ctx, bson.M{}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "获取运费模板失败"})
+	}
+	defer cursor.Close(sc.ctx)
+
+	templates := []models.ShippingTemplate{}
+	if err := cursor.All(sc.ctx, &templates); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析运费模板失败"})
+	}
+	return c.JSON(fiber.Map{"templates": templates})
+}
+
+// 后台添加运费模板
+func (sc *ShippingController) AddShippingTemplate(c *fiber.Ctx) error {
+	var template models.ShippingTemplate
+	if err := c.BodyParser(&template); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if msg := validateShippingTemplate(template); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	template.ID = primitive.NewObjectID()
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = template.CreatedAt
+	template.AmountUnit = models.AmountUnitFen
+	if template.Surcharges == nil {
+		template.Surcharges = []models.RegionSurcharge{}
+	}
+	if _, err := sc.templateCollection.InsertOne(sc.ctx, template); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "添加运费模板失败"})
+	}
+	if template.IsDefault {
+		sc.clearOtherDefaults(sc.ctx, template.ID)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "运费模板添加成功", "template": template})
+}
+
+// 后台更新运费模板
+func (sc *ShippingController) UpdateShippingTemplate(c *fiber.Ctx) error {
+	templateID, err := primitive.ObjectIDFromHex(c.Params("templateID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的模板ID"})
+	}
+
+	var template models.ShippingTemplate
+	if err := c.BodyParser(&template); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求数据"})
+	}
+	if msg := validateShippingTemplate(template); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	if template.Surcharges == nil {
+		template.Surcharges = []models.RegionSurcharge{}
+	}
+
+	update := bson.M{"$set": bson.M{
+		"name":        template.Name,
+		"type":        template.Type,
+		"first_units": template.FirstUnits,
+		"first_fee":   template.FirstFee,
+		"step_units":  template.StepUnits,
+		"step_fee":    template.StepFee,
+		"free_over":   template.FreeOver,
+		"surcharges":  template.Surcharges,
+		"is_default":  template.IsDefault,
+		"amount_unit": models.AmountUnitFen,
+		"updated_at":  time.Now(),
+	}}
+	result, err := sc.templateCollection.UpdateOne(sc.ctx, bson.M{"_id": templateID}, update)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新运费模板失败"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的运费模板"})
+	}
+	if template.IsDefault {
+		sc.clearOtherDefaults(sc.ctx, templateID)
+	}
+	return c.JSON(fiber.Map{"message": "运费模板更新成功"})
+}
+
+// 后台删除运费模板，关联该模板的商品改为使用默认模板
+func (sc *ShippingController) DeleteShippingTemplate(c *fiber.Ctx) error {
+	templateID, err := primitive.ObjectIDFromHex(c.Params("templateID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的模板ID"})
+	}
+
+	result, err := sc.templateCollection.DeleteOne(sc.ctx, bson.M{"_id": templateID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "删除运费模板失败"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的运费模板"})
+	}
+	unset, err := updateProductsWithRevisions(sc.ctx, sc.productCollection, sc.revisionCollection, revisionActor(c), models.RevisionShipping,
+		bson.M{"shipping_template_ref": templateID},
+		bson.M{"$unset": bson.M{"shipping_template_ref": ""}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		log.Printf("解除商品关联的运费模板失败 (TemplateID: %s): %v", templateID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解除商品关联的运费模板失败"})
+	}
+	return c.JSON(fiber.Map{"message": "运费模板删除成功", "products": unset})
+}
+
+// 后台为商品指定运费模板，可同时设置单件重量
+func (sc *ShippingController) AssignShippingTemplate(c *fiber.Ctx) error {
+	templateID, err := primitive.ObjectIDFromHex(c.Params("templateID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的模板ID"})
+	}
+
+	var input struct {
+		ProductIDs []string `json:"product_ids"`
+		Weight     *int     `json:"weight"` // 单件重量（克），不传时不修改
+	}
+	if err := c.BodyParser(&input); err != nil || len(input.ProductIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "请选择商品"})
+	}
+	if input.Weight != nil && *input.Weight < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "重量不能为负数"})
+	}
+	ids := make([]primitive.ObjectID, 0, len(input.ProductIDs))
+	for _, s := range input.ProductIDs {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("无效的商品ID: %s", s)})
+		}
+		ids = append(ids, id)
+	}
+
+	count, err := sc.templateCollection.CountDocuments(sc.ctx, bson.M{"_id": templateID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询运费模板失败"})
+	}
+	if count == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "未找到指定的运费模板"})
+	}
+
+	set := bson.M{"shipping_template_ref": templateID}
+	if input.Weight != nil {
+		set["weight"] = *input.Weight
+	}
+	modified, err := updateProductsWithRevisions(sc.ctx, sc.productCollection, sc.revisionCollection, revisionActor(c), models.RevisionShipping,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "更新商品运费模板失败"})
+	}
+	return c.JSON(fiber.Map{"message": "商品运费模板更新成功", "matched": modified})
+}
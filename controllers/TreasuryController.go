@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 余额快照间隔
+const treasurySnapshotInterval = 15 * time.Minute
+
+// 同一类告警在该间隔内只通知一次，避免每次快照都刷屏
+const treasuryAlertCooldown = 6 * time.Hour
+
+type TreasuryController struct {
+	snapshotCollection *mongo.Collection
+	outflowCollection  *mongo.Collection
+	alertCollection    *mongo.Collection
+	userCollection     *mongo.Collection
+	ctx                context.Context
+	notifier           Notifier
+	endpoint           string // 为空时轮询 rpcEndpoints
+
+	minSOL float64 // SOL 余额告警阈值
+	minSCL float64 // SCL 余额告警阈值
+
+	alertMu     sync.Mutex
+	lastAlerted map[string]time.Time
+}
+
+// 读取浮点型环境变量，未配置或无效时使用默认值
+func envFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+// NewTreasuryController 构造函数
+// 告警阈值读取环境变量 TREASURY_MIN_SOL、TREASURY_MIN_SCL
+func NewTreasuryController(snapshotCollection, outflowCollection, alertCollection, userCollection *mongo.Collection, ctx context.Context, notifier Notifier) *TreasuryController {
+	if notifier == nil {
+		notifier = LogNotifier{}
+	}
+	tc := &TreasuryController{
+		snapshotCollection: snapshotCollection,
+		outflowCollection:  outflowCollection,
+		alertCollection:    alertCollection,
+		userCollection:     userCollection,
+		ctx:                ctx,
+		notifier:           notifier,
+		minSOL:             envFloat("TREASURY_MIN_SOL", 0.5),
+		minSCL:             envFloat("TREASURY_MIN_SCL", 10000),
+		lastAlerted:        make(map[string]time.Time),
+	}
+	// 启动余额监控 goroutine
+	go tc.startMonitor()
+	return tc
+}
+
+func (tc *TreasuryController) startMonitor() {
+	tc.runSnapshot()
+	ticker := time.NewTicker(treasurySnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tc.runSnapshot()
+		case <-tc.ctx.Done():
+			return
+		}
+	}
+}
+
+func (tc *TreasuryController) runSnapshot() {
+	if _, err := tc.takeSnapshot(tc.ctx); err != nil {
+		log.Printf("记录金库快照失败: %v", err)
+	}
+}
+
+func (tc *TreasuryController) client() *rpc.Client {
+	if tc.endpoint != "" {
+		return rpc.New(tc.endpoint)
+	}
+	return rpc.New(getNextRPCEndpoint())
+}
+
+// 热钱包地址，即链上转出 SOL 和 SCL 的账户
+func hotWalletPublicKey() (solana.PublicKey, error) {
+	account, err := solana.PrivateKeyFromBase58(fromPrivateKey)
+	if err != nil {
+		return solana.PublicKey{}, fmt.Errorf("无法解析热钱包私钥: %v", err)
+	}
+	return account.PublicKey(), nil
+}
+
+// 查询热钱包链上 SOL 和 SCL 余额
+func (tc *TreasuryController) walletBalances(ctx context.Context, wallet solana.PublicKey) (float64, float64, error) {
+	client := tc.client()
+
+	if err := waitForRateLimit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("等待限流失败: %v", err)
+	}
+	solBalance, err := client.GetBalance(ctx, wallet, rpc.CommitmentConfirmed)
+	if err != nil {
+		return 0, 0, fmt.Errorf("查询 SOL 余额失败: %v", err)
+	}
+	sol := float64(solBalance.Value) / 1e9
+
+	tokenAccount, _, err := solana.FindAssociatedTokenAddress(wallet, solana.MustPublicKeyFromBase58(sclTokenMint))
+	if err != nil {
+		return sol, 0, fmt.Errorf("获取 SCL 代币账户失败: %v", err)
+	}
+	if err := waitForRateLimit(ctx); err != nil {
+		return sol, 0, fmt.Errorf("等待限流失败: %v", err)
+	}
+	tokenBalance, err := client.GetTokenAccountBalance(ctx, tokenAccount, rpc.CommitmentConfirmed)
+	if err != nil {
+		return sol, 0, fmt.Errorf("查询 SCL 余额失败: %v", err)
+	}
+	var scl float64
+	if tokenBalance.Value != nil {
+		amount, ok := new(big.Float).SetString(tokenBalance.Value.Amount)
+		if ok {
+			scale := big.NewFloat(math.Pow10(int(tokenBalance.Value.Decimals)))
+			scl, _ = amount.Quo(amount, scale).Float64()
+		}
+	}
+	return sol, scl, nil
+}
+
+// 汇总用户权证负债：可提现部分（含赎回处理中冻结的部分）、其中冻结的部分和锁定部分
+// 冻结的权证在赎回被拒绝或订单删除时会退回用户，完成前仍是负债
+func (tc *TreasuryController) powLiabilities(ctx context.Context) (liability, escrowed, locked float64, err error) {
+	cursor, err := tc.userCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"pow":      bson.M{"$sum": "$pow"},
+			"escrowed": bson.M{"$sum": "$pow_escrowed"},
+			"locked":   bson.M{"$sum": "$pow_locked"},
+		}}},
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("统计用户权证失败: %v", err)
+	}
+	var result []struct {
+		Pow      float64 `bson:"pow"`
+		Escrowed float64 `bson:"escrowed"`
+		Locked   float64 `bson:"locked"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, 0, 0, fmt.Errorf("解析用户权证统计失败: %v", err)
+	}
+	if len(result) == 0 {
+		return 0, 0, 0, nil
+	}
+	return result[0].Pow + result[0].Escrowed, result[0].Escrowed, result[0].Locked, nil
+}
+
+// 统计 since 之后各资产的流出总额
+func (tc *TreasuryController) outflowsSince(ctx context.Context, since time.Time) (map[string]float64, error) {
+	cursor, err := tc.outflowCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$asset", "total": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("统计流出失败: %v", err)
+	}
+	var result []struct {
+		Asset string  `bson:"_id"`
+		Total float64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("解析流出统计失败: %v", err)
+	}
+	totals := make(map[string]float64, len(result))
+	for _, r := range result {
+		totals[r.Asset] = r.Total
+	}
+	return totals, nil
+}
+
+// 记录并保存一次金库快照，同时检查告警
+// 链上查询失败时仍保存负债和流出数据，并在 Error 字段说明原因
+func (tc *TreasuryController) takeSnapshot(ctx context.Context) (*models.TreasurySnapshot, error) {
+	snapshot := &models.TreasurySnapshot{CreatedAt: time.Now()}
+
+	var chainErr error
+	wallet, err := hotWalletPublicKey()
+	if err != nil {
+		chainErr = err
+	} else {
+		snapshot.Wallet = wallet.String()
+		snapshot.SOLBalance, snapshot.SCLBalance, chainErr = tc.walletBalances(ctx, wallet)
+	}
+	if chainErr != nil {
+		snapshot.Error = chainErr.Error()
+	}
+
+	snapshot.PowLiability, snapshot.PowEscrowed, snapshot.PowLocked, err = tc.powLiabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.PowLiability > 0 {
+		snapshot.Coverage = snapshot.SCLBalance / snapshot.PowLiability
+	}
+
+	outflows, err := tc.outflowsSince(ctx, snapshot.CreatedAt.Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	snapshot.SOLOutflow24h = outflows[models.AssetSOL]
+	snapshot.SCLOutflow24h = outflows[models.AssetSCL]
+
+	// 余额查询失败时不判断余额类告警，避免误报
+	snapshot.Alerts = []string{}
+	if chainErr == nil {
+		for _, alert := range tc.evaluateAlerts(snapshot) {
+			snapshot.Alerts = append(snapshot.Alerts, alert.Type)
+			tc.raiseAlert(ctx, alert)
+		}
+	}
+
+	result, err := tc.snapshotCollection.InsertOne(ctx, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("保存金库快照失败: %v", err)
+	}
+	snapshot.ID, _ = result.InsertedID.(primitive.ObjectID)
+	return snapshot, nil
+}
+
+// 根据阈值判断需要触发的告警
+func (tc *TreasuryController) evaluateAlerts(snapshot *models.TreasurySnapshot) []models.TreasuryAlert {
+	var alerts []models.TreasuryAlert
+	if snapshot.SOLBalance < tc.minSOL {
+		alerts = append(alerts, models.TreasuryAlert{
+			Type:      models.TreasuryAlertLowSOL,
+			Asset:     models.AssetSOL,
+			Balance:   snapshot.SOLBalance,
+			Threshold: tc.minSOL,
+			Message:   fmt.Sprintf("热钱包 SOL 余额 %.4f 低于阈值 %.4f，近24小时流出 %.4f SOL", snapshot.SOLBalance, tc.minSOL, snapshot.SOLOutflow24h),
+		})
+	}
+	if snapshot.SCLBalance < tc.minSCL {
+		alerts = append(alerts, models.TreasuryAlert{
+			Type:      models.TreasuryAlertLowSCL,
+			Asset:     models.AssetSCL,
+			Balance:   snapshot.SCLBalance,
+			Threshold: tc.minSCL,
+			Message:   fmt.Sprintf("热钱包 SCL 余额 %.2f 低于阈值 %.2f，近24小时流出 %.2f SCL", snapshot.SCLBalance, tc.minSCL, snapshot.SCLOutflow24h),
+		})
+	}
+	if snapshot.SCLBalance < snapshot.PowLiability {
+		alerts = append(alerts, models.TreasuryAlert{
+			Type:      models.TreasuryAlertUnderCovered,
+			Asset:     models.AssetSCL,
+			Balance:   snapshot.SCLBalance,
+			Threshold: snapshot.PowLiability,
+			Message:   fmt.Sprintf("热钱包 SCL 余额 %.2f 不足以覆盖用户待提现权证 %.2f", snapshot.SCLBalance, snapshot.PowLiability),
+		})
+	}
+	return alerts
+}
+
+// 保存告警并通知，同类告警在冷却时间内只保存不通知
+func (tc *TreasuryController) raiseAlert(ctx context.Context, alert models.TreasuryAlert) {
+	alert.CreatedAt = time.Now()
+
+	tc.alertMu.Lock()
+	last, alerted := tc.lastAlerted[alert.Type]
+	shouldNotify := !alerted || alert.CreatedAt.Sub(last) >= treasuryAlertCooldown
+	if shouldNotify {
+		tc.lastAlerted[alert.Type] = alert.CreatedAt
+	}
+	tc.alertMu.Unlock()
+
+	if shouldNotify {
+		if err := tc.notifier.Notify(ctx, alert); err != nil {
+			alert.NotifyError = err.Error()
+			log.Printf("发送金库告警失败 (%s): %v", alert.Type, err)
+		} else {
+			alert.Notified = true
+		}
+	}
+
+	if _, err := tc.alertCollection.InsertOne(ctx, alert); err != nil {
+		log.Printf("保存金库告警失败 (%s): %v", alert.Type, err)
+	}
+}
+
+// RecordOutflow 记录热钱包的一笔转出，失败只记录日志，不影响转账流程
+func (tc *TreasuryController) RecordOutflow(asset string, amount float64, userRef primitive.ObjectID, toWallet, reason string) {
+	if tc == nil || amount <= 0 {
+		return
+	}
+	_, err := tc.outflowCollection.InsertOne(tc.ctx, models.TreasuryOutflow{
+		Asset:     asset,
+		Amount:    amount,
+		UserRef:   userRef,
+		ToWallet:  toWallet,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("记录热钱包流出失败 (%s %.4f): %v", asset, amount, err)
+	}
+}
+
+// 按天统计最近 days 天的流出
+func (tc *TreasuryController) dailyOutflows(ctx context.Context, days int) ([]bson.M, error) {
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(days - 1))
+	cursor, err := tc.outflowCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"date":  bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at", "timezone": now.Format("-07:00")}},
+				"asset": "$asset",
+			},
+			"total": bson.M{"$sum": "$amount"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":   0,
+			"date":  "$_id.date",
+			"asset": "$_id.asset",
+			"total": 1,
+			"count": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: 1}, {Key: "asset", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result []bson.M
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 后端管理员查看金库看板：最新余额、负债、流出和告警
+func (tc *TreasuryController) GetTreasuryDashboard(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 96)
+	if limit <= 0 || limit > 1000 {
+		limit = 96
+	}
+	days := c.QueryInt("days", 7)
+	if days <= 0 || days > 90 {
+		days = 7
+	}
+
+	cursor, err := tc.snapshotCollection.Find(c.Context(), bson.M{},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(limit)))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询金库快照失败"})
+	}
+	snapshots := []models.TreasurySnapshot{}
+	if err := cursor.All(c.Context(), &snapshots); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析金库快照失败"})
+	}
+
+	var latest *models.TreasurySnapshot
+	if len(snapshots) > 0 {
+		latest = &snapshots[0]
+	}
+
+	daily, err := tc.dailyOutflows(c.Context(), days)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计每日流出失败"})
+	}
+
+	cursor, err = tc.alertCollection.Find(c.Context(), bson.M{},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(20))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "查询金库告警失败"})
+	}
+	alerts := []models.TreasuryAlert{}
+	if err := cursor.All(c.Context(), &alerts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析金库告警失败"})
+	}
+
+	return c.JSON(fiber.Map{
+		"latest":        latest,
+		"history":       snapshots,
+		"daily_outflow": daily,
+		"alerts":        alerts,
+		"thresholds": fiber.Map{
+			"min_sol": tc.minSOL,
+			"min_scl": tc.minSCL,
+		},
+	})
+}
+
+// 后端管理员立即刷新一次金库快照
+func (tc *TreasuryController) RefreshTreasury(c *fiber.Ctx) error {
+	snapshot, err := tc.takeSnapshot(c.Context())
+	if err != nil {
+		log.Printf("刷新金库快照失败: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "刷新金库快照失败"})
+	}
+	return c.JSON(fiber.Map{
+		"message":  "金库快照已更新",
+		"snapshot": snapshot,
+	})
+}
@@ -242,7 +242,7 @@ func (uc *UserController) Login(c *fiber.Ctx) error {
 	user := new(models.User)
 
 	if utils.IsValidEmail(signupReq.Username) {
-		err := uc.collection.FindOne(uc.ctx, bson.D{{"email", signupReq.Username}}).Decode(&user)
+		err := uc.collection.FindOne(uc.ctx, bson.D{{Key: "email", Value: signupReq.Username}}).Decode(&user)
 		if err != nil {
 			return c.JSON(fiber.Map{"message": "Invalid username or password"})
 		}
@@ -251,7 +251,7 @@ func (uc *UserController) Login(c *fiber.Ctx) error {
 			return c.JSON(fiber.Map{"message": "Invalid username or password"})
 		}
 	} else if utils.IsValidPhone(signupReq.Username) {
-		err := uc.collection.FindOne(uc.ctx, bson.D{{"phone", signupReq.Username}}).Decode(&user)
+		err := uc.collection.FindOne(uc.ctx, bson.D{{Key: "phone", Value: signupReq.Username}}).Decode(&user)
 		if err != nil {
 			return c.JSON(fiber.Map{"message": "Invalid username or password"})
 		}
@@ -0,0 +1,514 @@
+package controllers
+
+import (
+	"blog-auth-server/models"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Redis 中小时计数的保留时间，汇总任务异常时留出补偿窗口
+const visitorHourTTL = 48 * time.Hour
+
+// Redis 中按天去重的 HyperLogLog 保留时间，用于跨小时的访客去重
+const visitorDayTTL = 90 * 24 * time.Hour
+
+// 汇总间隔
+const visitorRollupInterval = 5 * time.Minute
+
+// 待汇总的小时集合
+const visitorPendingHours = "visit:hours"
+
+// 前端信标允许上报的事件，商品浏览和加购由后端记录
+var beaconEvents = map[string]bool{
+	models.EventPageView:      true,
+	models.EventCheckoutStart: true,
+}
+
+var visitorEvents = []string{
+	models.EventPageView,
+	models.EventProductView,
+	models.EventAddToCart,
+	models.EventCheckoutStart,
+}
+
+type VisitorController struct {
+	statsCollection      *mongo.Collection
+	orderCollection      *mongo.Collection
+	statisticsCollection *mongo.Collection
+	productCollection    *mongo.Collection
+	redisClient          *redis.Client
+	ctx                  context.Context
+	location             *time.Location // 按天去重使用的时区
+}
+
+// NewVisitorController 构造函数
+func NewVisitorController(statsCollection, orderCollection, statisticsCollection, productCollection *mongo.Collection, redisClient *redis.Client, ctx context.Context) *VisitorController {
+	loc, err := time.LoadLocation(defaultAnalyticsTimezone)
+	if err != nil {
+		loc = time.Local
+	}
+	vc := &VisitorController{
+		statsCollection:      statsCollection,
+		orderCollection:      orderCollection,
+		statisticsCollection: statisticsCollection,
+		productCollection:    productCollection,
+		redisClient:          redisClient,
+		ctx:                  ctx,
+		location:             loc,
+	}
+	// 启动小时汇总 goroutine
+	go vc.startRollup()
+	return vc
+}
+
+func hourKey(t time.Time) string {
+	return t.UTC().Format("2006010215")
+}
+
+func visitorCountKey(hour, event string) string {
+	return fmt.Sprintf("visit:h:%s:%s", hour, event)
+}
+
+func visitorHLLKey(hour, event string) string {
+	return fmt.Sprintf("visit:h:%s:%s:u", hour, event)
+}
+
+func visitorProductKey(hour string) string {
+	return fmt.Sprintf("visit:h:%s:product_view:p", hour)
+}
+
+func visitorProductHLLKey(hour, productID string) string {
+	return fmt.Sprintf("visit:h:%s:product_view:p:%s:u", hour, productID)
+}
+
+func visitorDayKey(day, event string) string {
+	return fmt.Sprintf("visit:d:%s:%s:u", day, event)
+}
+
+// 访客标识：优先使用 Cookie，其次登录用户，最后用 IP+UA 摘要
+func visitorIdentity(c *fiber.Ctx) string {
+	if vid, ok := c.Locals("visitor_id").(string); ok && vid != "" {
+		return vid
+	}
+	if claims, ok := c.Locals("claims").(jwt.MapClaims); ok {
+		if userID, ok := claims["user_id"].(string); ok {
+			return "u:" + userID
+		}
+	}
+	sum := sha1.Sum([]byte(c.IP() + "|" + c.Get(fiber.HeaderUserAgent)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Track 记录一次访客事件，productID 仅商品浏览时传入
+// 统计失败只记录日志，不影响业务请求
+func (vc *VisitorController) Track(c *fiber.Ctx, event, productID string) {
+	if vc == nil {
+		return
+	}
+	vid := visitorIdentity(c)
+	now := time.Now()
+	hour := hourKey(now)
+	day := now.In(vc.location).Format("20060102")
+
+	pipe := vc.redisClient.Pipeline()
+	pipe.Incr(vc.ctx, visitorCountKey(hour, event))
+	pipe.Expire(vc.ctx, visitorCountKey(hour, event), visitorHourTTL)
+	pipe.PFAdd(vc.ctx, visitorHLLKey(hour, event), vid)
+	pipe.Expire(vc.ctx, visitorHLLKey(hour, event), visitorHourTTL)
+	pipe.PFAdd(vc.ctx, visitorDayKey(day, event), vid)
+	pipe.Expire(vc.ctx, visitorDayKey(day, event), visitorDayTTL)
+	if productID != "" {
+		pipe.HIncrBy(vc.ctx, visitorProductKey(hour), productID, 1)
+		pipe.Expire(vc.ctx, visitorProductKey(hour), visitorHourTTL)
+		pipe.PFAdd(vc.ctx, visitorProductHLLKey(hour, productID), vid)
+		pipe.Expire(vc.ctx, visitorProductHLLKey(hour, productID), visitorHourTTL)
+	}
+	pipe.SAdd(vc.ctx, visitorPendingHours, hour)
+	if _, err := pipe.Exec(vc.ctx); err != nil {
+		log.Printf("记录访客事件失败 (%s): %v", event, err)
+	}
+}
+
+// TrackPageView 中间件：记录公开页面的 GET 请求
+func (vc *VisitorController) TrackPageView(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodGet {
+		vc.Track(c, models.EventPageView, "")
+	}
+	return c.Next()
+}
+
+// 前端信标上报：页面浏览、进入结算
+func (vc *VisitorController) Beacon(c *fiber.Ctx) error {
+	var req struct {
+		Event string `json:"event"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "无效的请求体"})
+	}
+	if !beaconEvents[req.Event] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "不支持的事件类型"})
+	}
+	vc.Track(c, req.Event, "")
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (vc *VisitorController) startRollup() {
+	ticker := time.NewTicker(visitorRollupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			vc.rollupPendingHours()
+		case <-vc.ctx.Done():
+			return
+		}
+	}
+}
+
+// 将 Redis 中的小时计数写入 MongoDB，写入的是绝对值，重复汇总是幂等的
+// 已结束的小时汇总成功后从待汇总集合中移除
+func (vc *VisitorController) rollupPendingHours() {
+	hours, err := vc.redisClient.SMembers(vc.ctx, visitorPendingHours).Result()
+	if err != nil {
+		log.Printf("读取待汇总小时失败: %v", err)
+		return
+	}
+	current := hourKey(time.Now())
+	for _, hour := range hours {
+		if err := vc.rollupHour(hour); err != nil {
+			log.Printf("汇总访客统计失败 (%s): %v", hour, err)
+			continue
+		}
+		if hour < current {
+			vc.redisClient.SRem(vc.ctx, visitorPendingHours, hour)
+		}
+	}
+}
+
+func (vc *VisitorController) upsertHourlyStat(hour time.Time, event string, productRef primitive.ObjectID, count, unique int64) error {
+	_, err := vc.statsCollection.UpdateOne(vc.ctx,
+		bson.M{"hour": hour, "event": event, "product_ref": productRef},
+		bson.M{"$set": bson.M{"count": count, "unique": unique, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (vc *VisitorController) rollupHour(hour string) error {
+	hourTime, err := time.Parse("2006010215", hour)
+	if err != nil {
+		// 无法识别的数据直接丢弃
+		vc.redisClient.SRem(vc.ctx, visitorPendingHours, hour)
+		return nil
+	}
+
+	for _, event := range visitorEvents {
+		count, err := vc.redisClient.Get(vc.ctx, visitorCountKey(hour, event)).Int64()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		unique, err := vc.redisClient.PFCount(vc.ctx, visitorHLLKey(hour, event)).Result()
+		if err != nil {
+			return err
+		}
+		if err := vc.upsertHourlyStat(hourTime, event, primitive.NilObjectID, count, unique); err != nil {
+			return err
+		}
+	}
+
+	products, err := vc.redisClient.HGetAll(vc.ctx, visitorProductKey(hour)).Result()
+	if err != nil {
+		return err
+	}
+	for productID, countStr := range products {
+		productRef, err := primitive.ObjectIDFromHex(productID)
+		if err != nil {
+			continue
+		}
+		count, _ := strconv.ParseInt(countStr, 10, 64)
+		unique, err := vc.redisClient.PFCount(vc.ctx, visitorProductHLLKey(hour, productID)).Result()
+		if err != nil {
+			return err
+		}
+		if err := vc.upsertHourlyStat(hourTime, models.EventProductView, productRef, count, unique); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 区间内各事件的次数和去重访客数
+func (vc *VisitorController) eventTotals(ctx context.Context, r salesRange) (map[string]fiber.Map, error) {
+	cursor, err := vc.statsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"hour":        bson.M{"$gte": r.Start, "$lt": r.End},
+			"product_ref": primitive.NilObjectID,
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$event",
+			"count":  bson.M{"$sum": "$count"},
+			"unique": bson.M{"$sum": "$unique"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Event  string `bson:"_id"`
+		Count  int64  `bson:"count"`
+		Unique int64  `bson:"unique"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]fiber.Map, len(visitorEvents))
+	for _, event := range visitorEvents {
+		totals[event] = fiber.Map{"count": int64(0), "unique": int64(0)}
+	}
+	for _, row := range rows {
+		totals[row.Event] = fiber.Map{"count": row.Count, "unique": vc.uniqueVisitors(ctx, r, row.Event, row.Unique)}
+	}
+	return totals, nil
+}
+
+// 按天的 HyperLogLog 只按 vc.location（默认北京时间）的自然日记录
+// 区间的起止时间都是该时区的零点时才能用按天数据准确去重，其他时区的日期边界与之错开
+func (vc *VisitorController) dayAligned(r salesRange) bool {
+	for _, t := range []time.Time{r.Start, r.End} {
+		local := t.In(vc.location)
+		if local.Hour() != 0 || local.Minute() != 0 || local.Second() != 0 || local.Nanosecond() != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 响应中的区间信息，unique_by_day 为 false 时去重访客数为按小时去重数之和（会偏大）
+func (vc *VisitorController) rangeInfo(r salesRange) fiber.Map {
+	return fiber.Map{
+		"start":           r.Start,
+		"end":             r.End,
+		"timezone":        r.Timezone,
+		"unique_timezone": vc.location.String(),
+		"unique_by_day":   vc.dayAligned(r) && time.Since(r.Start) <= visitorDayTTL,
+	}
+}
+
+// 区间去重访客数：合并按天的 HyperLogLog
+// 区间与按天数据的日期边界不一致，或起点早于按天 HyperLogLog 的保留期时，退回按小时去重数之和（会偏大）
+func (vc *VisitorController) uniqueVisitors(ctx context.Context, r salesRange, event string, fallback int64) int64 {
+	if !vc.dayAligned(r) || time.Since(r.Start) > visitorDayTTL {
+		return fallback
+	}
+	var keys []string
+	for d := r.Start.In(vc.location); d.Before(r.End); d = d.AddDate(0, 0, 1) {
+		keys = append(keys, visitorDayKey(d.Format("20060102"), event))
+	}
+	if len(keys) == 0 {
+		return fallback
+	}
+	unique, err := vc.redisClient.PFCount(ctx, keys...).Result()
+	if err != nil {
+		return fallback
+	}
+	return unique
+}
+
+// 下单漏斗：浏览 → 加购 → 下单 → 支付
+// 未支付订单15分钟后会被清理，下单数 = 仍存在的订单 + 清理统计中的订单
+func (vc *VisitorController) funnel(ctx context.Context, r salesRange, totals map[string]fiber.Map) (fiber.Map, error) {
+	created, err := vc.orderCollection.CountDocuments(ctx, bson.M{"created_at": bson.M{"$gte": r.Start, "$lt": r.End}})
+	if err != nil {
+		return nil, err
+	}
+	var expired struct {
+		Count int64 `bson:"count"`
+	}
+	err = aggregateOne(ctx, vc.statisticsCollection, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"cleanup_date": bson.M{"$gte": r.Start, "$lt": r.End}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "count": bson.M{"$sum": "$deleted_count"}}}},
+	}, &expired)
+	if err != nil {
+		return nil, err
+	}
+	paid, err := vc.orderCollection.CountDocuments(ctx, paidOrdersMatch(r))
+	if err != nil {
+		return nil, err
+	}
+
+	views := totals[models.EventProductView]["unique"].(int64)
+	carts := totals[models.EventAddToCart]["unique"].(int64)
+	checkouts := totals[models.EventCheckoutStart]["unique"].(int64)
+	orders := created + expired.Count
+	return fiber.Map{
+		"view":     views,
+		"cart":     carts,
+		"checkout": checkouts,
+		"order":    orders,
+		"paid":     paid,
+		"rates": fiber.Map{
+			"view_to_cart":  safeRatio(float64(carts), float64(views)),
+			"cart_to_order": safeRatio(float64(orders), float64(carts)),
+			"order_to_paid": safeRatio(float64(paid), float64(orders)),
+			"view_to_paid":  safeRatio(float64(paid), float64(views)),
+		},
+	}, nil
+}
+
+// 浏览量最高的商品
+func (vc *VisitorController) topViewedProducts(ctx context.Context, r salesRange, limit int) ([]bson.M, error) {
+	cursor, err := vc.statsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"hour":        bson.M{"$gte": r.Start, "$lt": r.End},
+			"event":       models.EventProductView,
+			"product_ref": bson.M{"$ne": primitive.NilObjectID},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$product_ref",
+			"views":  bson.M{"$sum": "$count"},
+			"unique": bson.M{"$sum": "$unique"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"views": -1}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         vc.productCollection.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"product_ref": "$_id",
+			"name":        bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$product.name", 0}}, ""}},
+			"views":       1,
+			"unique":      1,
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	products := []bson.M{}
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// 展示后台浏览数据GetVisitors：区间内各事件汇总及与上一等长区间对比
+// 参数：start、end（YYYY-MM-DD），tz（IANA 时区）
+// 按天去重的访客数只在北京时间下统计，tz 为其他偏移的时区时 unique 退回按小时去重数之和，见 range.unique_by_day
+func (vc *VisitorController) GetVisitors(c *fiber.Ctx) error {
+	r, err := parseSalesRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	current, err := vc.eventTotals(c.Context(), r)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计访客数据失败"})
+	}
+	prevRange := r.previous()
+	previous, err := vc.eventTotals(c.Context(), prevRange)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计上期访客数据失败"})
+	}
+
+	change := fiber.Map{}
+	for _, event := range visitorEvents {
+		change[event] = growth(float64(current[event]["unique"].(int64)), float64(previous[event]["unique"].(int64)))
+	}
+
+	return c.JSON(fiber.Map{
+		"range":    vc.rangeInfo(r),
+		"current":  current,
+		"previous": fiber.Map{"start": prevRange.Start, "end": prevRange.End, "events": previous},
+		"change":   change,
+	})
+}
+
+// 展示后台浏览数据分析GetVisitorsAnalytics：趋势、漏斗和热门商品
+// 参数：start、end、tz 同 GetVisitors，granularity 为 hour/day，top 为排行数量
+func (vc *VisitorController) GetVisitorsAnalytics(c *fiber.Ctx) error {
+	r, err := parseSalesRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	formats := map[string]string{"hour": "%Y-%m-%d %H:00", "day": "%Y-%m-%d"}
+	granularity := c.Query("granularity", "day")
+	format, ok := formats[granularity]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "granularity 仅支持 hour、day"})
+	}
+	top := c.QueryInt("top", 10)
+	if top <= 0 || top > 100 {
+		top = 10
+	}
+
+	cursor, err := vc.statsCollection.Aggregate(c.Context(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"hour":        bson.M{"$gte": r.Start, "$lt": r.End},
+			"product_ref": primitive.NilObjectID,
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"period": bson.M{"$dateToString": bson.M{"format": format, "date": "$hour", "timezone": r.Timezone}},
+				"event":  "$event",
+			},
+			"count":  bson.M{"$sum": "$count"},
+			"unique": bson.M{"$sum": "$unique"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":    0,
+			"period": "$_id.period",
+			"event":  "$_id.event",
+			"count":  1,
+			"unique": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "period", Value: 1}, {Key: "event", Value: 1}}}},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计访客趋势失败"})
+	}
+	series := []bson.M{}
+	if err := cursor.All(c.Context(), &series); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "解析访客趋势失败"})
+	}
+
+	totals, err := vc.eventTotals(c.Context(), r)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计访客数据失败"})
+	}
+	funnel, err := vc.funnel(c.Context(), r, totals)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计转化漏斗失败"})
+	}
+	products, err := vc.topViewedProducts(c.Context(), r, top)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "统计热门商品失败"})
+	}
+
+	return c.JSON(fiber.Map{
+		"range":        vc.rangeInfo(r),
+		"granularity":  granularity,
+		"totals":       totals,
+		"series":       series,
+		"funnel":       funnel,
+		"top_products": products,
+	})
+}
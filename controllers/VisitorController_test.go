@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestVisitorDayAligned(t *testing.T) {
+	shanghai, err := time.LoadLocation(defaultAnalyticsTimezone)
+	if err != nil {
+		t.Skip("缺少时区数据")
+	}
+	vc := &VisitorController{location: shanghai}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"start=2026-10-01&end=2026-10-07", true},
+		{"start=2026-10-01&end=2026-10-07&tz=Asia/Shanghai", true},
+		{"start=2026-10-01&end=2026-10-07&tz=Asia/Singapore", true}, // 同为 UTC+8
+		{"start=2026-10-01&end=2026-10-07&tz=UTC", false},
+		{"start=2026-10-01&end=2026-10-07&tz=America/New_York", false},
+	}
+	for _, tc := range cases {
+		var got bool
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			r, err := parseSalesRange(c)
+			if err != nil {
+				return err
+			}
+			got = vc.dayAligned(r)
+			return nil
+		})
+		if _, err := app.Test(httptest.NewRequest("GET", "/?"+tc.query, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("dayAligned(%s) = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
@@ -12,6 +12,7 @@ require (
 	github.com/xuri/excelize/v2 v2.8.1
 	go.mongodb.org/mongo-driver v1.16.1
 	golang.org/x/crypto v0.26.0
+	golang.org/x/image v0.18.0
 	golang.org/x/net v0.28.0
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 )
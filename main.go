@@ -2,6 +2,7 @@ package main
 
 import (
 	"blog-auth-server/controllers"
+	"blog-auth-server/media"
 	"blog-auth-server/middleware"
 	"blog-auth-server/utils"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 	"github.com/smartwalle/alipay/v3"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/net/context"
 )
 
@@ -27,6 +29,13 @@ var cartController *controllers.CartController
 var orderController *controllers.OrderController
 var addressController *controllers.AddressController
 var redemptionOrderController *controllers.RedemptionOrderController
+var powController *controllers.PowController
+var treasuryController *controllers.TreasuryController
+var visitorController *controllers.VisitorController
+var logisticsController *controllers.LogisticsController
+var shippingController *controllers.ShippingController
+var currencyController *controllers.CurrencyController
+var mediaController *controllers.MediaController
 var middleware1 *middleware.Middleware
 
 func init() {
@@ -49,6 +58,20 @@ func init() {
 	addressCollection := db.Collection("address")
 	statisticsCollection := db.Collection("order_cleanup_statistics")
 	redemptionOrderCollection := db.Collection("redemption_orders")
+	powRuleCollection := db.Collection("pow_rules")
+	powConfigCollection := db.Collection("pow_config")
+	treasurySnapshotCollection := db.Collection("treasury_snapshots")
+	treasuryOutflowCollection := db.Collection("treasury_outflows")
+	treasuryAlertCollection := db.Collection("treasury_alerts")
+	visitorStatsCollection := db.Collection("visitor_stats")
+	trackingEventCollection := db.Collection("tracking_events")
+	orderAddressAuditCollection := db.Collection("order_address_audits")
+	shippingTemplateCollection := db.Collection("shipping_templates")
+	migrationCollection := db.Collection("migrations")
+	currencyCollection := db.Collection("currencies")
+	fxRateCollection := db.Collection("fx_rates")
+	// 修改记录中字段的新旧值类型不固定，嵌套文档解析为 map，返回的 JSON 与商品接口一致
+	productRevisionCollection := db.Collection("product_revisions", options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true}))
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:     "127.0.0.1:6379",
 		Password: "password",
@@ -71,13 +94,50 @@ func init() {
 		log.Fatalf("Failed to load Alipay public key: %v", err)
 	}
 
-	userController = controllers.NewUserController(usercollection, ctx, redisClient)
-	productController = controllers.NewProductController(productCollection, ctx)
+	// 金额统一以分保存，旧数据迁移完成前不处理请求
+	if err := controllers.MigrateMoneyToFen(ctx, migrationCollection, productCollection, orderCollection, shippingTemplateCollection); err != nil {
+		log.Fatalf("金额单位迁移失败: %v", err)
+	}
+	if err := controllers.EnsureIndexes(ctx, orderCollection, redemptionOrderCollection); err != nil {
+		log.Fatalf("创建索引失败: %v", err)
+	}
 
-	cartController = controllers.NewCartController(cartCollection, productCollection, ctx)
-	orderController = controllers.NewOrderController(usercollection, cartCollection, productCollection, orderCollection, addressCollection, statisticsCollection, ctx, alipayClient)
-	addressController = controllers.NewAddressController(addressCollection, ctx)
-	redemptionOrderController = controllers.NewRedemptionOrderController(redemptionOrderCollection, usercollection, orderCollection, ctx)
+	userController = controllers.NewUserController(usercollection, ctx, redisClient)
+	// 访客统计，Redis 实时计数，每小时汇总到 MongoDB
+	visitorController = controllers.NewVisitorController(visitorStatsCollection, orderCollection, statisticsCollection, productCollection, redisClient, ctx)
+	// 展示货币和汇率，订单仍以人民币结算
+	currencyController = controllers.NewCurrencyController(currencyCollection, fxRateCollection, ctx)
+	// 商品图片存储，MEDIA_STORAGE 未配置时保存在本地 upload 目录
+	mediaStorage, err := media.NewStorageFromEnv()
+	if err != nil {
+		log.Fatalf("初始化图片存储失败: %v", err)
+	}
+	mediaService, err := media.NewServiceFromEnv(mediaStorage)
+	if err != nil {
+		log.Fatalf("初始化图片处理失败: %v", err)
+	}
+	mediaController = controllers.NewMediaController(productCollection, mediaService, ctx)
+	productController = controllers.NewProductController(productCollection, productRevisionCollection, ctx, visitorController, currencyController, mediaService)
+
+	cartController = controllers.NewCartController(cartCollection, productCollection, ctx, visitorController, currencyController)
+	powController = controllers.NewPowController(powRuleCollection, powConfigCollection, usercollection, orderCollection, productCollection, ctx)
+	// 热钱包余额监控，配置 TREASURY_ALERT_WEBHOOK 后告警通过 Webhook 发送
+	treasuryController = controllers.NewTreasuryController(treasurySnapshotCollection, treasuryOutflowCollection, treasuryAlertCollection, usercollection, ctx, controllers.NewNotifierFromEnv())
+	shippingController = controllers.NewShippingController(shippingTemplateCollection, productCollection, productRevisionCollection, ctx)
+	orderController = controllers.NewOrderController(usercollection, cartCollection, productCollection, orderCollection, addressCollection, statisticsCollection, orderAddressAuditCollection, ctx, alipayClient, powController, treasuryController, shippingController, currencyController)
+	addressController = controllers.NewAddressController(addressCollection, orderCollection, ctx)
+	// 物流轨迹，LOGISTICS_PROVIDER 未配置时不查询，本地联调使用 LOGISTICS_PROVIDER=fake
+	carrierProvider, err := controllers.NewCarrierProviderFromEnv()
+	if err != nil {
+		log.Fatalf("初始化物流服务商失败: %v", err)
+	}
+	logisticsController = controllers.NewLogisticsController(orderCollection, trackingEventCollection, carrierProvider, ctx)
+	// 链上核验服务，未配置金库地址时赎回订单无法核验
+	redemptionVerifier, err := controllers.NewRedemptionVerifier("", "")
+	if err != nil {
+		log.Printf("初始化链上核验服务失败: %v", err)
+	}
+	redemptionOrderController = controllers.NewRedemptionOrderController(redemptionOrderCollection, usercollection, orderCollection, ctx, redemptionVerifier, alipayClient)
 
 	middleware1 = middleware.NewMiddleware(ctx, redisClient)
 
@@ -87,7 +147,9 @@ func main() {
 	// 创建安全中间件
 	securityMiddleware := middleware.NewSecurityMiddleware()
 
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		BodyLimit: 20 << 20, // 商品图片表单包含多张图片，与 nginx 的 client_max_body_size 一致
+	})
 	// 设置静态文件目录为 upload
 	app.Static("/upload", "./upload")
 	// 添加 CORS 中间件，允许所有源访问
@@ -102,71 +164,126 @@ func main() {
 	app.Use(logger.New())
 
 	api := app.Group("/api")
-	api.Get("/", productController.AllProduct)          //产品展示页
-	api.Get("/product/:id", productController.FetchOne) //产品信息页
+	api.Use(middleware.VisitorID()) // 为访客分配匿名标识
+
+	api.Get("/", visitorController.TrackPageView, productController.AllProduct)      //产品展示页
+	api.Get("/product/:id", productController.FetchOne)                              //产品信息页
+	api.Post("/track", securityMiddleware.BeaconLimiter(), visitorController.Beacon) //前端上报浏览和结算事件
+	api.Get("/currencies", currencyController.GetCurrencies)                         //可选的展示货币及当前汇率
 	api.Post("/signup", userController.CreateUser)
 	api.Post("/login", userController.Login)
 
 	api.Get("/cart", middleware1.UserMiddlewareHandler, cartController.AllfromCart) //产品结算页 用户可以增删查 改数量,前端localStorage，登录后同步到数据库 在支付的时候需要登录session
 	api.Post("/cart", middleware1.UserMiddlewareHandler, cartController.AddtoCart)  //后端接收到购物车数据后，将其与当前登录的用户账户关联起来, 关联成功后，前端可以清除localStorage
 	api.Delete("/cart", middleware1.UserMiddlewareHandler, cartController.DelfromCart)
+	api.Put("/cart", middleware1.UserMiddlewareHandler, cartController.UpdatefromCart)        //修改购物车商品数量或规格
+	api.Post("/cart/merge", middleware1.UserMiddlewareHandler, cartController.MergeGuestCart) //登录后合并游客购物车，同一 guest_cart_id 只合并一次
 	api.Get("/userinfo", middleware1.UserMiddlewareHandler, userController.GetUserInfo)
 
-	api.Post("/address", middleware1.UserMiddlewareHandler, addressController.AddAddress)                 //增
-	api.Delete("/address/:addressID", middleware1.UserMiddlewareHandler, addressController.DelAddress)    //删
-	api.Put("/address/:addressID", middleware1.UserMiddlewareHandler, addressController.UpdateAddress)    //改
-	api.Get("/address", middleware1.UserMiddlewareHandler, addressController.GetAddress)                  //查
-	api.Get("/address/:addressID", middleware1.UserMiddlewareHandler, addressController.FromIDGetAddress) //通过ID获取地址
+	api.Post("/address", middleware1.UserMiddlewareHandler, addressController.AddAddress)                          //增
+	api.Delete("/address/:addressID", middleware1.UserMiddlewareHandler, addressController.DelAddress)             //删
+	api.Put("/address/:addressID", middleware1.UserMiddlewareHandler, addressController.UpdateAddress)             //改
+	api.Put("/address/:addressID/default", middleware1.UserMiddlewareHandler, addressController.SetDefaultAddress) //设为默认地址
+	api.Get("/address", middleware1.UserMiddlewareHandler, addressController.GetAddress)                           //查
+	api.Get("/address/:addressID", middleware1.UserMiddlewareHandler, addressController.FromIDGetAddress)          //通过ID获取地址
+	api.Get("/regions", addressController.GetRegions)                                                              //行政区划级联查询，parent 为上级代码
 	api.Post("/create_qr_code", middleware1.UserMiddlewareHandler, orderController.CreateQRCode)
 
-	api.Post("/orders/create", middleware1.UserMiddlewareHandler, securityMiddleware.RateLimiter(), orderController.AddOrder)                                     //创建订单
+	api.Post("/orders/quote", middleware1.UserMiddlewareHandler, orderController.GetOrderQuote)                                 //结算页报价，返回应付金额和 quote_id
+	api.Post("/orders/create", middleware1.UserMiddlewareHandler, securityMiddleware.RateLimiter(), orderController.AddOrder)   //使用 quote_id 创建订单
 	api.Get("/onepay", middleware1.UserMiddlewareHandler, orderController.GetOrder)                                             //查询个人所有订单
 	api.Get("/query-auto", middleware1.UserMiddlewareHandler, securityMiddleware.RateLimiter(), orderController.QueryOrderAuto) //个人页面自动查询更新待支付订单，查询个人所有订单
 	api.Get("/onepay/:orderID", middleware1.UserMiddlewareHandler, orderController.GetOneOrder)                                 //查询单个订单
+	api.Get("/onepay/:orderID/tracking", middleware1.UserMiddlewareHandler, logisticsController.GetOrderTracking)               //查询订单物流
+	api.Post("/logistics/push/:provider", logisticsController.ReceivePush)                                                      //物流服务商推送轨迹
 	api.Post("/user/pow-addr", middleware1.UserMiddlewareHandler, userController.SetPowAddress)
 	api.Get("/query_order/:orderID", middleware1.UserMiddlewareHandler, securityMiddleware.RateLimiter(), orderController.QueryOrder) //查询支付宝的支付信息,应用速率限制中间件
 	api.Post("/transfer-scl", middleware1.UserMiddlewareHandler, securityMiddleware.RateLimiter(), orderController.TransferSCL)
 	api.Post("/redemption-order", middleware1.UserMiddlewareHandler, securityMiddleware.RateLimiter(), redemptionOrderController.CreateRedemptionOrder)
+	api.Get("/redemption-orders", middleware1.UserMiddlewareHandler, redemptionOrderController.GetMyRedemptionOrders)             //查询个人赎回订单
+	api.Get("/redemption-orders/:dempOrderID", middleware1.UserMiddlewareHandler, redemptionOrderController.GetMyRedemptionOrder) //查询个人单个赎回订单
 
 	api.Get("/admininfo", middleware1.AdminMiddlewareHandler, userController.GetUserInfo)
 	api.Get("/createadmin", userController.CreateAdminUser)
 	api.Post("/adminTestRoute", middleware1.AdminMiddlewareHandler, userController.TestRoute)
-	api.Get("/admin", middleware1.AdminMiddlewareHandler)                                                  //后台主页，展示销售数据,支付订单，未支付订单，数量和金钱，浏览数据统计
-	api.Get("/admin/products", middleware1.AdminMiddlewareHandler, productController.AllProduct)           //展示后台产品数据
-	api.Get("/admin/product/:id", middleware1.AdminMiddlewareHandler, productController.FetchOne)          //产品信息页
-	api.Post("/admin/addproduct", middleware1.AdminMiddlewareHandler, productController.AddProduct)        //admin 添加产品
-	api.Delete("/admin/delproduct/:id", middleware1.AdminMiddlewareHandler, productController.DelProduct)  //admin 删除产品
-	api.Post("/admin/editproduct/:id", middleware1.AdminMiddlewareHandler, productController.UpdateProduct) //admin 编辑产品
+	api.Get("/admin", middleware1.AdminMiddlewareHandler)                                                                  //后台主页，展示销售数据,支付订单，未支付订单，数量和金钱，浏览数据统计
+	api.Get("/admin/products", middleware1.AdminMiddlewareHandler, productController.AllProduct)                           //展示后台产品数据
+	api.Get("/admin/product/:id", middleware1.AdminMiddlewareHandler, productController.FetchOne)                          //产品信息页
+	api.Post("/admin/addproduct", middleware1.AdminMiddlewareHandler, productController.AddProduct)                        //admin 添加产品
+	api.Delete("/admin/delproduct/:id", middleware1.AdminMiddlewareHandler, productController.DelProduct)                  //admin 删除产品
+	api.Post("/admin/editproduct/:id", middleware1.AdminMiddlewareHandler, productController.UpdateProduct)                //admin 编辑产品
+	api.Put("/admin/product/:id/status", middleware1.AdminMiddlewareHandler, productController.SetProductStatus)           //发布、下架或归档产品，可定时上下架
+	api.Post("/admin/product/:id/images", middleware1.AdminMiddlewareHandler, productController.AddProductImages)          //为已有产品上传图片
+	api.Delete("/admin/product/:id/images", middleware1.AdminMiddlewareHandler, productController.DeleteProductImage)      //删除产品图片，url 为图片地址
+	api.Put("/admin/product/:id/images/order", middleware1.AdminMiddlewareHandler, productController.ReorderProductImages) //调整图片顺序
+	api.Put("/admin/product/:id/images/main", middleware1.AdminMiddlewareHandler, productController.SetProductMainImage)   //设置主图
+	api.Put("/admin/product/:id/images/color", middleware1.AdminMiddlewareHandler, productController.SetProductImageColor) //图片关联颜色
+	api.Post("/admin/media/gc", middleware1.AdminMiddlewareHandler, mediaController.CollectMediaGarbage)                   //清理未引用的商品图片，dry_run=true 时只列出
+
+	api.Get("/admin/product/:id/revisions", middleware1.AdminMiddlewareHandler, productController.GetProductRevisions)                         //产品修改历史，可按 action 筛选
+	api.Get("/admin/product/:id/revisions/:revisionID", middleware1.AdminMiddlewareHandler, productController.GetProductRevision)              //修改记录详情，包含修改后的完整产品
+	api.Post("/admin/product/:id/revisions/:revisionID/restore", middleware1.AdminMiddlewareHandler, productController.RestoreProductRevision) //恢复到历史版本
+	api.Get("/admin/product/:id/price-history", middleware1.AdminMiddlewareHandler, productController.GetProductPriceHistory)                  //价格变动历史，from、to 为日期
 
 	api.Get("/admin/users", middleware1.AdminMiddlewareHandler, userController.AllUsers)          //展示后台用户数据
 	api.Get("/admin/user/:id", middleware1.AdminMiddlewareHandler, userController.GetOneUser)     //one user
 	api.Delete("/admin/users/:id", middleware1.AdminMiddlewareHandler, userController.DelUser)    //admin删除用户
 	api.Post("/admin/user/update-pow", middleware1.AdminMiddlewareHandler, userController.SetPow) //admin更新用户pow
 
-	api.Get("/admin/orders", middleware1.AdminMiddlewareHandler, orderController.GetOrder)                     //展示后台 个人订单数据
-	api.Get("/admin/orders/:orderID", middleware1.AdminMiddlewareHandler, orderController.GetOneOrderByID)     //展示后台 单个订单数据
-	api.Get("/admin/address/:addressID", middleware1.AdminMiddlewareHandler, addressController.GetAddressByID) //展示后台 后台单个订单地址数据
+	api.Get("/admin/pow/config", middleware1.AdminMiddlewareHandler, powController.GetPowConfig)            //获取权证发放配置
+	api.Post("/admin/pow/config", middleware1.AdminMiddlewareHandler, powController.UpdatePowConfig)        //更新权证发放配置
+	api.Get("/admin/pow/rules", middleware1.AdminMiddlewareHandler, powController.GetPowRules)              //获取权证规则
+	api.Post("/admin/pow/rules", middleware1.AdminMiddlewareHandler, powController.AddPowRule)              //添加权证规则
+	api.Put("/admin/pow/rules/:ruleID", middleware1.AdminMiddlewareHandler, powController.UpdatePowRule)    //更新权证规则
+	api.Delete("/admin/pow/rules/:ruleID", middleware1.AdminMiddlewareHandler, powController.DeletePowRule) //删除权证规则
+
+	api.Get("/admin/shipping/templates", middleware1.AdminMiddlewareHandler, shippingController.GetShippingTemplates)                        //获取运费模板
+	api.Post("/admin/shipping/templates", middleware1.AdminMiddlewareHandler, shippingController.AddShippingTemplate)                        //添加运费模板
+	api.Put("/admin/shipping/templates/:templateID", middleware1.AdminMiddlewareHandler, shippingController.UpdateShippingTemplate)          //更新运费模板
+	api.Delete("/admin/shipping/templates/:templateID", middleware1.AdminMiddlewareHandler, shippingController.DeleteShippingTemplate)       //删除运费模板
+	api.Put("/admin/shipping/templates/:templateID/products", middleware1.AdminMiddlewareHandler, shippingController.AssignShippingTemplate) //为商品指定运费模板
+
+	api.Get("/admin/currencies", middleware1.AdminMiddlewareHandler, currencyController.GetCurrencySettings) //获取货币设置
+	api.Put("/admin/currencies/:code", middleware1.AdminMiddlewareHandler, currencyController.SaveCurrency)  //添加或修改货币
+	api.Get("/admin/fx-rates", middleware1.AdminMiddlewareHandler, currencyController.GetFxRates)            //汇率历史
+	api.Post("/admin/fx-rates", middleware1.AdminMiddlewareHandler, currencyController.AddFxRate)            //录入汇率
+	api.Post("/admin/fx-rates/import", middleware1.AdminMiddlewareHandler, currencyController.ImportFxRates) //导入汇率，dry_run=true 时只校验
+
+	api.Get("/admin/orders", middleware1.AdminMiddlewareHandler, orderController.GetOrder)                                      //展示后台 个人订单数据
+	api.Get("/admin/orders/:orderID", middleware1.AdminMiddlewareHandler, orderController.GetOneOrderByID)                      //展示后台 单个订单数据
+	api.Put("/admin/orders/:orderID/address", middleware1.AdminMiddlewareHandler, orderController.CorrectOrderAddress)          //修正订单收件地址快照
+	api.Get("/admin/orders/:orderID/address-audits", middleware1.AdminMiddlewareHandler, orderController.GetOrderAddressAudits) //订单地址修改记录
+	api.Post("/admin/backfill-order-addresses", middleware1.AdminMiddlewareHandler, orderController.BackfillAddressSnapshots)   //为旧订单回填地址快照
+	api.Post("/admin/migrate-addresses", middleware1.AdminMiddlewareHandler, addressController.MigrateLegacyAddresses)          //旧地址迁移为行政区划代码
+	api.Get("/admin/address/:addressID", middleware1.AdminMiddlewareHandler, addressController.GetAddressByID)                  //展示后台 后台单个订单地址数据
 
 	api.Get("/admin/redemption-orders", middleware1.AdminMiddlewareHandler, redemptionOrderController.GetRedemptionOrder)                               //展示后台 赎回订单数据
 	api.Post("/admin/update-redemption-status/:dempOrderID", middleware1.AdminMiddlewareHandler, redemptionOrderController.UpdateRedemptionOrderStatus) //更新赎回订单状态
 	api.Post("/admin/delredemption-orders/:dempOrderID", middleware1.AdminMiddlewareHandler, redemptionOrderController.DeleteRedemptionOrder)           //删除赎回订单
+	api.Post("/admin/verify-redemption/:dempOrderID", middleware1.AdminMiddlewareHandler, redemptionOrderController.VerifyRedemptionOrder)              //链上核验赎回订单
+	api.Post("/admin/pay-redemption/:dempOrderID", middleware1.AdminMiddlewareHandler, redemptionOrderController.ApproveAndPayRedemption)               //审核并支付宝打款
 
+	api.Get("/admin/treasury", middleware1.AdminMiddlewareHandler, treasuryController.GetTreasuryDashboard)     //热钱包余额、负债和告警看板
+	api.Post("/admin/treasury/refresh", middleware1.AdminMiddlewareHandler, treasuryController.RefreshTreasury) //立即刷新热钱包快照
 
-	api.Get("/admin/sales", middleware1.AdminMiddlewareHandler, orderController.GetSales)       //展示后台销售数据
-	api.Get("/admin/visitors", middleware1.AdminMiddlewareHandler, orderController.GetVisitors) //展示后台浏览数据
+	api.Get("/admin/sales", middleware1.AdminMiddlewareHandler, orderController.GetSales)         //展示后台销售数据
+	api.Get("/admin/visitors", middleware1.AdminMiddlewareHandler, visitorController.GetVisitors) //展示后台浏览数据
 
-	api.Get("/admin/analytics/sales", middleware1.AdminMiddlewareHandler, orderController.GetSalesAnalytics)       //展示后台销售数据分析
-	api.Get("/admin/analytics/visitors", middleware1.AdminMiddlewareHandler, orderController.GetVisitorsAnalytics) //展示后台浏览数据分析
+	api.Get("/admin/analytics/sales", middleware1.AdminMiddlewareHandler, orderController.GetSalesAnalytics)         //展示后台销售数据分析
+	api.Get("/admin/analytics/visitors", middleware1.AdminMiddlewareHandler, visitorController.GetVisitorsAnalytics) //展示后台浏览数据分析
 
-	api.Get("/admin/export_orders", middleware1.AdminMiddlewareHandler, orderController.ExportOrders) //后台导出订单到excel
-	api.Get("/admin/allorders", middleware1.AdminMiddlewareHandler, orderController.GetAllOrders)     //查询所有订单
+	api.Get("/admin/export_orders", middleware1.AdminMiddlewareHandler, orderController.ExportOrders)      //后台导出订单到excel/csv
+	api.Get("/admin/export_columns", middleware1.AdminMiddlewareHandler, orderController.GetExportColumns) //导出可选列
+	api.Get("/admin/allorders", middleware1.AdminMiddlewareHandler, orderController.GetAllOrders)          //查询所有订单
 	// api.Get("/admin/paidorders", middleware1.AdminMiddlewareHandler, orderController.GetAllPaidOrder) //查询所有已支付订单
 
 	// api.Post("/admin/clear-unpaid-orders", middleware1.AdminMiddlewareHandler, orderController.ClearUnpaidOrders) //清除未支付订单
 
-	api.Post("/admin/update-ship-status", middleware1.AdminMiddlewareHandler, orderController.UpdateOrderItemShippingStatus) //更新订单发货状态
-	api.Post("/admin/update-deliver-id", middleware1.AdminMiddlewareHandler, orderController.UpdateOrderItemDeliverID)       //更新订单快递单号
-	api.Get("/admin/get-deliver-id", middleware1.AdminMiddlewareHandler, orderController.GetOrderItemDeliverID)              //获取订单快递单号
+	api.Post("/admin/update-ship-status", middleware1.AdminMiddlewareHandler, orderController.UpdateOrderItemShippingStatus)  //更新订单发货状态
+	api.Post("/admin/update-deliver-id", middleware1.AdminMiddlewareHandler, orderController.UpdateOrderItemDeliverID)        //更新订单快递单号
+	api.Get("/admin/get-deliver-id", middleware1.AdminMiddlewareHandler, orderController.GetOrderItemDeliverID)               //获取订单快递单号
+	api.Post("/admin/orders/:orderID/tracking", middleware1.AdminMiddlewareHandler, logisticsController.RefreshOrderTracking) //手动刷新订单物流
+	api.Post("/admin/import-shipments", middleware1.AdminMiddlewareHandler, orderController.ImportShipments)                  //批量导入快递单号，dry_run=true 只校验
 
 	err := app.Listen(":3000")
 	if err != nil {
@@ -0,0 +1,283 @@
+// Package media 商品图片处理：校验格式和大小、去除 EXIF、生成缩略图和 WebP，按内容哈希命名后写入存储
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+var (
+	ErrTooLarge    = errors.New("图片文件过大")
+	ErrUnsupported = errors.New("仅支持 JPEG、PNG、GIF、WebP 格式的图片")
+	ErrTooManyPx   = errors.New("图片分辨率过大")
+	ErrCorrupt     = errors.New("无法解析图片")
+)
+
+// 允许上传的格式，按文件内容判断，不信任文件名和请求头
+var decoders = map[string]func([]byte) (image.Image, error){
+	"image/jpeg": func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) },
+	"image/png":  func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) },
+	"image/gif":  func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }, // 动图只保留第一帧
+	"image/webp": func(b []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(b)) },
+}
+
+var configDecoders = map[string]func([]byte) (image.Config, error){
+	"image/jpeg": func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) },
+	"image/png":  func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) },
+	"image/gif":  func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) },
+	"image/webp": func(b []byte) (image.Config, error) { return webp.DecodeConfig(bytes.NewReader(b)) },
+}
+
+// 处理后的图片保存在存储中的目录
+const keyPrefix = "products/"
+
+// Options 图片处理参数
+type Options struct {
+	MaxSize   int64  // 单个文件的最大字节数
+	MaxPixels int    // 宽×高的上限，避免解码超大图片耗尽内存
+	MaxSide   int    // 保存的原图最长边，超出时等比缩小
+	ThumbSide int    // 缩略图最长边
+	CWebP     string // cwebp 可执行文件路径，必须配置
+}
+
+// Service 图片处理服务
+type Service struct {
+	storage Storage
+	opts    Options
+}
+
+// NewService 构造函数
+func NewService(storage Storage, opts Options) *Service {
+	return &Service{storage: storage, opts: opts}
+}
+
+// NewServiceFromEnv 从环境变量读取处理参数
+// MEDIA_MAX_SIZE_MB 默认 5，MEDIA_MAX_SIDE 默认 2048，MEDIA_THUMB_SIDE 默认 400
+// MEDIA_CWEBP 为 cwebp 的路径，未配置时从 PATH 中查找，找不到时返回错误，不允许在缺少 WebP 的情况下启动
+func NewServiceFromEnv(storage Storage) (*Service, error) {
+	opts := Options{
+		MaxSize:   int64(envInt("MEDIA_MAX_SIZE_MB", 5)) << 20,
+		MaxPixels: 40_000_000,
+		MaxSide:   envInt("MEDIA_MAX_SIDE", 2048),
+		ThumbSide: envInt("MEDIA_THUMB_SIDE", 400),
+		CWebP:     os.Getenv("MEDIA_CWEBP"),
+	}
+	if opts.CWebP == "" {
+		opts.CWebP = "cwebp"
+	}
+	p, err := exec.LookPath(opts.CWebP)
+	if err != nil {
+		return nil, fmt.Errorf("未找到 cwebp，请安装 libwebp 或通过 MEDIA_CWEBP 指定路径: %v", err)
+	}
+	opts.CWebP = p
+	return NewService(storage, opts), nil
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// Storage 返回使用的存储
+func (s *Service) Storage() Storage {
+	return s.storage
+}
+
+// MaxSize 单个文件的最大字节数
+func (s *Service) MaxSize() int64 {
+	return s.opts.MaxSize
+}
+
+// Asset 处理后的图片，各地址可直接保存到商品中
+type Asset struct {
+	Hash      string
+	URL       string
+	Thumbnail string
+	WebP      string
+	Width     int
+	Height    int
+}
+
+// Process 校验并处理上传的图片，写入原图（已去除 EXIF）、缩略图和 WebP
+// 文件名为内容哈希，同一张图片重复上传时覆盖为相同的文件
+func (s *Service) Process(ctx context.Context, data []byte) (*Asset, error) {
+	if int64(len(data)) > s.opts.MaxSize {
+		return nil, fmt.Errorf("%w: 不能超过 %dMB", ErrTooLarge, s.opts.MaxSize>>20)
+	}
+	mime := http.DetectContentType(data)
+	decode, ok := decoders[mime]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	cfg, err := configDecoders[mime](data)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > s.opts.MaxPixels {
+		return nil, ErrTooManyPx
+	}
+	img, err := decode(data)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if mime == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:16])
+
+	// 不透明的图片保存为 JPEG，带透明通道的保存为 PNG；重新编码时丢弃 EXIF 等元数据
+	ext, contentType := ".jpg", "image/jpeg"
+	if !isOpaque(img) {
+		ext, contentType = ".png", "image/png"
+	}
+	original := fit(img, s.opts.MaxSide)
+	originalData, err := encode(original, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("编码图片失败: %v", err)
+	}
+	thumbData, err := encode(fit(original, s.opts.ThumbSide), contentType)
+	if err != nil {
+		return nil, fmt.Errorf("编码缩略图失败: %v", err)
+	}
+	// 先生成 WebP 再写入存储，转换失败时整个上传失败，不留下缺少 WebP 的图片
+	webpData, err := s.toWebP(ctx, originalData, ext)
+	if err != nil {
+		return nil, fmt.Errorf("生成 WebP 图片失败: %v", err)
+	}
+
+	asset := &Asset{Hash: hash, Width: original.Bounds().Dx(), Height: original.Bounds().Dy()}
+	originalKey := keyPrefix + hash + ext
+	thumbKey := keyPrefix + hash + "_thumb" + ext
+	webpKey := keyPrefix + hash + ".webp"
+	if err := s.storage.Put(ctx, originalKey, originalData, contentType); err != nil {
+		return nil, fmt.Errorf("保存图片失败: %v", err)
+	}
+	if err := s.storage.Put(ctx, thumbKey, thumbData, contentType); err != nil {
+		return nil, fmt.Errorf("保存缩略图失败: %v", err)
+	}
+	if err := s.storage.Put(ctx, webpKey, webpData, "image/webp"); err != nil {
+		return nil, fmt.Errorf("保存 WebP 图片失败: %v", err)
+	}
+	asset.URL = s.storage.URL(originalKey)
+	asset.Thumbnail = s.storage.URL(thumbKey)
+	asset.WebP = s.storage.URL(webpKey)
+	return asset, nil
+}
+
+// 判断图片是否不透明
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// 等比缩小到最长边不超过 side，小图不放大
+func fit(img image.Image, side int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if side <= 0 || (w <= side && h <= side) {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*side/w)
+		w = side
+	} else {
+		w = max(1, w*side/h)
+		h = side
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 88})
+	}
+	return buf.Bytes(), err
+}
+
+// 调用 cwebp 转换为 WebP
+func (s *Service) toWebP(ctx context.Context, data []byte, ext string) ([]byte, error) {
+	if s.opts.CWebP == "" {
+		return nil, errors.New("未配置 cwebp")
+	}
+	dir, err := os.MkdirTemp("", "media-webp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in"+ext)
+	out := filepath.Join(dir, "out.webp")
+	if err := os.WriteFile(in, data, 0600); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.opts.CWebP, "-quiet", "-metadata", "none", "-q", "80", in, "-o", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return os.ReadFile(out)
+}
+
+// GCResult 清理未引用文件的结果
+type GCResult struct {
+	Scanned int      `json:"scanned"`
+	Removed []string `json:"removed"`
+	Failed  []string `json:"failed,omitempty"`
+	Bytes   int64    `json:"bytes"` // 释放的空间
+}
+
+// CollectGarbage 删除存储中未被引用的文件，referenced 为引用中的 Key
+// 修改时间在 grace 之内的文件不删除，避免误删刚上传、商品尚未保存的图片；dryRun 时只列出不删除
+func (s *Service) CollectGarbage(ctx context.Context, referenced map[string]bool, grace time.Duration, dryRun bool) (*GCResult, error) {
+	objects, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("列出图片文件失败: %v", err)
+	}
+	result := &GCResult{Scanned: len(objects), Removed: []string{}}
+	cutoff := time.Now().Add(-grace)
+	for _, obj := range objects {
+		if referenced[obj.Key] || obj.ModTime.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := s.storage.Delete(ctx, obj.Key); err != nil {
+				log.Printf("删除未引用的图片失败 (%s): %v", obj.Key, err)
+				result.Failed = append(result.Failed, obj.Key)
+				continue
+			}
+		}
+		result.Removed = append(result.Removed, obj.Key)
+		result.Bytes += obj.Size
+	}
+	return result, nil
+}
@@ -0,0 +1,459 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 模拟 cwebp：把 -o 之后的路径写成一个最小的 WebP 文件头
+const fakeCWebP = `#!/bin/sh
+while [ $# -gt 1 ]; do
+	if [ "$1" = "-o" ]; then
+		printf 'RIFF\000\000\000\000WEBP' > "$2"
+		exit 0
+	fi
+	shift
+done
+exit 1
+`
+
+const brokenCWebP = `#!/bin/sh
+echo "cannot encode" >&2
+exit 1
+`
+
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "cwebp")
+	if err := os.WriteFile(p, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testOptions(t *testing.T) Options {
+	return Options{
+		MaxSize:   1 << 20,
+		MaxPixels: 1_000_000,
+		MaxSide:   2048,
+		ThumbSide: 400,
+		CWebP:     writeScript(t, fakeCWebP),
+	}
+}
+
+func newTestService(t *testing.T, opts Options) (*Service, *LocalStorage) {
+	t.Helper()
+	storage := NewLocalStorage(t.TempDir(), "upload")
+	return NewService(storage, opts), storage
+}
+
+func storedKeys(t *testing.T, storage *LocalStorage) []string {
+	t.Helper()
+	objects, err := storage.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func readStored(t *testing.T, storage *LocalStorage, key string) []byte {
+	t.Helper()
+	p, err := storage.path(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// 左半边红色、右半边蓝色的图片，用于判断旋转方向
+func twoColorImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 在 JPEG 的 SOI 之后插入带方向标记的 EXIF，extra 附加在 TIFF 数据末尾，模拟 GPS 等需要去除的信息
+func withExif(data []byte, orientation uint16, extra string) []byte {
+	tiff := []byte("MM\x00\x2A")
+	tiff = binary.BigEndian.AppendUint32(tiff, 8)
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)      // 1 个条目
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112) // Orientation
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)      // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // 填充和下一个 IFD 的偏移
+	tiff = append(tiff, extra...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+// 修改 PNG 头中的宽高并重新计算校验和，得到声称超大分辨率的文件
+func withPNGSize(data []byte, w, h uint32) []byte {
+	out := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(out[16:], w)
+	binary.BigEndian.PutUint32(out[20:], h)
+	binary.BigEndian.PutUint32(out[29:], crc32.ChecksumIEEE(out[12:29]))
+	return out
+}
+
+func TestProcessRejects(t *testing.T) {
+	valid := encodeJPEG(t, twoColorImage(20, 20))
+	cases := []struct {
+		name string
+		data []byte
+		opts func(*Options)
+		want error
+	}{
+		{"too large", valid, func(o *Options) { o.MaxSize = int64(len(valid) - 1) }, ErrTooLarge},
+		{"html", []byte("<html><body>not an image</body></html>"), nil, ErrUnsupported},
+		{"bmp", append([]byte("BM"), make([]byte, 64)...), nil, ErrUnsupported},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), nil, ErrUnsupported},
+		{"truncated jpeg", valid[:len(valid)/2], nil, ErrCorrupt},
+		{"gif header only", []byte("GIF89a"), nil, ErrCorrupt},
+		{"too many pixels", valid, func(o *Options) { o.MaxPixels = 20*20 - 1 }, ErrTooManyPx},
+		{"pixel bomb", withPNGSize(encodePNG(t, twoColorImage(1, 1)), 100_000, 100_000), nil, ErrTooManyPx},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := testOptions(t)
+			if tc.opts != nil {
+				tc.opts(&opts)
+			}
+			s, storage := newTestService(t, opts)
+			asset, err := s.Process(context.Background(), tc.data)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("Process = %+v, %v; want %v", asset, err, tc.want)
+			}
+			if keys := storedKeys(t, storage); len(keys) != 0 {
+				t.Errorf("rejected upload stored files: %v", keys)
+			}
+		})
+	}
+}
+
+func TestProcessSniffsContentNotName(t *testing.T) {
+	// 文件内容是 PNG 时按 PNG 解码，与文件名和请求头无关
+	s, _ := newTestService(t, testOptions(t))
+	asset, err := s.Process(context.Background(), encodePNG(t, twoColorImage(30, 10)))
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if asset.Width != 30 || asset.Height != 10 {
+		t.Errorf("size = %dx%d, want 30x10", asset.Width, asset.Height)
+	}
+}
+
+func TestProcessHashNaming(t *testing.T) {
+	s, storage := newTestService(t, testOptions(t))
+	data := encodeJPEG(t, twoColorImage(40, 20))
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:16])
+
+	asset, err := s.Process(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if asset.Hash != hash {
+		t.Errorf("Hash = %q, want %q", asset.Hash, hash)
+	}
+	if want := "upload/products/" + hash + ".jpg"; asset.URL != want {
+		t.Errorf("URL = %q, want %q", asset.URL, want)
+	}
+	if want := "upload/products/" + hash + "_thumb.jpg"; asset.Thumbnail != want {
+		t.Errorf("Thumbnail = %q, want %q", asset.Thumbnail, want)
+	}
+	if want := "upload/products/" + hash + ".webp"; asset.WebP != want {
+		t.Errorf("WebP = %q, want %q", asset.WebP, want)
+	}
+
+	// 重复上传同一张图片得到相同的文件，不会产生新文件
+	again, err := s.Process(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Process again: %v", err)
+	}
+	if *again != *asset {
+		t.Errorf("second upload = %+v, want %+v", again, asset)
+	}
+	want := []string{
+		"products/" + hash + ".jpg",
+		"products/" + hash + ".webp",
+		"products/" + hash + "_thumb.jpg",
+	}
+	if keys := storedKeys(t, storage); len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] || keys[2] != want[2] {
+		t.Errorf("stored keys = %v, want %v", keys, want)
+	}
+	if webp := readStored(t, storage, want[1]); !bytes.HasPrefix(webp, []byte("RIFF")) {
+		t.Errorf("webp file = %q", webp)
+	}
+}
+
+func TestProcessTransparentKeepsPNG(t *testing.T) {
+	s, storage := newTestService(t, testOptions(t))
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	asset, err := s.Process(context.Background(), encodePNG(t, img))
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if want := "upload/products/" + asset.Hash + ".png"; asset.URL != want {
+		t.Errorf("URL = %q, want %q", asset.URL, want)
+	}
+	if _, err := png.Decode(bytes.NewReader(readStored(t, storage, "products/"+asset.Hash+"_thumb.png"))); err != nil {
+		t.Errorf("thumbnail is not a PNG: %v", err)
+	}
+}
+
+func TestProcessResizes(t *testing.T) {
+	opts := testOptions(t)
+	opts.MaxSide, opts.ThumbSide = 100, 50
+	s, storage := newTestService(t, opts)
+	asset, err := s.Process(context.Background(), encodeJPEG(t, twoColorImage(400, 200)))
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if asset.Width != 100 || asset.Height != 50 {
+		t.Errorf("original = %dx%d, want 100x50", asset.Width, asset.Height)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(readStored(t, storage, "products/"+asset.Hash+"_thumb.jpg")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 50 || cfg.Height != 25 {
+		t.Errorf("thumbnail = %dx%d, want 50x25", cfg.Width, cfg.Height)
+	}
+}
+
+func TestProcessExifOrientationAndStripping(t *testing.T) {
+	s, storage := newTestService(t, testOptions(t))
+	// 方向 6：需要顺时针旋转 90 度，原图左边的红色应在上方
+	data := withExif(encodeJPEG(t, twoColorImage(40, 20)), 6, "GPS 31.2304N 121.4737E")
+	if got := jpegOrientation(data); got != 6 {
+		t.Fatalf("jpegOrientation = %d, want 6", got)
+	}
+
+	asset, err := s.Process(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if asset.Width != 20 || asset.Height != 40 {
+		t.Errorf("size = %dx%d, want 20x40", asset.Width, asset.Height)
+	}
+
+	stored := readStored(t, storage, "products/"+asset.Hash+".jpg")
+	if bytes.Contains(stored, []byte("Exif")) || bytes.Contains(stored, []byte("GPS")) {
+		t.Error("stored image still contains EXIF data")
+	}
+	if got := jpegOrientation(stored); got != 1 {
+		t.Errorf("stored orientation = %d, want 1", got)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isRed := func(x, y int) bool {
+		r, _, b, _ := img.At(x, y).RGBA()
+		return r > 0xC000 && b < 0x4000
+	}
+	if !isRed(10, 5) || isRed(10, 35) {
+		t.Error("image was not rotated according to EXIF orientation")
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 2x1 的图片，左红右蓝，检查各方向变换后红色像素的位置
+	src := twoColorImage(2, 1)
+	cases := []struct {
+		orientation int
+		w, h        int
+		redX, redY  int
+	}{
+		{1, 2, 1, 0, 0},
+		{2, 2, 1, 1, 0},
+		{3, 2, 1, 1, 0},
+		{4, 2, 1, 0, 0},
+		{5, 1, 2, 0, 0},
+		{6, 1, 2, 0, 0},
+		{7, 1, 2, 0, 1},
+		{8, 1, 2, 0, 1},
+	}
+	for _, tc := range cases {
+		out := applyOrientation(src, tc.orientation)
+		b := out.Bounds()
+		if b.Dx() != tc.w || b.Dy() != tc.h {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", tc.orientation, b.Dx(), b.Dy(), tc.w, tc.h)
+			continue
+		}
+		if r, _, _, _ := out.At(tc.redX, tc.redY).RGBA(); r != 0xFFFF {
+			t.Errorf("orientation %d: pixel (%d,%d) is not red", tc.orientation, tc.redX, tc.redY)
+		}
+	}
+}
+
+func TestProcessFailsWithoutWebP(t *testing.T) {
+	opts := testOptions(t)
+	opts.CWebP = writeScript(t, brokenCWebP)
+	s, storage := newTestService(t, opts)
+	if _, err := s.Process(context.Background(), encodeJPEG(t, twoColorImage(20, 20))); err == nil {
+		t.Fatal("Process succeeded although cwebp failed")
+	}
+	if keys := storedKeys(t, storage); len(keys) != 0 {
+		t.Errorf("failed upload stored files: %v", keys)
+	}
+
+	opts.CWebP = ""
+	s, _ = newTestService(t, opts)
+	if _, err := s.Process(context.Background(), encodeJPEG(t, twoColorImage(20, 20))); err == nil {
+		t.Fatal("Process succeeded without cwebp")
+	}
+}
+
+func TestNewServiceFromEnvRequiresCWebP(t *testing.T) {
+	storage := NewLocalStorage(t.TempDir(), "upload")
+
+	t.Setenv("MEDIA_CWEBP", filepath.Join(t.TempDir(), "missing-cwebp"))
+	if _, err := NewServiceFromEnv(storage); err == nil {
+		t.Error("missing MEDIA_CWEBP accepted")
+	}
+
+	t.Setenv("MEDIA_CWEBP", "")
+	t.Setenv("PATH", t.TempDir())
+	if _, err := NewServiceFromEnv(storage); err == nil {
+		t.Error("started without cwebp in PATH")
+	}
+
+	fake := writeScript(t, fakeCWebP)
+	t.Setenv("MEDIA_CWEBP", fake)
+	s, err := NewServiceFromEnv(storage)
+	if err != nil {
+		t.Fatalf("NewServiceFromEnv: %v", err)
+	}
+	if s.opts.CWebP != fake {
+		t.Errorf("CWebP = %q, want %q", s.opts.CWebP, fake)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	s, storage := newTestService(t, testOptions(t))
+	ctx := context.Background()
+	old := time.Now().Add(-2 * time.Hour)
+	files := map[string]time.Time{
+		"products/referenced.jpg": old,
+		"products/orphan.jpg":     old,
+		"products/orphan.webp":    old,
+		"products/fresh.jpg":      time.Now(), // 刚上传，商品尚未保存
+	}
+	for key, modTime := range files {
+		if err := storage.Put(ctx, key, []byte(key), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+		p, _ := storage.path(key)
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	referenced := map[string]bool{"products/referenced.jpg": true}
+	orphans := []string{"products/orphan.jpg", "products/orphan.webp"}
+	orphanBytes := int64(len(orphans[0]) + len(orphans[1]))
+
+	check := func(result *GCResult) {
+		t.Helper()
+		removed := append([]string{}, result.Removed...)
+		sort.Strings(removed)
+		if result.Scanned != 4 || len(removed) != 2 || removed[0] != orphans[0] || removed[1] != orphans[1] {
+			t.Errorf("result = %+v, want scanned 4 and removed %v", result, orphans)
+		}
+		if result.Bytes != orphanBytes {
+			t.Errorf("Bytes = %d, want %d", result.Bytes, orphanBytes)
+		}
+	}
+
+	// 试运行只列出，不删除任何文件
+	result, err := s.CollectGarbage(ctx, referenced, time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(result)
+	if keys := storedKeys(t, storage); len(keys) != 4 {
+		t.Errorf("dry run removed files, left %v", keys)
+	}
+
+	result, err = s.CollectGarbage(ctx, referenced, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(result)
+	keys := storedKeys(t, storage)
+	if len(keys) != 2 || keys[0] != "products/fresh.jpg" || keys[1] != "products/referenced.jpg" {
+		t.Errorf("remaining keys = %v, want fresh and referenced", keys)
+	}
+
+	// 宽限期足够长时，未引用的旧文件也保留
+	if err := storage.Put(ctx, "products/orphan.jpg", []byte("x"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := storage.path("products/orphan.jpg")
+	os.Chtimes(p, old, old)
+	result, err = s.CollectGarbage(ctx, referenced, 3*time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 0 {
+		t.Errorf("removed within grace period: %v", result.Removed)
+	}
+}
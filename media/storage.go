@@ -0,0 +1,146 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Object 存储中的一个文件
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage 图片存储，Key 为使用 / 分隔的相对路径
+// 目前使用本地磁盘，之后可以替换为 S3 兼容的对象存储
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context) ([]Object, error)
+	// URL 返回保存到商品中的访问地址，KeyFromURL 为其逆操作，不属于该存储的地址返回 false
+	URL(key string) string
+	KeyFromURL(url string) (string, bool)
+}
+
+// LocalStorage 保存在本地目录，通过 app.Static 或 nginx 对外提供访问
+type LocalStorage struct {
+	dir       string
+	urlPrefix string
+}
+
+// NewLocalStorage 构造函数，urlPrefix 为访问地址的前缀，与旧数据保持一致时为 "upload"
+func NewLocalStorage(dir, urlPrefix string) *LocalStorage {
+	return &LocalStorage{dir: dir, urlPrefix: strings.TrimSuffix(urlPrefix, "/")}
+}
+
+// NewStorageFromEnv 根据 MEDIA_STORAGE 选择存储，目前只支持 local（默认）
+// 本地存储目录为 MEDIA_LOCAL_DIR，默认 upload
+func NewStorageFromEnv() (Storage, error) {
+	switch driver := os.Getenv("MEDIA_STORAGE"); driver {
+	case "", "local":
+		dir := os.Getenv("MEDIA_LOCAL_DIR")
+		if dir == "" {
+			dir = "upload"
+		}
+		return NewLocalStorage(dir, "upload"), nil
+	default:
+		return nil, fmt.Errorf("不支持的图片存储: %s", driver)
+	}
+}
+
+// 校验 Key 并转换为本地路径，防止通过 .. 访问存储目录之外的文件
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if clean == "" || clean != key {
+		return "", fmt.Errorf("无效的文件名: %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免并发请求读到写了一半的图片
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == s.dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return ctx.Err()
+	})
+	return objects, err
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.urlPrefix + "/" + key
+}
+
+// 旧数据中的地址为 filepath.Join("upload", 文件名)，也可能带有开头的 /
+func (s *LocalStorage) KeyFromURL(url string) (string, bool) {
+	url = strings.TrimPrefix(filepath.ToSlash(url), "/")
+	key, ok := strings.CutPrefix(url, s.urlPrefix+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
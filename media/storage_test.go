@@ -0,0 +1,84 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoragePathRejectsTraversal(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "upload")
+	for _, key := range []string{
+		"",
+		"..",
+		"../secret",
+		"products/../../secret",
+		"products/../a.jpg", // 清理后与原 Key 不同
+		"/etc/passwd",
+		"./a.jpg",
+		"products//a.jpg",
+		"products/",
+	} {
+		if p, err := s.path(key); err == nil {
+			t.Errorf("path(%q) = %q, want error", key, p)
+		}
+	}
+
+	p, err := s.path("products/a.jpg")
+	if err != nil {
+		t.Fatalf("path(products/a.jpg): %v", err)
+	}
+	if want := filepath.Join(s.dir, "products", "a.jpg"); p != want {
+		t.Errorf("path(products/a.jpg) = %q, want %q", p, want)
+	}
+}
+
+func TestLocalStoragePutOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	s := NewLocalStorage(filepath.Join(root, "upload"), "upload")
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "../escaped.jpg", []byte("x"), "image/jpeg"); err == nil {
+		t.Error("Put(../escaped.jpg) succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.jpg")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the storage dir: %v", err)
+	}
+
+	outside := filepath.Join(root, "keep.txt")
+	if err := os.WriteFile(outside, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "../keep.txt"); err == nil {
+		t.Error("Delete(../keep.txt) succeeded")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the storage dir removed: %v", err)
+	}
+}
+
+func TestLocalStorageKeyFromURL(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "upload")
+	cases := []struct {
+		url string
+		key string
+		ok  bool
+	}{
+		{"upload/products/a.jpg", "products/a.jpg", true},
+		{"/upload/products/a.jpg", "products/a.jpg", true},
+		{"upload/a.jpg", "a.jpg", true},
+		{"upload/", "", false},
+		{"https://cdn.example.com/a.jpg", "", false},
+		{"uploads/a.jpg", "", false},
+	}
+	for _, tc := range cases {
+		key, ok := s.KeyFromURL(tc.url)
+		if key != tc.key || ok != tc.ok {
+			t.Errorf("KeyFromURL(%q) = %q, %v; want %q, %v", tc.url, key, ok, tc.key, tc.ok)
+		}
+	}
+	if key, _ := s.KeyFromURL(s.URL("products/a.jpg")); key != "products/a.jpg" {
+		t.Errorf("KeyFromURL(URL(products/a.jpg)) = %q", key)
+	}
+}
@@ -28,3 +28,17 @@ func (sm *SecurityMiddleware) RateLimiter() fiber.Handler {
 		},
 	})
 }
+
+// BeaconLimiter 前端统计上报的速率限制，比业务接口宽松
+func (sm *SecurityMiddleware) BeaconLimiter() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        60,
+		Expiration: 1 * time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusTooManyRequests)
+		},
+	})
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 访客标识 Cookie 名称
+const VisitorCookie = "vid"
+
+// VisitorID 为每个访客分配匿名标识，保存在 Cookie 中并写入 c.Locals("visitor_id")
+func VisitorID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		vid := c.Cookies(VisitorCookie)
+		if len(vid) != 32 {
+			buf := make([]byte, 16)
+			if _, err := rand.Read(buf); err == nil {
+				vid = hex.EncodeToString(buf)
+				c.Cookie(&fiber.Cookie{
+					Name:     VisitorCookie,
+					Value:    vid,
+					Expires:  time.Now().AddDate(1, 0, 0),
+					HTTPOnly: true,
+					SameSite: "Lax",
+				})
+			} else {
+				vid = ""
+			}
+		}
+		c.Locals("visitor_id", vid)
+		return c.Next()
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BaseCurrency 结算货币，订单金额和支付宝支付都使用人民币，其他货币只用于展示
+const BaseCurrency = "CNY"
+
+// 汇率来源
+const (
+	FxRateManual = "manual" // 管理员手动录入
+	FxRateImport = "import" // 文件导入
+)
+
+// Currency 可供前台选择的展示货币
+type Currency struct {
+	Code      string    `bson:"_id" json:"code"` // ISO 4217 代码，如 USD
+	Name      string    `bson:"name" json:"name"`
+	Symbol    string    `bson:"symbol" json:"symbol"`
+	Decimals  int       `bson:"decimals" json:"decimals"` // 展示的小数位数，如 JPY 为 0
+	Enabled   bool      `bson:"enabled" json:"enabled"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// FxRate 汇率记录，Rate 为 1 元人民币兑换的外币数量
+// 每次调整都新增一条记录，同一货币生效时间不晚于当前的最新一条为当前汇率
+type FxRate struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Currency    string             `bson:"currency" json:"currency"`
+	Rate        float64            `bson:"rate" json:"rate"`
+	Source      string             `bson:"source" json:"source"` // manual / import
+	EffectiveAt time.Time          `bson:"effective_at" json:"effective_at"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// OrderFxRate 下单时使用的展示汇率，订单仍以人民币金额结算
+type OrderFxRate struct {
+	Currency     string             `bson:"currency" json:"currency"`
+	Rate         float64            `bson:"rate" json:"rate"`
+	RateRef      primitive.ObjectID `bson:"rate_ref" json:"rate_ref"`           // 使用的汇率记录
+	DisplayTotal string             `bson:"display_total" json:"display_total"` // 应付金额按该汇率换算的外币金额
+}
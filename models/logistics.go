@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 物流轨迹状态，由各物流服务商的状态归一化而来
+const (
+	TrackingCollected  = "collected"  // 已揽收
+	TrackingInTransit  = "in_transit" // 运输中
+	TrackingDelivering = "delivering" // 派送中
+	TrackingDelivered  = "delivered"  // 已签收
+	TrackingException  = "exception"  // 疑难件、拒签等异常
+	TrackingReturned   = "returned"   // 退回
+)
+
+// TrackingEvent 一条物流轨迹，按快递公司和单号归属包裹，同一包裹内的商品共享轨迹
+type TrackingEvent struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Carrier     string             `bson:"carrier" json:"carrier"`       // 快递公司编码
+	DeliverID   string             `bson:"deliver_id" json:"deliver_id"` // 快递单号
+	Status      string             `bson:"status" json:"status"`
+	Description string             `bson:"description" json:"description"`
+	Location    string             `bson:"location" json:"location"`
+	EventTime   time.Time          `bson:"event_time" json:"event_time"`
+	Source      string             `bson:"source" json:"source"` // 数据来源：poll / push
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
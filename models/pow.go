@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 权证规则类型
+const (
+	PowRuleMultiplier = "multiplier"  // 倍率规则，可限定商品/分类，可设置活动时间段
+	PowRuleFirstOrder = "first_order" // 首单奖励
+)
+
+// 订单权证发放状态
+const (
+	PowAwardLocked = "locked" // 锁定中，退货期结束后才可提现
+	PowAwardVested = "vested" // 已解锁，计入可提现余额
+)
+
+// 订单权证入账状态，先随订单保存为待入账，加到用户余额后再标记为已入账
+const (
+	PowCreditPending  = "pending"  // 待入账，由定时任务重试
+	PowCreditCredited = "credited" // 已加到用户余额
+)
+
+// PowConfig 权证发放全局配置，集合中只保存一份
+type PowConfig struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BaseRate    float64            `bson:"base_rate" json:"base_rate"`         // 基础倍率：每消费1元获得的 Pow
+	MaxPerOrder float64            `bson:"max_per_order" json:"max_per_order"` // 单笔订单上限，0 表示不限
+	MaxPerDay   float64            `bson:"max_per_day" json:"max_per_day"`     // 单个用户每日上限，0 表示不限
+	VestingDays int                `bson:"vesting_days" json:"vesting_days"`   // 锁定天数（退货期），0 表示立即可提现
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// PowRule 管理员配置的权证发放规则
+type PowRule struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name         string               `bson:"name" json:"name"`
+	Type         string               `bson:"type" json:"type"`                   // multiplier / first_order
+	Multiplier   float64              `bson:"multiplier" json:"multiplier"`       // 倍率规则使用
+	Bonus        float64              `bson:"bonus" json:"bonus"`                 // 首单奖励使用
+	ProductRefs  []primitive.ObjectID `bson:"product_refs" json:"product_refs"`   // 限定商品，为空表示不限
+	CategoryRefs []primitive.ObjectID `bson:"category_refs" json:"category_refs"` // 限定分类，为空表示不限
+	StartAt      time.Time            `bson:"start_at" json:"start_at"`           // 活动开始时间，零值表示不限
+	EndAt        time.Time            `bson:"end_at" json:"end_at"`               // 活动结束时间，零值表示不限
+	Enabled      bool                 `bson:"enabled" json:"enabled"`
+	CreatedAt    time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// PowAward 订单实际发放的权证，保存在订单上用于审计
+type PowAward struct {
+	Amount    float64          `bson:"amount" json:"amount"`                     // 最终发放数量
+	BaseRate  float64          `bson:"base_rate" json:"base_rate"`               // 计算时的基础倍率
+	Capped    bool             `bson:"capped" json:"capped"`                     // 是否触发了上限
+	Rules     []AppliedPowRule `bson:"rules" json:"rules"`                       // 命中的规则明细
+	Status    string           `bson:"status" json:"status"`                     // locked / vested
+	Credit    string           `bson:"credit,omitempty" json:"credit,omitempty"` // pending / credited，旧记录为空表示已入账
+	VestAt    time.Time        `bson:"vest_at" json:"vest_at"`                   // 解锁时间
+	AwardedAt time.Time        `bson:"awarded_at" json:"awarded_at"`
+}
+
+// AppliedPowRule 单条命中规则的快照
+type AppliedPowRule struct {
+	RuleRef    primitive.ObjectID `bson:"rule_ref" json:"rule_ref"`
+	Name       string             `bson:"name" json:"name"`
+	Type       string             `bson:"type" json:"type"`
+	ProductRef primitive.ObjectID `bson:"product_ref,omitempty" json:"product_ref,omitempty"` // 倍率规则作用的商品
+	Multiplier float64            `bson:"multiplier,omitempty" json:"multiplier,omitempty"`
+	Bonus      float64            `bson:"bonus,omitempty" json:"bonus,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 商品修改记录的操作类型
+const (
+	RevisionCreate   = "create"   // 新建商品
+	RevisionUpdate   = "update"   // 编辑商品信息、库存或分类
+	RevisionStatus   = "status"   // 发布、下架、归档，包括定时下架
+	RevisionImages   = "images"   // 上传、删除、排序图片等
+	RevisionShipping = "shipping" // 指定或解除运费模板
+	RevisionRestore  = "restore"  // 恢复到历史版本
+)
+
+// ProductRevision 商品的一次修改记录
+type ProductRevision struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductRef   primitive.ObjectID  `bson:"product_ref" json:"product_ref"`
+	Version      int64               `bson:"version" json:"version"` // 修改后的版本号
+	Action       string              `bson:"action" json:"action"`
+	AdminRef     primitive.ObjectID  `bson:"admin_ref" json:"admin_ref"`                             // 操作的管理员，定时任务等系统操作为空
+	Changes      []FieldChange       `bson:"changes" json:"changes"`                                 // 新建商品时为空
+	Snapshot     *Product            `bson:"snapshot,omitempty" json:"snapshot,omitempty"`           // 修改后的完整商品，列表中不返回
+	RestoredFrom *primitive.ObjectID `bson:"restored_from,omitempty" json:"restored_from,omitempty"` // 恢复操作使用的历史记录
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// FieldChange 字段修改前后的值，字段名与商品接口的 JSON 字段一致
+type FieldChange struct {
+	Field string      `bson:"field" json:"field"`
+	From  interface{} `bson:"from" json:"from"`
+	To    interface{} `bson:"to" json:"to"`
+}
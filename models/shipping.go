@@ -0,0 +1,39 @@
+package models
+
+import (
+	"blog-auth-server/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 运费模板计费方式
+const (
+	ShippingFeeFlat   = "flat"   // 固定运费
+	ShippingFeeWeight = "weight" // 按重量（克）
+	ShippingFeeCount  = "count"  // 按件数
+)
+
+// ShippingTemplate 管理员配置的运费模板，商品通过 shipping_template_ref 关联
+// 按重量或件数计费时，FirstUnits 以内收 FirstFee，超出部分每 StepUnits 加收 StepFee；固定运费只使用 FirstFee
+type ShippingTemplate struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Type       string             `bson:"type" json:"type"`               // flat / weight / count
+	FirstUnits int                `bson:"first_units" json:"first_units"` // 首重（克）或首件数
+	FirstFee   money.Fen          `bson:"first_fee" json:"first_fee"`
+	StepUnits  int                `bson:"step_units" json:"step_units"` // 续重（克）或续件数
+	StepFee    money.Fen          `bson:"step_fee" json:"step_fee"`
+	FreeOver   money.Fen          `bson:"free_over" json:"free_over"`   // 商品金额满多少包邮，0 表示不包邮
+	Surcharges []RegionSurcharge  `bson:"surcharges" json:"surcharges"` // 按收件省份加收，包邮时仍然收取
+	IsDefault  bool               `bson:"is_default" json:"is_default"` // 未关联模板的商品使用默认模板
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	AmountUnit string             `bson:"amount_unit,omitempty" json:"-"` // 金额单位，见 AmountUnitFen
+}
+
+// RegionSurcharge 偏远地区加收的运费
+type RegionSurcharge struct {
+	ProvinceCode string    `bson:"province_code" json:"province_code"` // GB/T 2260 省级代码
+	Fee          money.Fen `bson:"fee" json:"fee"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 热钱包资产
+const (
+	AssetSOL = "SOL"
+	AssetSCL = "SCL"
+)
+
+// 金库告警类型
+const (
+	TreasuryAlertLowSOL       = "low_sol"       // SOL 余额低于阈值，无法支付手续费和新账户租金
+	TreasuryAlertLowSCL       = "low_scl"       // SCL 余额低于阈值
+	TreasuryAlertUnderCovered = "under_covered" // SCL 余额不足以覆盖用户待提现权证
+)
+
+// TreasurySnapshot 热钱包余额快照
+type TreasurySnapshot struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Wallet        string             `bson:"wallet" json:"wallet"`
+	SOLBalance    float64            `bson:"sol_balance" json:"sol_balance"`
+	SCLBalance    float64            `bson:"scl_balance" json:"scl_balance"`
+	PowLiability  float64            `bson:"pow_liability" json:"pow_liability"`     // 用户待提现权证总额（users.pow 与 pow_escrowed 之和）
+	PowEscrowed   float64            `bson:"pow_escrowed" json:"pow_escrowed"`       // 其中赎回处理中冻结的权证
+	PowLocked     float64            `bson:"pow_locked" json:"pow_locked"`           // 锁定中的权证总额，解锁后转为待提现
+	Coverage      float64            `bson:"coverage" json:"coverage"`               // SCL 余额 / 待提现权证，负债为 0 时为 0
+	SOLOutflow24h float64            `bson:"sol_outflow_24h" json:"sol_outflow_24h"` // 最近24小时 SOL 流出
+	SCLOutflow24h float64            `bson:"scl_outflow_24h" json:"scl_outflow_24h"` // 最近24小时 SCL 流出
+	Alerts        []string           `bson:"alerts" json:"alerts"`                   // 本次快照触发的告警类型
+	Error         string             `bson:"error,omitempty" json:"error,omitempty"` // 链上余额查询失败原因
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// TreasuryOutflow 热钱包每一笔转出记录
+type TreasuryOutflow struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Asset     string             `bson:"asset" json:"asset"` // SOL / SCL
+	Amount    float64            `bson:"amount" json:"amount"`
+	UserRef   primitive.ObjectID `bson:"user_ref,omitempty" json:"user_ref,omitempty"`
+	ToWallet  string             `bson:"to_wallet" json:"to_wallet"`
+	Reason    string             `bson:"reason" json:"reason"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// TreasuryAlert 金库告警记录
+type TreasuryAlert struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type        string             `bson:"type" json:"type"`
+	Asset       string             `bson:"asset" json:"asset"`
+	Balance     float64            `bson:"balance" json:"balance"`
+	Threshold   float64            `bson:"threshold" json:"threshold"`
+	Message     string             `bson:"message" json:"message"`
+	Notified    bool               `bson:"notified" json:"notified"`
+	NotifyError string             `bson:"notify_error,omitempty" json:"notify_error,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
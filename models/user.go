@@ -1,6 +1,8 @@
 package models
 
 import (
+	"blog-auth-server/money"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,8 +13,10 @@ type User struct {
 	Email       string             `json:"email" bson:"email"`       // 用户邮箱，可以登录
 	Password    string             `json:"password" bson:"password"` // 用户电话，可以登录
 	Phone       string             `json:"phone" bson:"phone"`
-	Pow         float64            `json:"pow" bson:"pow"`         //权证数量
-	PowAddress  string             `json:"powaddr" bson:"powaddr"` //权证地址
+	Pow         float64            `json:"pow" bson:"pow"`                   //权证数量
+	PowLocked   float64            `json:"pow_locked" bson:"pow_locked"`     //锁定中的权证，退货期后转入 Pow
+	PowEscrowed float64            `json:"pow_escrowed" bson:"pow_escrowed"` //赎回处理中冻结的权证
+	PowAddress  string             `json:"powaddr" bson:"powaddr"`           //权证地址
 	Permissions Permissions        `json:"permissions" bson:"permissions"`
 	CreatedAt   time.Time          `json:"created_at"`
 	Updated_At  time.Time          `json:"updated_at"`
@@ -25,15 +29,24 @@ type Address struct {
 }
 
 type AddressItem struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	Phone     string             `json:"phone" bson:"phone"`
-	FirstName string             `json:"first_name"`
-	LastName  string             `json:"last_name"`
-	Street    string             `json:"street"`
-	City      string             `json:"city"`
-	State     string             `json:"state"`
-	ZipCode   string             `json:"zip_code"`
-	IsDefault bool               `json:"is_default"`
+	ID            primitive.ObjectID `bson:"_id"`
+	RecipientName string             `json:"recipient_name" bson:"recipient_name"` // 收件人姓名
+	Phone         string             `json:"phone" bson:"phone"`                   // 收件人手机号
+	ProvinceCode  string             `json:"province_code" bson:"province_code"`   // GB/T 2260 省级代码
+	Province      string             `json:"province" bson:"province"`
+	CityCode      string             `json:"city_code" bson:"city_code"` // 地级代码
+	City          string             `json:"city"`
+	DistrictCode  string             `json:"district_code" bson:"district_code"` // 县级代码
+	District      string             `json:"district" bson:"district"`
+	Detail        string             `json:"detail" bson:"detail"` // 街道、门牌号等详细地址
+	ZipCode       string             `json:"zip_code"`
+	IsDefault     bool               `json:"is_default"`
+	NeedsReview   bool               `json:"needs_review" bson:"needs_review"` // 旧地址迁移时无法识别省市，需要用户重新选择
+	// 旧版的自由文本字段，迁移后保留原值，用户修改地址时清除
+	FirstName string `json:"first_name,omitempty" bson:"firstname,omitempty"`
+	LastName  string `json:"last_name,omitempty" bson:"lastname,omitempty"`
+	Street    string `json:"street,omitempty" bson:"street,omitempty"`
+	State     string `json:"state,omitempty" bson:"state,omitempty"`
 }
 
 type Orders struct {
@@ -41,37 +54,147 @@ type Orders struct {
 	UserRef            primitive.ObjectID `bson:"user_ref" json:"user_ref"`               // 关联的用户ID
 	AlipayTradeNo      string             `bson:"alipay_trade_no" json:"alipay_trade_no"` // 支付宝交易号
 	OrderItems         []OrderItem        `bson:"items" json:"items"`
-	TotalPrice         uint64             `bson:"total_price" json:"total_price"`
-	Discount           int                `bson:"discount" json:"discount"`
+	TotalPrice         money.Fen          `bson:"total_price" json:"total_price"` // 应付金额（分）
+	Discount           money.Fen          `bson:"discount" json:"discount"`
 	PaymentStatus      string             `bson:"payment_status" json:"payment_status"` // 支付状态
 	PaymentTime        time.Time          `bson:"payment_time" json:"payment_time"`     // 支付时间
 	BuyerAlipayAccount string             `bson:"buyer_alipay_account" json:"buyer_alipay_account"`
 	CreatedAt          time.Time          `bson:"created_at" json:"created_at"`
 	IsRedeemed         bool               `bson:"is_redeemed" json:"is_redeemed"`
+	PowAward           *PowAward          `bson:"pow_award,omitempty" json:"pow_award,omitempty"` // 本单发放的权证及命中规则
+	// 下单时报价的金额明细，TotalPrice 为应付金额
+	Subtotal     money.Fen    `bson:"subtotal" json:"subtotal"`                               // 商品金额
+	ShippingFee  money.Fen    `bson:"shipping_fee" json:"shipping_fee"`                       // 运费
+	PowOffset    float64      `bson:"pow_offset,omitempty" json:"pow_offset,omitempty"`       // 抵扣使用的 Pow，未支付订单关闭时退回
+	PowDeduction money.Fen    `bson:"pow_deduction,omitempty" json:"pow_deduction,omitempty"` // Pow 抵扣的金额
+	QuoteID      string       `bson:"quote_id,omitempty" json:"quote_id,omitempty"`           // 下单使用的报价，每个报价只能下单一次
+	FxRate       *OrderFxRate `bson:"fx_rate,omitempty" json:"fx_rate,omitempty"`             // 下单时选择外币展示时使用的汇率
+	AmountUnit   string       `bson:"amount_unit,omitempty" json:"-"`                         // 金额单位，见 AmountUnitFen
 }
 
+// AmountUnitFen 以分保存金额的文档标记，金额迁移时跳过已有该标记的文档
+// 写入金额的插入和更新都需要带上，避免迁移记录丢失后重复转换
+const AmountUnitFen = "fen"
+
 type OrderItem struct {
 	ProductRef     primitive.ObjectID `bson:"product_ref" json:"product_ref"` // 关联的产品ID
 	Quantity       int                `bson:"quantity" json:"quantity"`
 	Size           string             `bson:"size" json:"size"`
 	Color          string             `bson:"color" json:"color"`
-	Price          uint64             `bson:"price" json:"price"`
+	Price          money.Fen          `bson:"price" json:"price"`
 	DeliverID      string             `bson:"deliver_id" json:"deliverid"`              // 快递单号
 	ShippingStatus string             `bson:"shipping_status" json:"shipping_status"`   // 配送状态
 	AddressItemRef primitive.ObjectID `bson:"address_item_ref" json:"address_item_ref"` // 每个商品的配送地址
+	// 快递公司编码及发货、签收时间
+	Carrier     string    `bson:"carrier" json:"carrier"`
+	ShippedAt   time.Time `bson:"shipped_at,omitempty" json:"shipped_at,omitempty"`
+	DeliveredAt time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	// 下单时的收件地址快照，用户修改或删除地址后订单仍按快照发货
+	ShippingAddress *ShippingAddress `bson:"shipping_address,omitempty" json:"shipping_address,omitempty"`
+}
+
+// ShippingAddress 订单商品的收件地址快照
+type ShippingAddress struct {
+	RecipientName string    `bson:"recipient_name,omitempty" json:"recipient_name,omitempty"`
+	Phone         string    `bson:"phone" json:"phone"`
+	ProvinceCode  string    `bson:"province_code,omitempty" json:"province_code,omitempty"`
+	Province      string    `bson:"province,omitempty" json:"province,omitempty"`
+	CityCode      string    `bson:"city_code,omitempty" json:"city_code,omitempty"`
+	City          string    `bson:"city" json:"city"`
+	DistrictCode  string    `bson:"district_code,omitempty" json:"district_code,omitempty"`
+	District      string    `bson:"district,omitempty" json:"district,omitempty"`
+	Detail        string    `bson:"detail,omitempty" json:"detail,omitempty"`
+	ZipCode       string    `bson:"zip_code" json:"zip_code"`
+	CapturedAt    time.Time `bson:"captured_at" json:"captured_at"` // 快照时间，回填的旧订单为迁移时间
+	// 旧版地址格式的快照
+	FirstName string `bson:"first_name,omitempty" json:"first_name,omitempty"`
+	LastName  string `bson:"last_name,omitempty" json:"last_name,omitempty"`
+	Street    string `bson:"street,omitempty" json:"street,omitempty"`
+	State     string `bson:"state,omitempty" json:"state,omitempty"`
+}
+
+// 收件人姓名
+func (a *ShippingAddress) Receiver() string {
+	if a.RecipientName != "" {
+		return a.RecipientName
+	}
+	return a.FirstName + " " + a.LastName
+}
+
+// 完整收件地址
+func (a *ShippingAddress) FullAddress() string {
+	if a.Detail != "" {
+		city := a.City
+		// 直辖市和省直辖县的地级名称不是真实地名
+		if city == "市辖区" || city == "县" || strings.HasSuffix(city, "直辖县级行政区划") {
+			city = ""
+		}
+		return a.Province + city + a.District + a.Detail
+	}
+	return a.State + ", " + a.City + ", " + a.Street + " " + a.ZipCode
+}
+
+// OrderAddressAudit 管理员修改订单收件地址快照的记录
+type OrderAddressAudit struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrderRef       primitive.ObjectID `bson:"order_ref" json:"order_ref"`
+	ProductRef     primitive.ObjectID `bson:"product_ref" json:"product_ref"`
+	Size           string             `bson:"size" json:"size"`
+	Color          string             `bson:"color" json:"color"`
+	AddressItemRef primitive.ObjectID `bson:"address_item_ref" json:"address_item_ref"` // 商品所属包裹的地址
+	AdminRef       primitive.ObjectID `bson:"admin_ref" json:"admin_ref"`               // 操作的管理员
+	Before         *ShippingAddress   `bson:"before" json:"before"`                     // 修改前的快照，旧订单未回填时为空
+	After          ShippingAddress    `bson:"after" json:"after"`
+	Reason         string             `bson:"reason" json:"reason"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
 }
 
+// 订单商品配送状态
+const (
+	ShippingPending   = "待发货"
+	ShippingShipped   = "已发货"
+	ShippingDelivered = "已签收"
+)
+
 type Product struct {
 	ID          primitive.ObjectID `bson:"_id"`
 	Name        string             `json:"name"`
 	Description string             `json:"description"`
 	SizeColors  []SizeColor        `json:"size_colors"` // 存储尺寸和颜色的对应关系
-	Price       uint64             `json:"price"`
+	Price       money.Fen          `json:"price"`       // 单价（分）
 	CreatedAt   time.Time          `json:"created_at"`
 	Rating      float64            `json:"rating"`                       // 平均评分
 	Images      []Image            `json:"images"`                       // 图片URL数组
 	Categories  []CategoryRef      `json:"categories" bson:"categories"` // 产品分类引用列表
 	Inventory   int                `json:"inventory"`                    // 库存数量
+	// 运费计算使用的重量和模板，未关联模板时使用默认模板
+	Weight              int                `json:"weight"` // 单件重量（克）
+	ShippingTemplateRef primitive.ObjectID `json:"shipping_template_ref" bson:"shipping_template_ref,omitempty"`
+	Version             int64              `json:"version"` // 每次编辑加 1，用于检测并发修改
+	// 上下架状态，旧数据没有状态时视为已发布
+	Status      string     `json:"status" bson:"status,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty" bson:"publish_at,omitempty"`     // 定时上架，之前前台不可见
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty" bson:"unpublish_at,omitempty"` // 定时下架，到期后改为草稿
+	ArchivedAt  *time.Time `json:"archived_at,omitempty" bson:"archived_at,omitempty"`   // 删除时间，删除只归档，历史订单仍可查到商品
+	AmountUnit  string     `json:"-" bson:"amount_unit,omitempty"`                       // 金额单位，见 AmountUnitFen
+}
+
+// 商品状态
+const (
+	ProductDraft     = "draft"     // 草稿，前台不可见
+	ProductPublished = "published" // 已发布
+	ProductArchived  = "archived"  // 已删除（归档），前台不可见也不能购买
+)
+
+// Visible 商品当前是否在前台展示并可以购买
+func (p *Product) Visible(now time.Time) bool {
+	if p.Status != "" && p.Status != ProductPublished {
+		return false
+	}
+	if p.PublishAt != nil && p.PublishAt.After(now) {
+		return false
+	}
+	return p.UnpublishAt == nil || p.UnpublishAt.After(now)
 }
 
 type Image struct {
@@ -79,6 +202,12 @@ type Image struct {
 	Type      string `json:"type"`       // 图片类型，例如："main", "color_variant", "introductory"
 	Color     string `json:"color"`      // 如果图片是颜色变体，这里存储颜色信息
 	MainImage bool   `json:"main_image"` // 标记这张图片是否为主视图
+	// 图片处理生成的版本，旧数据中为空
+	Thumbnail string `json:"thumbnail,omitempty" bson:"thumbnail,omitempty"` // 缩略图URL
+	WebP      string `json:"webp,omitempty" bson:"webp,omitempty"`           // WebP 版本URL，服务器未安装 cwebp 时为空
+	Width     int    `json:"width,omitempty" bson:"width,omitempty"`
+	Height    int    `json:"height,omitempty" bson:"height,omitempty"`
+	Hash      string `json:"hash,omitempty" bson:"hash,omitempty"` // 原始文件的内容哈希
 }
 
 type SizeColor struct {
@@ -86,17 +215,59 @@ type SizeColor struct {
 	Colors []string `json:"colors"`
 }
 
+// 赎回订单状态
+const (
+	RedemptionPending  = "pending"  // 待审核，Pow 已冻结
+	RedemptionApproved = "approved" // 已审核，等待打款
+	RedemptionPaidOut  = "paid_out" // 已打款，冻结的 Pow 已扣除
+	RedemptionRejected = "rejected" // 已拒绝，冻结的 Pow 已退回
+)
+
 type RedemptionOrder struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserRef        primitive.ObjectID `bson:"user_ref" json:"user_ref"`         // 关联的用户ID
 	OrderRef       primitive.ObjectID `bson:"order_ref" json:"order_ref"`       // 关联的原始订单ID
 	IsSubmitted    bool               `bson:"is_submitted" json:"is_submitted"` // 是否已提交
-	Status         string             `bson:"status" json:"status"`             // 赎回状态，如：待处理、已完成、已取消
+	Status         string             `bson:"status" json:"status"`             // 赎回状态：pending、approved、paid_out、rejected
+	PowAmount      float64            `bson:"pow_amount" json:"pow_amount"`     // 创建时冻结的 Pow 数量
+	RejectReason   string             `bson:"reject_reason" json:"reject_reason"`
 	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
 	AlipayUsername string             `bson:"alipay_username" json:"alipay_username"` // 支付宝用户名
 	AlipayAccount  string             `bson:"alipay_account" json:"alipay_account"`   // 支付宝账户
 	WalletAddress  string             `bson:"wallet_address" json:"wallet_address"`   // 钱包地址
 	Hash           string             `bson:"hash" json:"hash"`                       // 哈希
+	// 链上交易核验结果
+	Verification *ChainVerification `bson:"verification,omitempty" json:"verification,omitempty"`
+	// 支付宝打款结果
+	Payout *RedemptionPayout `bson:"payout,omitempty" json:"payout,omitempty"`
+}
+
+// RedemptionPayout 赎回订单的支付宝转账记录
+type RedemptionPayout struct {
+	OutBizNo       string    `bson:"out_biz_no" json:"out_biz_no"`               // 商户转账单号，使用赎回订单ID保证幂等
+	OrderID        string    `bson:"order_id" json:"order_id"`                   // 支付宝转账订单号
+	PayFundOrderID string    `bson:"pay_fund_order_id" json:"pay_fund_order_id"` // 支付宝资金流水号
+	Status         string    `bson:"status" json:"status"`                       // SUCCESS / FAIL / DEALING
+	Amount         string    `bson:"amount" json:"amount"`                       // 转账金额（元）
+	TransDate      string    `bson:"trans_date" json:"trans_date"`
+	Attempts       int       `bson:"attempts" json:"attempts"` // 调用支付宝接口的次数
+	Error          string    `bson:"error" json:"error"`
+	Sandbox        bool      `bson:"sandbox" json:"sandbox"` // 沙箱模式下未真实打款
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ChainVerification 赎回交易哈希的链上核验结果
+type ChainVerification struct {
+	Verified   bool      `bson:"verified" json:"verified"`
+	Signature  string    `bson:"signature" json:"signature"`
+	Slot       uint64    `bson:"slot" json:"slot"`
+	FromWallet string    `bson:"from_wallet" json:"from_wallet"`
+	ToWallet   string    `bson:"to_wallet" json:"to_wallet"`
+	Expected   float64   `bson:"expected" json:"expected"` // 期望转入的 SCL 数量
+	Amount     float64   `bson:"amount" json:"amount"`     // 实际转入金库的 SCL 数量
+	Error      string    `bson:"error" json:"error"`       // 核验失败原因
+	CheckedAt  time.Time `bson:"checked_at" json:"checked_at"`
 }
 
 type Category struct {
@@ -110,7 +281,7 @@ type Payment struct {
 	OrderRef primitive.ObjectID `bson:"order_ref"` // 关联的订单ID
 	Method   string             `json:"method"`    // 支付方式，如信用卡、PayPal、COD等
 	Status   string             `json:"status"`    // 支付状态，如成功、失败等
-	Amount   uint64             `json:"amount"`
+	Amount   money.Fen          `json:"amount"`
 }
 
 type Permissions struct {
@@ -121,6 +292,8 @@ type Cart struct {
 	ID        primitive.ObjectID `bson:"_id"`
 	UserRef   primitive.ObjectID `bson:"user_ref"` // 关联的用户ID
 	CartItems []CartItem         `bson:"items"`
+	// 已合并过的游客购物车ID，重复提交同一游客购物车时不再合并
+	MergedGuestCarts []string `bson:"merged_guest_carts,omitempty"`
 }
 
 type CartItem struct {
@@ -156,7 +329,7 @@ type CategoryRef struct {
 type ProductRef struct {
 	ID    primitive.ObjectID `bson:"_id"`
 	Name  string             `json:"name"`
-	Price uint64             `json:"price"`
+	Price money.Fen          `json:"price"`
 }
 type OrderRef struct {
 	ID        primitive.ObjectID `bson:"_id"`
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 访客行为事件
+const (
+	EventPageView      = "page_view"      // 页面浏览
+	EventProductView   = "product_view"   // 商品详情浏览
+	EventAddToCart     = "add_to_cart"    // 加入购物车
+	EventCheckoutStart = "checkout_start" // 进入结算
+)
+
+// VisitorHourlyStat 每小时访客统计，由 Redis 计数定时汇总
+// ProductRef 为空表示该事件的整体统计，否则为单个商品的统计
+type VisitorHourlyStat struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Hour       time.Time          `bson:"hour" json:"hour"` // 整点时间（UTC）
+	Event      string             `bson:"event" json:"event"`
+	ProductRef primitive.ObjectID `bson:"product_ref" json:"product_ref"`
+	Count      int64              `bson:"count" json:"count"`   // 事件次数
+	Unique     int64              `bson:"unique" json:"unique"` // 去重访客数（HyperLogLog 估算）
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,190 @@
+// Package money 人民币金额，统一以分为单位的整数保存和计算，避免元/分混用和浮点误差
+//
+// 取整规则：
+//   - 元转分（Parse、FromYuan）按十进制表示在分位四舍五入，0.5 分远离零舍入，如 "1.005" 为 101 分、"-1.005" 为 -101 分
+//   - 按比例计算（MulRate）同样四舍五入到分，FloorRate 向下取整到分，用于抵扣等不能多给的场景
+//
+// 接口中的金额始终以元表示：JSON 输出为两位小数的数字，输入的数字和字符串、表单和查询参数都按元解析
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fen 以分为单位的金额
+type Fen int64
+
+const (
+	Cent Fen = 1
+	Yuan Fen = 100
+)
+
+var ErrInvalidAmount = errors.New("无效的金额")
+
+// Parse 解析以元为单位的金额字符串，如支付宝返回的 "12.34"
+func Parse(s string) (Fen, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, orig)
+	}
+
+	var yuan int64
+	if intPart != "" {
+		v, err := strconv.ParseInt(intPart, 10, 64)
+		if err != nil || v > math.MaxInt64/100-1 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, orig)
+		}
+		yuan = v
+	}
+	// 取两位小数，第三位决定是否进位
+	cents := int64(0)
+	for i := 0; i < 2; i++ {
+		cents *= 10
+		if i < len(fracPart) {
+			cents += int64(fracPart[i] - '0')
+		}
+	}
+	if len(fracPart) > 2 && fracPart[2] >= '5' {
+		cents++
+	}
+
+	amount := Fen(yuan*100 + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromYuan 将以元为单位的浮点数转为分，按十进制表示四舍五入，避免 1.005*100 得到 100.49999 的问题
+func FromYuan(yuan float64) Fen {
+	if math.IsNaN(yuan) || math.IsInf(yuan, 0) {
+		return 0
+	}
+	amount, err := Parse(strconv.FormatFloat(yuan, 'f', -1, 64))
+	if err != nil {
+		return Fen(math.Round(yuan * 100))
+	}
+	return amount
+}
+
+// String 格式化为以元为单位、保留两位小数的字符串，即支付宝接口使用的格式
+func (f Fen) String() string {
+	sign := ""
+	v := int64(f)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Yuan 转为以元为单位的浮点数，只用于展示、统计和 Pow 等以元计价的换算
+func (f Fen) Yuan() float64 {
+	return float64(f) / 100
+}
+
+// Mul 单价乘以数量
+func (f Fen) Mul(quantity int) Fen {
+	return f * Fen(quantity)
+}
+
+// MulRate 按比例计算，四舍五入到分
+func (f Fen) MulRate(rate float64) Fen {
+	return Fen(math.Round(float64(f) * rate))
+}
+
+// FloorRate 按比例计算，向下取整到分
+func (f Fen) FloorRate(rate float64) Fen {
+	return Fen(math.Floor(float64(f) * rate))
+}
+
+// Min 返回较小的金额
+func Min(a, b Fen) Fen {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Sum 金额求和
+func Sum(amounts ...Fen) Fen {
+	var total Fen
+	for _, a := range amounts {
+		total += a
+	}
+	return total
+}
+
+// MarshalJSON 输出以元为单位、保留两位小数的数字，如 19.90
+func (f Fen) MarshalJSON() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalJSON 数字和字符串都按元解析，如 99、19.9、"19.90"
+func (f *Fen) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	amount, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	*f = amount
+	return nil
+}
+
+// UnmarshalText 表单和查询参数中的金额按元解析
+func (f *Fen) UnmarshalText(text []byte) error {
+	amount, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*f = amount
+	return nil
+}
+
+// Convert 按汇率（1 元人民币兑换的外币数量）换算为外币，返回外币最小单位的整数，decimals 为外币的小数位数，四舍五入
+func (f Fen) Convert(rate float64, decimals int) int64 {
+	return int64(math.Round(float64(f) / 100 * rate * math.Pow10(decimals)))
+}
+
+// FormatMinor 将外币最小单位的整数格式化为带小数的字符串，如 1234 和 2 位小数为 "12.34"
+func FormatMinor(minor int64, decimals int) string {
+	if decimals <= 0 {
+		return strconv.FormatInt(minor, 10)
+	}
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	unit := int64(math.Pow10(decimals))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/unit, decimals, minor%unit)
+}
@@ -0,0 +1,219 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Fen
+	}{
+		{"0", 0},
+		{"12", 1200},
+		{"12.3", 1230},
+		{"12.34", 1234},
+		{" 12.34 ", 1234},
+		{"+1.5", 150},
+		{".5", 50},
+		{"5.", 500},
+		{"0.5", 50},
+		{"0.004", 0},
+		{"0.005", 1},
+		{"1.004", 100},
+		{"1.005", 101}, // 第三位小数 5 进位
+		{"1.00999", 101},
+		{"-1.004", -100},
+		{"-1.005", -101}, // 远离零舍入
+		{"-0.5", -50},
+		{"99999999.99", 9999999999},
+	}
+	for _, tc := range cases {
+		got, err := Parse(tc.in)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Parse(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, in := range []string{"", " ", ".", "-", "+", "abc", "1.2.3", "1,000", "1e2", "--1", "1.-5", "¥10", "92233720368547758.07"} {
+		if got, err := Parse(in); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("Parse(%q) = %d, %v; want ErrInvalidAmount", in, got, err)
+		}
+	}
+}
+
+func TestFromYuan(t *testing.T) {
+	cases := []struct {
+		in   float64
+		want Fen
+	}{
+		{0, 0},
+		{1.005, 101}, // 1.005*100 为 100.49999...，按十进制表示仍进位
+		{-1.005, -101},
+		{0.1 + 0.2, 30},
+		{19.99, 1999},
+		{2.675, 268},
+		{1e-9, 0},
+		{math.NaN(), 0},
+		{math.Inf(1), 0},
+	}
+	for _, tc := range cases {
+		if got := FromYuan(tc.in); got != tc.want {
+			t.Errorf("FromYuan(%v) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	cases := []struct {
+		in   Fen
+		want string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{10, "0.10"},
+		{1234, "12.34"},
+		{100000, "1000.00"},
+		{-5, "-0.05"},
+		{-1234, "-12.34"},
+	}
+	for _, tc := range cases {
+		if got := tc.in.String(); got != tc.want {
+			t.Errorf("Fen(%d).String() = %q, want %q", tc.in, got, tc.want)
+		}
+		if back, err := Parse(tc.want); err != nil || back != tc.in {
+			t.Errorf("Parse(%q) = %d, %v; want round trip to %d", tc.want, back, err, tc.in)
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	cases := []struct {
+		in          Fen
+		rate        float64
+		round, down Fen
+	}{
+		{1000, 0.15, 150, 150},
+		{999, 0.5, 500, 499}, // 499.5 分：四舍五入进位，向下取整舍去
+		{333, 0.1, 33, 33},
+		{335, 0.1, 34, 33},
+		{1, 0.3, 0, 0},
+		{0, 0.5, 0, 0},
+		{-999, 0.5, -500, -500},
+	}
+	for _, tc := range cases {
+		if got := tc.in.MulRate(tc.rate); got != tc.round {
+			t.Errorf("Fen(%d).MulRate(%v) = %d, want %d", tc.in, tc.rate, got, tc.round)
+		}
+		if got := tc.in.FloorRate(tc.rate); got != tc.down {
+			t.Errorf("Fen(%d).FloorRate(%v) = %d, want %d", tc.in, tc.rate, got, tc.down)
+		}
+	}
+}
+
+func TestConvertAndFormatMinor(t *testing.T) {
+	cases := []struct {
+		in       Fen
+		rate     float64
+		decimals int
+		minor    int64
+		text     string
+	}{
+		{10000, 0.1378, 2, 1378, "13.78"}, // 100 元兑美元
+		{1999, 0.1378, 2, 275, "2.75"},    // 2.754 四舍五入
+		{1999, 20.5, 0, 410, "410"},       // 日元没有小数
+		{100, 0.0451, 3, 45, "0.045"},     // 三位小数的货币
+		{0, 0.1378, 2, 0, "0.00"},
+		{-1999, 0.1378, 2, -275, "-2.75"},
+	}
+	for _, tc := range cases {
+		minor := tc.in.Convert(tc.rate, tc.decimals)
+		if minor != tc.minor {
+			t.Errorf("Fen(%d).Convert(%v, %d) = %d, want %d", tc.in, tc.rate, tc.decimals, minor, tc.minor)
+		}
+		if got := FormatMinor(minor, tc.decimals); got != tc.text {
+			t.Errorf("FormatMinor(%d, %d) = %q, want %q", minor, tc.decimals, got, tc.text)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Fen
+	}{
+		{`99`, 9900}, // 数字按元解析，与旧接口一致
+		{`19.9`, 1990},
+		{`19.99`, 1999},
+		{`1.005`, 101},
+		{`-0.5`, -50},
+		{`"19.99"`, 1999},
+		{`" 7 "`, 700},
+		{`"1.005"`, 101},
+	}
+	for _, tc := range cases {
+		var got Fen
+		if err := json.Unmarshal([]byte(tc.in), &got); err != nil {
+			t.Errorf("Unmarshal(%s) error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{`"abc"`, `1e2`, `true`, `"1.2.3"`, `{}`} {
+		var got Fen
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) = %d, want error", in, got)
+		}
+	}
+
+	// null 不修改原值，用于 PATCH 中未传的字段
+	amount := Fen(500)
+	if err := json.Unmarshal([]byte(`null`), &amount); err != nil || amount != 500 {
+		t.Errorf("Unmarshal(null) = %d, %v; want 500 unchanged", amount, err)
+	}
+
+	// 输出为以元为单位的数字，可以原样解析回来
+	data, err := json.Marshal(struct {
+		Price    Fen  `json:"price"`
+		Discount Fen  `json:"discount"`
+		Refund   *Fen `json:"refund"`
+	}{Price: 1999, Discount: -5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"price":19.99,"discount":-0.05,"refund":null}` {
+		t.Errorf("Marshal = %s", data)
+	}
+	var back struct {
+		Price    Fen `json:"price"`
+		Discount Fen `json:"discount"`
+	}
+	if err := json.Unmarshal(data, &back); err != nil || back.Price != 1999 || back.Discount != -5 {
+		t.Errorf("round trip = %+v, %v", back, err)
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	var f Fen
+	if err := f.UnmarshalText([]byte("12.345")); err != nil || f != 1235 {
+		t.Errorf("UnmarshalText(12.345) = %d, %v; want 1235", f, err)
+	}
+	if err := f.UnmarshalText([]byte("12")); err != nil || f != 1200 {
+		t.Errorf("UnmarshalText(12) = %d, %v; want 1200", f, err)
+	}
+	if err := f.UnmarshalText([]byte("twelve")); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("UnmarshalText(twelve) error = %v, want ErrInvalidAmount", err)
+	}
+}
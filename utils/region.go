@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 随代码发布的行政区划数据，行政区划调整后可通过环境变量 REGION_DATA_FILE 指定新数据
+//
+//go:embed gb2260.txt
+var bundledRegions []byte
+
+// Region 行政区划（省、市、区县），代码为 GB/T 2260 六位代码
+type Region struct {
+	Code     string    `json:"code"`
+	Name     string    `json:"name"`
+	Children []*Region `json:"-"`
+}
+
+// 行政区划层级
+const (
+	RegionProvince = 1
+	RegionCity     = 2
+	RegionDistrict = 3
+)
+
+var (
+	regionOnce  sync.Once
+	regionIndex map[string]*Region
+	provinces   []*Region
+)
+
+// 直辖市的地级行政区在数据中通常为“市辖区”
+var municipalities = map[string]bool{"11": true, "12": true, "31": true, "50": true}
+
+// 根据代码判断层级：省级后四位为0，地级后两位为0
+func RegionLevel(code string) int {
+	switch {
+	case len(code) != 6:
+		return 0
+	case strings.HasSuffix(code, "0000"):
+		return RegionProvince
+	case strings.HasSuffix(code, "00"):
+		return RegionCity
+	default:
+		return RegionDistrict
+	}
+}
+
+// 上级行政区代码
+func parentRegionCode(code string) string {
+	switch RegionLevel(code) {
+	case RegionCity:
+		return code[:2] + "0000"
+	case RegionDistrict:
+		return code[:4] + "00"
+	}
+	return ""
+}
+
+// 解析“代码 名称”格式的数据，# 开头为注释
+func parseRegions(data []byte) (map[string]*Region, []*Region, error) {
+	index := make(map[string]*Region)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || RegionLevel(fields[0]) == 0 {
+			return nil, nil, fmt.Errorf("第 %d 行格式错误: %s", line, text)
+		}
+		index[fields[0]] = &Region{Code: fields[0], Name: strings.Join(fields[1:], " ")}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// 按代码顺序挂到上级，缺少地级行政区的区县挂到补出的“市辖区”或“省直辖县级行政区划”下
+	codes := make([]string, 0, len(index))
+	for code := range index {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	var roots []*Region
+	for _, code := range codes {
+		region := index[code]
+		parentCode := parentRegionCode(code)
+		if parentCode == "" {
+			roots = append(roots, region)
+			continue
+		}
+		parent, ok := index[parentCode]
+		if !ok && RegionLevel(parentCode) == RegionCity {
+			if _, ok := index[code[:2]+"0000"]; ok {
+				name := "省直辖县级行政区划"
+				if municipalities[code[:2]] {
+					name = "市辖区"
+				}
+				parent = &Region{Code: parentCode, Name: name}
+				index[parentCode] = parent
+				grand := index[code[:2]+"0000"]
+				grand.Children = append(grand.Children, parent)
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("行政区划 %s 缺少上级 %s", code, parentCode)
+		}
+		parent.Children = append(parent.Children, region)
+	}
+	return index, roots, nil
+}
+
+// 首次使用时加载行政区划数据
+func loadRegions() {
+	data := bundledRegions
+	if path := os.Getenv("REGION_DATA_FILE"); path != "" {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("读取行政区划数据 %s 失败，使用内置数据: %v", path, err)
+		} else {
+			data = file
+		}
+	}
+	index, roots, err := parseRegions(data)
+	if err != nil && !bytes.Equal(data, bundledRegions) {
+		log.Printf("解析行政区划数据失败，使用内置数据: %v", err)
+		index, roots, err = parseRegions(bundledRegions)
+	}
+	if err != nil {
+		log.Printf("解析内置行政区划数据失败: %v", err)
+		index = map[string]*Region{}
+	}
+	regionIndex, provinces = index, roots
+}
+
+// LookupRegion 按代码查询行政区划
+func LookupRegion(code string) *Region {
+	regionOnce.Do(loadRegions)
+	return regionIndex[code]
+}
+
+// RegionChildren 下级行政区划，code 为空时返回全部省级行政区
+func RegionChildren(code string) ([]*Region, bool) {
+	regionOnce.Do(loadRegions)
+	if code == "" {
+		return provinces, true
+	}
+	region, ok := regionIndex[code]
+	if !ok {
+		return nil, false
+	}
+	return region.Children, true
+}
+
+// ResolveRegions 校验省、市、区县代码的从属关系，返回对应的名称
+// 上级在数据中没有下级时（如东莞市、港澳台），下级代码可以为空
+func ResolveRegions(provinceCode, cityCode, districtCode string) (province, city, district string, err error) {
+	regionOnce.Do(loadRegions)
+	parentCode := ""
+	names := make([]string, 0, 3)
+	for level, code := range []string{provinceCode, cityCode, districtCode} {
+		var siblings []*Region
+		if parentCode == "" {
+			siblings = provinces
+		} else {
+			siblings = regionIndex[parentCode].Children
+		}
+		if code == "" {
+			if len(siblings) > 0 {
+				return "", "", "", fmt.Errorf("请选择%s", []string{"省份", "城市", "区县"}[level])
+			}
+			names = append(names, "")
+			continue
+		}
+		region, ok := regionIndex[code]
+		if !ok || RegionLevel(code) != level+1 || parentRegionCode(code) != parentCode {
+			return "", "", "", fmt.Errorf("无效的%s代码: %s", []string{"省份", "城市", "区县"}[level], code)
+		}
+		names = append(names, region.Name)
+		parentCode = code
+	}
+	return names[0], names[1], names[2], nil
+}
+
+// MatchRegion 在上级行政区的下级中按名称模糊匹配，用于迁移旧的文本地址
+// 名称可以省略“省”“市”“区”等后缀，parentCode 为空时匹配省级
+func MatchRegion(parentCode, name string) *Region {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	children, ok := RegionChildren(parentCode)
+	if !ok {
+		return nil
+	}
+	for _, region := range children {
+		if region.Name == name {
+			return region
+		}
+	}
+	// 去掉后缀后至少保留两个字，避免“市”“区”之类的误匹配
+	short := strings.TrimRight(name, "省市区县")
+	if len([]rune(short)) < 2 {
+		return nil
+	}
+	for _, region := range children {
+		if strings.HasPrefix(region.Name, short) {
+			return region
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import "testing"
+
+func TestResolveRegions(t *testing.T) {
+	cases := []struct {
+		name                         string
+		province, city, code         string
+		wantProvince, wantCity, want string
+		wantErr                      bool
+	}{
+		{"municipality district", "110000", "110100", "110101", "北京市", "市辖区", "东城区", false},
+		{"prefecture district", "320000", "320200", "320213", "江苏省", "无锡市", "梁溪区", false},
+		{"county-level city", "330000", "330100", "330182", "浙江省", "杭州市", "建德市", false},
+		{"directly administered county", "420000", "429000", "429004", "湖北省", "省直辖县级行政区划", "仙桃市", false},
+		{"city without districts", "440000", "441900", "", "广东省", "东莞市", "", false},
+		{"missing district", "320000", "320200", "", "", "", "", true},
+		{"unlisted district", "320000", "320200", "320299", "", "", "", true},
+		{"district outside city", "320000", "320100", "320213", "", "", "", true},
+		{"city outside province", "320000", "440100", "440103", "", "", "", true},
+		{"missing city", "320000", "", "", "", "", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			province, city, district, err := ResolveRegions(tc.province, tc.city, tc.code)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ResolveRegions = %q %q %q, want error", province, city, district)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveRegions: %v", err)
+			}
+			if province != tc.wantProvince || city != tc.wantCity || district != tc.want {
+				t.Errorf("ResolveRegions = %q %q %q, want %q %q %q", province, city, district, tc.wantProvince, tc.wantCity, tc.want)
+			}
+		})
+	}
+}
+
+// 内置数据应包含全部县级行政区，且每个区县都能挂到上级
+func TestBundledRegions(t *testing.T) {
+	index, roots, err := parseRegions(bundledRegions)
+	if err != nil {
+		t.Fatalf("parseRegions: %v", err)
+	}
+	if len(roots) != 34 {
+		t.Errorf("got %d provinces, want 34", len(roots))
+	}
+	districts := 0
+	for code := range index {
+		if RegionLevel(code) == RegionDistrict {
+			districts++
+		}
+	}
+	if districts < 2800 {
+		t.Errorf("got %d districts, want the complete county-level list", districts)
+	}
+}